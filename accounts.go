@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// activeStatuses matches active accounts in a query, including ones from before accounts had
+// a status
+var activeStatuses = bson.M{"$in": []interface{}{nil, UserActive}}
+
+type inactiveUsersKey struct{}
+
+// WithInactiveUsers returns a context whose user lookups also find hidden, deactivated,
+// deleted and suspended users. Admins see everyone
+func WithInactiveUsers(ctx context.Context) context.Context {
+	return context.WithValue(ctx, inactiveUsersKey{}, true)
+}
+
+// visibleUsers limits a users filter to active accounts, unless the context includes inactive ones
+func visibleUsers(ctx context.Context, filter bson.M) bson.M {
+	if include, _ := ctx.Value(inactiveUsersKey{}).(bool); !include {
+		filter["status"] = activeStatuses
+	}
+	return filter
+}
+
+// userVisible tells if a user would be found by a lookup with ctx, see visibleUsers
+func userVisible(ctx context.Context, u *User) bool {
+	include, _ := ctx.Value(inactiveUsersKey{}).(bool)
+	return include || u.Active()
+}
+
+// SelfServiceStatuses are the statuses users can move their own account to
+var SelfServiceStatuses = map[UserStatus]bool{
+	UserActive:      true,
+	UserHidden:      true,
+	UserDeactivated: true,
+	UserDeleted:     true,
+}
+
+// AccountStatusError is returned when a user can't move their account to a status
+type AccountStatusError struct {
+	msg string
+}
+
+func (e *AccountStatusError) Error() string {
+	return e.msg
+}
+
+// checkStatusChange tells if a user can move their own account from its current status to
+// status. Deleted accounts can only be reactivated within the grace period
+func checkStatusChange(u *User, status UserStatus, now time.Time, grace time.Duration) error {
+	switch {
+	case u.Suspended():
+		return &AccountStatusError{"account is suspended"}
+	case u.Status == UserDeleted && status != UserDeleted:
+		if status != UserActive {
+			return &AccountStatusError{"a deleted account can only be reactivated"}
+		}
+		if u.DeletedDate != nil && !now.Before(u.DeletedDate.Add(grace)) {
+			return &AccountStatusError{"account was deleted and can no longer be reactivated"}
+		}
+	}
+
+	return nil
+}
+
+// ChangeAccountStatus moves a user's own account to status, if they're allowed to, and
+// returns the updated user. Returns nil if there is no such user
+func ChangeAccountStatus(ctx context.Context, db *DB, id string, status UserStatus, now time.Time, grace time.Duration) (*User, error) {
+	u, err := FindCurrentAccount(ctx, db, id)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	if err := checkStatusChange(u, status, now, grace); err != nil {
+		return nil, err
+	}
+
+	// deleting again would restart the grace period
+	if u.Status == status || (u.Active() && status == UserActive) {
+		return u, nil
+	}
+
+	if _, err := SetUserStatus(ctx, db, id, status, "", now); err != nil {
+		return nil, err
+	}
+
+	return FindAccount(ctx, db, id)
+}
+
+// PurgeDeletedUsers erases users whose deletion grace period is over, see EraseUser. Returns
+// how many users were removed
+func PurgeDeletedUsers(ctx context.Context, db *DB, blobs BlobStore, now time.Time, grace time.Duration) (int, error) {
+	users := db.MongoClient.Collection("users")
+
+	filter := bson.M{
+		"status":      UserDeleted,
+		"deletedDate": bson.M{"$lt": now.Add(-grace)},
+	}
+
+	cur, err := users.Find(ctx, filter)
+	if err != nil {
+		return 0, NewErrorf("error finding users to purge: %s", err)
+	}
+
+	ids := make([]string, 0)
+	for cur.Next(ctx) {
+		var u User
+		if err := cur.Decode(&u); err != nil {
+			cur.Close(ctx)
+			return 0, NewErrorf("error decoding into user struct: %s", err)
+		}
+		ids = append(ids, string(u.ID))
+	}
+	cur.Close(ctx)
+
+	purged := 0
+	for _, id := range ids {
+		ok, err := purgeUser(ctx, db, blobs, id)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// erase a user whose grace period is over, unless they were reactivated in the meantime. The
+// user goes last, so one whose data failed to erase is found and purged again on the next run
+func purgeUser(ctx context.Context, db *DB, blobs BlobStore, id string) (bool, error) {
+	u, err := FindCurrentAccount(ctx, db, id)
+	if err != nil {
+		return false, err
+	}
+	if u == nil || u.Status != UserDeleted {
+		return false, nil
+	}
+
+	if _, err := eraseUserData(ctx, db, blobs, id, "user.purge"); err != nil {
+		return false, err
+	}
+
+	res, err := db.MongoClient.Collection("users").DeleteOne(ctx, bson.M{"_id": DocID(id), "status": UserDeleted})
+	if err != nil {
+		return false, NewErrorf("error purging user %s: %s", id, err)
+	}
+	db.forgetUsers(ctx, id)
+
+	return res.DeletedCount > 0, nil
+}
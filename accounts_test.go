@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_checkStatusChange(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)}
+	deleted := clock.Now()
+	grace := 30 * 24 * time.Hour
+
+	clock.Advance(29 * 24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		user    *User
+		status  UserStatus
+		wantErr string
+	}{
+		{"hide", &User{}, UserHidden, ""},
+		{"reactivate deactivated", &User{Status: UserDeactivated}, UserActive, ""},
+		{"reactivate in grace period", &User{Status: UserDeleted, DeletedDate: &deleted}, UserActive, ""},
+		{"hide deleted", &User{Status: UserDeleted, DeletedDate: &deleted}, UserHidden, "a deleted account can only be reactivated"},
+		{"suspended", &User{Status: UserSuspended}, UserActive, "account is suspended"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStatusChange(tt.user, tt.status, clock.Now(), grace)
+			if tt.wantErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	clock.Advance(24 * time.Hour)
+	err := checkStatusChange(&User{Status: UserDeleted, DeletedDate: &deleted}, UserActive, clock.Now(), grace)
+	assert.EqualError(t, err, "account was deleted and can no longer be reactivated")
+}
+
+func Test_visibleUsers(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, bson.M{"_id": "a", "status": activeStatuses}, visibleUsers(ctx, bson.M{"_id": "a"}))
+	assert.Equal(t, bson.M{"_id": "a"}, visibleUsers(WithInactiveUsers(ctx), bson.M{"_id": "a"}))
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callerHeader identifies the user making an admin request. There is no login yet, so this is
+// set by the proxy that authenticates requests in front of the api, which also sends
+// proxyTokenHeader so the caller can be trusted
+const (
+	callerHeader     = "X-User-Id"
+	proxyTokenHeader = "X-Proxy-Token"
+)
+
+// setupAdminRoutes adds the /admin group when the admin api is on. Moderators can look and
+// suspend, only admins can edit
+func setupAdminRoutes(r gin.IRouter, app *appContext) {
+	if !app.Config.Features.Admin {
+		return
+	}
+
+	admin := r.Group("/admin")
+
+	moderators := admin.Group("", app.requireRole(RoleModerator))
+	moderators.GET("/users", app.adminSearchUsers)
+	moderators.GET("/users/:id/ratings", app.adminUserRatings)
+	moderators.POST("/users/:id/suspend", app.adminSetStatus(UserSuspended))
+	moderators.POST("/users/:id/restore", app.adminSetStatus(UserActive))
+	moderators.GET("/audit", app.adminAudit)
+	moderators.GET("/reviews", app.adminReviews)
+	moderators.POST("/reviews/:reviewId/approve", app.adminResolveReview(ReviewApproved))
+	moderators.POST("/reviews/:reviewId/remove", app.adminResolveReview(ReviewRemoved))
+
+	admins := admin.Group("", app.requireRole(RoleAdmin))
+	admins.PUT("/users/:id", app.adminEditUser)
+	admins.GET("/users/:id/export", app.exportUser)
+	admins.POST("/users/:id/erase", app.adminEraseUser)
+	admins.GET("/exports/users", app.adminExportUsers)
+	admins.GET("/exports/ratings", app.adminExportRatings)
+	admins.POST("/imports/users", app.adminImportUsers)
+	admins.POST("/imports/ratings", app.adminImportRatings)
+	admins.GET("/webhooks", app.adminWebhooks)
+	admins.POST("/webhooks", app.adminCreateWebhook)
+	admins.DELETE("/webhooks/:webhookId", app.adminDeleteWebhook)
+	admins.GET("/webhooks/:webhookId/deliveries", app.adminWebhookDeliveries)
+	admins.POST("/webhooks/:webhookId/replay", app.adminReplayWebhook)
+	admins.GET("/cache/users", app.adminUserCacheStats)
+}
+
+// requireRole only lets through callers with at least the given role, named by a request that
+// came through the proxy. The caller is stored in the context as "actor"
+func (app *appContext) requireRole(min Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := app.Config.Admin.ProxyToken
+		if token == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader(proxyTokenHeader)), []byte(token)) != 1 {
+			errorResponse(c, http.StatusUnauthorized, NewErrorf("missing or invalid %s header", proxyTokenHeader))
+			return
+		}
+
+		id := c.GetHeader(callerHeader)
+		if id == "" {
+			errorResponse(c, http.StatusUnauthorized, NewErrorf("missing %s header", callerHeader))
+			return
+		}
+
+		actor, err := FindCurrentAccount(c.Request.Context(), app.DB, id)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		if actor == nil {
+			errorResponse(c, http.StatusUnauthorized, errors.New("unknown user"))
+			return
+		}
+
+		if !actor.Active() || !actor.HasRole(min) {
+			errorResponse(c, http.StatusForbidden, NewErrorf("requires the %s role", min))
+			return
+		}
+
+		// changes are made by the caller, not the user in the path, and admins see every account
+		ctx := c.Request.Context()
+		caller := &AuditActor{ID: string(actor.ID), RequestID: AuditActorFrom(ctx).RequestID}
+		c.Request = c.Request.WithContext(WithInactiveUsers(WithAuditActor(ctx, caller)))
+
+		c.Set("actor", actor)
+		c.Next()
+	}
+}
+
+// search users by name, location, role and status
+func (app *appContext) adminSearchUsers(c *gin.Context) {
+	s := &UserSearch{
+		Name:     c.Query("name"),
+		Location: c.Query("location"),
+		Role:     Role(c.Query("role")),
+		Status:   UserStatus(c.Query("status")),
+		Limit:    50,
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > 500 {
+			errorResponse(c, http.StatusBadRequest, errors.New("limit must be a number from 1 to 500"))
+			return
+		}
+		s.Limit = n
+	}
+
+	if s.Role != "" && !ValidRole(s.Role) {
+		errorResponse(c, http.StatusBadRequest, errors.New("role must be one of user, moderator, admin"))
+		return
+	}
+
+	users, err := SearchUsers(c.Request.Context(), app.DB, s)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": version(c).Accounts(users),
+	})
+	return
+}
+
+// every rating a user has given and received, of any type
+func (app *appContext) adminUserRatings(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	outgoing, err := FindRatings(ctx, app.DB, &RatingParams{Filter: &Rating{FromUserID: id}})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	incoming, err := FindRatings(ctx, app.DB, &RatingParams{Filter: &Rating{ToUserID: id}})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"incoming": version(c).Ratings(incoming),
+			"outgoing": version(c).Ratings(outgoing),
+		},
+	})
+	return
+}
+
+// suspend or restore an account. An optional json body can give a reason
+func (app *appContext) adminSetStatus(status UserStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && err != io.EOF {
+			errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
+			return
+		}
+
+		before, err := SetUserStatus(c.Request.Context(), app.DB, c.Param("id"), status, body.Reason, app.Clock.Now())
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		if before == nil {
+			errorResponse(c, http.StatusNotFound, errors.New("user not found"))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// edit any profile, including the user's role
+func (app *appContext) adminEditUser(c *gin.Context) {
+	var body AdminUserUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		if err == io.EOF {
+			errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body. allowed one of more fields: age, bio, jobTitle, location, name, notifications, role"))
+			return
+		}
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
+		return
+	}
+
+	if body.Role != "" && !ValidRole(body.Role) {
+		errorResponse(c, http.StatusBadRequest, errors.New("role must be one of user, moderator, admin"))
+		return
+	}
+
+	if err := body.Validate(); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// status and photos have their own endpoints, and the created date never changes
+	u := body.User(c.Param("id"))
+
+	user, err := u.Edit(c.Request.Context(), app.DB, app.Clock.Now())
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if user == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": version(c).Account(user),
+	})
+	return
+}
+
+// search the audit log by action, actor, target, request and time
+func (app *appContext) adminAudit(c *gin.Context) {
+	p := &AuditParams{
+		Action:    c.Query("action"),
+		ActorID:   c.Query("actorId"),
+		Limit:     100,
+		RequestID: c.Query("requestId"),
+		TargetID:  c.Query("targetId"),
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &p.From, "to": &p.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				errorResponse(c, http.StatusBadRequest, NewErrorf("%s must be an RFC 3339 time", name))
+				return
+			}
+			*dst = t
+		}
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > 1000 {
+			errorResponse(c, http.StatusBadRequest, errors.New("limit must be a number from 1 to 1000"))
+			return
+		}
+		p.Limit = n
+	}
+
+	entries, err := FindAudit(c.Request.Context(), app.DB, p)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": entries,
+	})
+	return
+}
+
+// content the filter flagged for review, oldest first
+func (app *appContext) adminReviews(c *gin.Context) {
+	status := ReviewStatus(c.DefaultQuery("status", string(ReviewPending)))
+
+	var limit int64 = 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > 1000 {
+			errorResponse(c, http.StatusBadRequest, errors.New("limit must be a number from 1 to 1000"))
+			return
+		}
+		limit = n
+	}
+
+	reviews, err := FindReviews(c.Request.Context(), app.DB, status, limit)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": reviews,
+	})
+	return
+}
+
+// approve flagged content, or remove it from the profile or rating it was in
+func (app *appContext) adminResolveReview(status ReviewStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := c.MustGet("actor").(*User)
+
+		review, err := ResolveReview(c.Request.Context(), app.DB, c.Param("reviewId"), status, string(actor.ID), app.Clock.Now())
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		if review == nil {
+			errorResponse(c, http.StatusNotFound, errors.New("no pending review found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": review,
+		})
+	}
+}
+
+// erase a user and everything about them for a right to erasure request. This can't be undone
+func (app *appContext) adminEraseUser(c *gin.Context) {
+	result, err := EraseUser(c.Request.Context(), app.DB, app.Blobs, c.Param("id"))
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if result == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": result,
+	})
+	return
+}
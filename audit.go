@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry records who changed what, and how. Entries are only ever added
+type AuditEntry struct {
+	Action      string                  `json:"action,omitempty" bson:"action,omitempty"`
+	ActorID     string                  `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	Changes     map[string]*FieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	CreatedDate time.Time               `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
+	Details     bson.M                  `json:"details,omitempty" bson:"details,omitempty"`
+	ID          DocID                   `json:"id,omitempty" bson:"_id,omitempty"`
+	RequestID   string                  `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	TargetID    string                  `json:"targetId,omitempty" bson:"targetId,omitempty"`
+	TargetType  string                  `json:"targetType,omitempty" bson:"targetType,omitempty"`
+}
+
+// FieldChange is the value of a field before and after a change. A missing side means the
+// field wasn't set
+type FieldChange struct {
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+}
+
+// AuditActor is who is making changes, carried in the context down to where they're made
+type AuditActor struct {
+	ID        string
+	RequestID string
+}
+
+// systemActor makes changes nobody asked for directly, e.g. seeding or background jobs
+var systemActor = &AuditActor{ID: "system"}
+
+type auditActorKey struct{}
+
+// WithAuditActor returns a context whose changes are recorded as made by actor
+func WithAuditActor(ctx context.Context, actor *AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFrom returns the actor set on the context, or the system if there is none
+func AuditActorFrom(ctx context.Context) *AuditActor {
+	if actor, ok := ctx.Value(auditActorKey{}).(*AuditActor); ok {
+		return actor
+	}
+	return systemActor
+}
+
+// requestIDHeader is read from requests, or generated, and sent back on every response
+const requestIDHeader = "X-Request-Id"
+
+// auditMiddleware gives every request an id and, until something better identifies the caller,
+// treats the user in the path as the one making changes
+func auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" {
+			requestID = primitive.NewObjectID().Hex()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		actor := &AuditActor{ID: c.Param("id"), RequestID: requestID}
+		if actor.ID == "" {
+			actor.ID = "anonymous"
+		}
+
+		c.Request = c.Request.WithContext(WithAuditActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
+
+// recordChange adds an audit entry for a change to a document made by the context's actor.
+// before is nil for new documents and after is nil for removed ones
+func recordChange(ctx context.Context, db *DB, action, targetType, targetID string, before, after interface{}, details bson.M) error {
+	e, err := changeEntry(ctx, action, targetType, targetID, before, after, details)
+	if err != nil {
+		return err
+	}
+	return RecordAudit(ctx, db, e)
+}
+
+// changeEntry builds the audit entry recordChange adds, for changes recorded in bulk
+func changeEntry(ctx context.Context, action, targetType, targetID string, before, after interface{}, details bson.M) (*AuditEntry, error) {
+	changes, err := diffDocs(before, after)
+	if err != nil {
+		return nil, NewErrorf("error diffing %s %s: %s", targetType, targetID, err)
+	}
+
+	actor := AuditActorFrom(ctx)
+	return &AuditEntry{
+		Action:      action,
+		ActorID:     actor.ID,
+		Changes:     changes,
+		CreatedDate: time.Now(),
+		Details:     details,
+		RequestID:   actor.RequestID,
+		TargetID:    targetID,
+		TargetType:  targetType,
+	}, nil
+}
+
+// RecordAudit adds an entry to the audit collection
+func RecordAudit(ctx context.Context, db *DB, e *AuditEntry) error {
+	coll := db.MongoClient.Collection("audit")
+
+	if e.ID == "" {
+		e.ID = NewDocID()
+	}
+
+	if _, err := coll.InsertOne(ctx, e); err != nil {
+		return NewErrorf("error recording %s on %s: %s", e.Action, e.TargetID, err)
+	}
+
+	return nil
+}
+
+// RecordAudits adds many entries at once
+func RecordAudits(ctx context.Context, db *DB, entries []*AuditEntry) error {
+	docs := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		if e.ID == "" {
+			e.ID = NewDocID()
+		}
+		docs = append(docs, e)
+	}
+
+	if err := insertMany(ctx, db.MongoClient.Collection("audit"), docs); err != nil {
+		return NewErrorf("error recording %d audit entries: %s", len(entries), err)
+	}
+
+	return nil
+}
+
+// diffDocs compares the top level fields two documents are stored with
+func diffDocs(before, after interface{}) (map[string]*FieldChange, error) {
+	b, err := toDoc(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := toDoc(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]*FieldChange)
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changes[k] = &FieldChange{Before: v, After: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = &FieldChange{After: v}
+		}
+	}
+
+	return changes, nil
+}
+
+// toDoc converts a value to the document it'd be stored as. nil is an empty document
+func toDoc(v interface{}) (bson.M, error) {
+	doc := bson.M{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return doc, nil
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// AuditParams filters audit entries. Empty fields match everything
+type AuditParams struct {
+	Action    string
+	ActorID   string
+	From      time.Time
+	Limit     int64
+	RequestID string
+	TargetID  string
+	To        time.Time
+}
+
+// query builds the mongo filter document
+func (p *AuditParams) query() bson.M {
+	q := bson.M{}
+	for k, v := range map[string]string{"action": p.Action, "actorId": p.ActorID, "requestId": p.RequestID, "targetId": p.TargetID} {
+		if v != "" {
+			q[k] = v
+		}
+	}
+
+	date := bson.M{}
+	if !p.From.IsZero() {
+		date["$gte"] = p.From
+	}
+	if !p.To.IsZero() {
+		date["$lt"] = p.To
+	}
+	if len(date) > 0 {
+		q["createdDate"] = date
+	}
+
+	return q
+}
+
+// FindAudit returns matching audit entries, newest first
+func FindAudit(ctx context.Context, db *DB, p *AuditParams) ([]*AuditEntry, error) {
+	coll := db.MongoClient.Collection("audit")
+
+	opts := options.Find().SetSort(bson.M{"createdDate": -1}).SetLimit(p.Limit)
+	cur, err := coll.Find(ctx, p.query(), opts)
+	if err != nil {
+		return nil, NewErrorf("error finding audit entries from mongo: %s", err)
+	}
+
+	defer cur.Close(ctx)
+
+	entries := make([]*AuditEntry, 0)
+	for cur.Next(ctx) {
+		var e AuditEntry
+		if err := cur.Decode(&e); err != nil {
+			return nil, NewErrorf("error decoding into audit entry struct: %s", err)
+		}
+
+		entries = append(entries, &e)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_diffDocs(t *testing.T) {
+	before := &User{ID: "a", Name: "Jen", Bio: "hi"}
+	after := &User{ID: "a", Name: "Jennifer", Location: "Toronto"}
+
+	changes, err := diffDocs(before, after)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*FieldChange{
+		"name":     {Before: "Jen", After: "Jennifer"},
+		"bio":      {Before: "hi"},
+		"location": {After: "Toronto"},
+	}, changes)
+
+	created, err := diffDocs(nil, &Rating{ID: "r", Type: LIKE})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*FieldChange{"_id": {After: "r"}, "type": {After: "LIKE"}}, created)
+
+	var none *Rating
+	deleted, err := diffDocs(&Rating{ID: "r"}, none)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*FieldChange{"_id": {Before: "r"}}, deleted)
+}
+
+func TestAuditParams_query(t *testing.T) {
+	from := time.Date(2020, 1, 27, 0, 0, 0, 0, time.UTC)
+	p := &AuditParams{Action: "user.edit", TargetID: "a", From: from}
+
+	assert.Equal(t, bson.M{
+		"action":      "user.edit",
+		"targetId":    "a",
+		"createdDate": bson.M{"$gte": from},
+	}, p.query())
+}
+
+func Test_auditMiddleware(t *testing.T) {
+	var actor *AuditActor
+
+	r := gin.New()
+	r.Use(auditMiddleware())
+	r.PUT("/users/:id", func(c *gin.Context) {
+		actor = AuditActorFrom(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/users/a", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, &AuditActor{ID: "a", RequestID: "req-1"}, actor)
+	assert.Equal(t, "req-1", w.Header().Get("X-Request-Id"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/users/a", nil)
+	r.ServeHTTP(w, req)
+	assert.NotEmpty(t, w.Header().Get("X-Request-Id"), "a request id should be generated")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps files, like photos, by key. Keys are slash separated paths such as
+// "photos/<userId>/<photoId>.jpg"
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every blob whose key starts with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+	// URL is where clients can download the blob
+	URL(key string) string
+}
+
+// ErrBlobNotFound is returned by Get for keys that don't exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+// localBlobStore keeps blobs as files under a directory, served by the api itself
+type localBlobStore struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalBlobStore is a constructor for a BlobStore on the local filesystem. Blob urls are
+// baseURL followed by the key
+func NewLocalBlobStore(dir, baseURL string) BlobStore {
+	return &localBlobStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// path returns the file for a key, refusing keys that would escape the directory
+func (s *localBlobStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean != "/"+key {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+// Put writes to a temporary file first so readers never see a partial blob
+func (s *localBlobStore) Put(_ context.Context, key string, r io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return NewErrorf("error creating blob directory: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return NewErrorf("error creating blob %s: %s", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return NewErrorf("error writing blob %s: %s", key, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return NewErrorf("error writing blob %s: %s", key, err)
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *localBlobStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, ErrBlobNotFound
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return NewErrorf("error deleting blob %s: %s", key, err)
+	}
+	return nil
+}
+
+// DeletePrefix only supports prefixes that are whole directories, e.g. "photos/<userId>/"
+func (s *localBlobStore) DeletePrefix(_ context.Context, prefix string) error {
+	p, err := s.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(p); err != nil {
+		return NewErrorf("error deleting blobs under %s: %s", prefix, err)
+	}
+	return nil
+}
+
+func (s *localBlobStore) URL(key string) string {
+	return s.baseURL + "/" + key
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalBlobStore(t.TempDir(), "/blobs/")
+
+	assert.Nil(t, store.Put(ctx, "photos/a/1.jpg", strings.NewReader("one")))
+	assert.Nil(t, store.Put(ctx, "photos/a/2.jpg", strings.NewReader("two")))
+	assert.Equal(t, "/blobs/photos/a/1.jpg", store.URL("photos/a/1.jpg"))
+
+	f, err := store.Get(ctx, "photos/a/1.jpg")
+	if assert.Nil(t, err) {
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		assert.Equal(t, "one", string(data))
+	}
+
+	assert.Nil(t, store.Delete(ctx, "photos/a/1.jpg"))
+	_, err = store.Get(ctx, "photos/a/1.jpg")
+	assert.Equal(t, ErrBlobNotFound, err)
+
+	assert.Nil(t, store.DeletePrefix(ctx, "photos/a/"))
+	_, err = store.Get(ctx, "photos/a/2.jpg")
+	assert.Equal(t, ErrBlobNotFound, err)
+}
+
+func TestLocalBlobStore_invalidKey(t *testing.T) {
+	store := NewLocalBlobStore(t.TempDir(), "/blobs")
+
+	err := store.Put(context.Background(), "../escape", strings.NewReader("x"))
+	assert.EqualError(t, err, `invalid blob key "../escape"`)
+
+	_, err = store.Get(context.Background(), "photos/../../etc/passwd")
+	assert.Equal(t, ErrBlobNotFound, err)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UserCache keeps users read from mongo, encoded as bson, by id. Users are kept whatever their
+// status, lookups decide who is visible. A cache shared between instances, e.g. in redis, can
+// implement it too: a backend that fails should report a miss and carry on, mongo still has
+// every user
+type UserCache interface {
+	Get(ctx context.Context, id string) ([]byte, bool)
+	Set(ctx context.Context, id string, doc []byte)
+	// Delete drops the given users, Purge all of them
+	Delete(ctx context.Context, ids ...string)
+	Purge(ctx context.Context)
+	Stats() CacheStats
+}
+
+// CacheStats says how well the user cache is doing since the process started
+type CacheStats struct {
+	Capacity      int     `json:"capacity"`
+	Evictions     int64   `json:"evictions"`
+	Expirations   int64   `json:"expirations"`
+	HitRate       float64 `json:"hitRate"`
+	Hits          int64   `json:"hits"`
+	Invalidations int64   `json:"invalidations"`
+	Misses        int64   `json:"misses"`
+	Size          int     `json:"size"`
+	Store         string  `json:"store"`
+}
+
+// cachedUserEntry is a user in the memory cache
+type cachedUserEntry struct {
+	doc     []byte
+	expires time.Time
+	id      string
+}
+
+// memoryUserCache is an in process lru cache of users, each kept for at most ttl
+type memoryUserCache struct {
+	clock Clock
+	size  int
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recent has the most recently used users at the front
+	recent *list.List
+	stats  CacheStats
+}
+
+// NewMemoryUserCache is a constructor for an in process UserCache of up to size users
+func NewMemoryUserCache(size int, ttl time.Duration, clock Clock) UserCache {
+	return &memoryUserCache{
+		clock:   clock,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+func (c *memoryUserCache) Get(_ context.Context, id string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	entry := el.Value.(*cachedUserEntry)
+	if !c.clock.Now().Before(entry.expires) {
+		c.remove(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.recent.MoveToFront(el)
+	c.stats.Hits++
+	return entry.doc, true
+}
+
+func (c *memoryUserCache) Set(_ context.Context, id string, doc []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.clock.Now().Add(c.ttl)
+	if el, ok := c.entries[id]; ok {
+		entry := el.Value.(*cachedUserEntry)
+		entry.doc, entry.expires = doc, expires
+		c.recent.MoveToFront(el)
+		return
+	}
+
+	c.entries[id] = c.recent.PushFront(&cachedUserEntry{doc: doc, expires: expires, id: id})
+	for c.recent.Len() > c.size {
+		c.remove(c.recent.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *memoryUserCache) Delete(_ context.Context, ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if el, ok := c.entries[id]; ok {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+func (c *memoryUserCache) Purge(_ context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Invalidations += int64(len(c.entries))
+	c.entries = make(map[string]*list.Element)
+	c.recent.Init()
+}
+
+func (c *memoryUserCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Capacity = c.size
+	stats.Size = c.recent.Len()
+	stats.Store = "memory"
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+func (c *memoryUserCache) remove(el *list.Element) {
+	c.recent.Remove(el)
+	delete(c.entries, el.Value.(*cachedUserEntry).id)
+}
+
+// cachedUser returns the cached user with the given id, or nil if they aren't cached. Within a
+// transaction the cache is skipped, it could be behind the transaction's own writes
+func (db *DB) cachedUser(ctx context.Context, id string) *User {
+	if db.UserCache == nil || inTransaction(ctx) {
+		return nil
+	}
+
+	doc, ok := db.UserCache.Get(ctx, id)
+	if !ok {
+		return nil
+	}
+
+	var u User
+	if err := bson.Unmarshal(doc, &u); err != nil {
+		logf(levelError, "user cache: error decoding user %s: %s", id, err)
+		db.UserCache.Delete(ctx, id)
+		return nil
+	}
+	return &u
+}
+
+// cacheUsers keeps users just read from mongo. Users read within a transaction aren't kept,
+// it might not commit
+func (db *DB) cacheUsers(ctx context.Context, users ...*User) {
+	if db.UserCache == nil || inTransaction(ctx) {
+		return
+	}
+
+	for _, u := range users {
+		doc, err := bson.Marshal(u)
+		if err != nil {
+			logf(levelError, "user cache: error encoding user %s: %s", u.ID, err)
+			continue
+		}
+		db.UserCache.Set(ctx, string(u.ID), doc)
+	}
+}
+
+// forgetUsers drops users that are being changed from the cache. In a transaction they're
+// dropped again once it commits, in case they were read back in before then
+func (db *DB) forgetUsers(ctx context.Context, ids ...string) {
+	if db.UserCache == nil || len(ids) == 0 {
+		return
+	}
+
+	forget := func() { db.UserCache.Delete(ctx, ids...) }
+	forget()
+	if inTransaction(ctx) {
+		afterCommit(ctx, forget)
+	}
+}
+
+// forgetAllUsers empties the cache, for changes to users that aren't by id
+func (db *DB) forgetAllUsers(ctx context.Context) {
+	if db.UserCache == nil {
+		return
+	}
+
+	purge := func() { db.UserCache.Purge(ctx) }
+	purge()
+	if inTransaction(ctx) {
+		afterCommit(ctx, purge)
+	}
+}
+
+// errChangeStreamsUnsupported is returned when watching a deployment that isn't a replica set
+var errChangeStreamsUnsupported = errors.New("change streams need a replica set")
+
+// UserCacheWatcher follows changes to users in mongo and drops them from the cache, so changes
+// made by other instances, or straight in the database, are seen before the ttl runs out
+type UserCacheWatcher struct {
+	db *DB
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewUserCacheWatcher is a constructor for a UserCacheWatcher. Nothing runs until Start is
+// called
+func NewUserCacheWatcher(db *DB) *UserCacheWatcher {
+	return &UserCacheWatcher{db: db}
+}
+
+// Start watches in a new goroutine. When the change stream breaks it's opened again, with a
+// growing wait in between. On a deployment without change streams it gives up and leaves
+// cached users to expire
+func (w *UserCacheWatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		attempts := 0
+		for {
+			err := w.watch(ctx, func() { attempts = 0 })
+			if ctx.Err() != nil {
+				return
+			}
+			if err == errChangeStreamsUnsupported {
+				logf(levelInfo, "user cache: %s, cached users are only dropped on expiry and this instance's writes", err)
+				return
+			}
+
+			attempts++
+			wait := backoff(time.Second, time.Minute, attempts)
+			logf(levelWarn, "user cache: change stream stopped, reopening in %s: %s", wait, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop closes the change stream and waits for the goroutine to exit
+func (w *UserCacheWatcher) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+}
+
+// userChange is the part of a change stream event the cache needs
+type userChange struct {
+	DocumentKey struct {
+		ID DocID `bson:"_id"`
+	} `bson:"documentKey"`
+	OperationType string `bson:"operationType"`
+}
+
+// watch opens a change stream on users and drops each changed user until the stream ends.
+// opened is called once the stream is open
+func (w *UserCacheWatcher) watch(ctx context.Context, opened func()) error {
+	stream, err := w.db.MongoClient.Collection("users").Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		if e, ok := err.(mongo.CommandError); ok && e.Code == 40573 {
+			return errChangeStreamsUnsupported
+		}
+		return err
+	}
+	defer stream.Close(context.Background())
+	opened()
+
+	// changes from before the stream opened, or while it was down, were missed
+	w.db.forgetAllUsers(ctx)
+
+	for stream.Next(ctx) {
+		var change userChange
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+
+		w.db.applyUserChange(ctx, &change)
+	}
+
+	return stream.Err()
+}
+
+// applyUserChange drops what a change to users made stale
+func (db *DB) applyUserChange(ctx context.Context, change *userChange) {
+	switch change.OperationType {
+	case "insert", "update", "replace", "delete":
+		db.forgetUsers(ctx, string(change.DocumentKey.ID))
+	default:
+		// the collection was dropped or renamed, and the stream ends after this
+		db.forgetAllUsers(ctx)
+	}
+}
+
+// how the user cache is doing. With caching off every lookup is a miss, so only the store is set
+func (app *appContext) adminUserCacheStats(c *gin.Context) {
+	stats := CacheStats{Store: "none"}
+	if app.DB.UserCache != nil {
+		stats = app.DB.UserCache.Stats()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": stats,
+	})
+}
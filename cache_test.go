@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryUserCache(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)}
+	cache := NewMemoryUserCache(2, time.Minute, clock)
+	ctx := context.Background()
+
+	cache.Set(ctx, "a", []byte("ann"))
+	cache.Set(ctx, "b", []byte("bob"))
+
+	doc, ok := cache.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("ann"), doc)
+
+	// a was just used, so b makes room for c
+	cache.Set(ctx, "c", []byte("cat"))
+	_, ok = cache.Get(ctx, "b")
+	assert.False(t, ok)
+	_, ok = cache.Get(ctx, "a")
+	assert.True(t, ok)
+
+	clock.Advance(time.Minute)
+	_, ok = cache.Get(ctx, "a")
+	assert.False(t, ok, "users expire after the ttl")
+
+	cache.Set(ctx, "a", []byte("ann"))
+	cache.Delete(ctx, "a", "nobody")
+	_, ok = cache.Get(ctx, "a")
+	assert.False(t, ok)
+
+	assert.Equal(t, CacheStats{
+		Capacity:      2,
+		Evictions:     1,
+		Expirations:   1,
+		HitRate:       0.4,
+		Hits:          2,
+		Invalidations: 1,
+		Misses:        3,
+		Size:          1,
+		Store:         "memory",
+	}, cache.Stats())
+
+	cache.Purge(ctx)
+	assert.Equal(t, 0, cache.Stats().Size)
+	assert.Equal(t, int64(2), cache.Stats().Invalidations)
+}
+
+func TestDB_cachedUser(t *testing.T) {
+	db := &DB{UserCache: NewMemoryUserCache(10, time.Minute, &fakeClock{})}
+	ctx := context.Background()
+
+	ann := &User{ID: "a", Name: "Ann", Photos: []*Photo{{ID: "p1", URL: "/blobs/p1"}}}
+	db.cacheUsers(ctx, ann)
+
+	cached := db.cachedUser(ctx, "a")
+	assert.Equal(t, ann, cached)
+	cached.Photos[0].URL = "changed"
+	assert.Equal(t, "/blobs/p1", db.cachedUser(ctx, "a").Photos[0].URL, "callers get their own copy")
+
+	// nothing read or written in a transaction goes through the cache
+	tx := context.WithValue(ctx, transactionKey{}, &txn{})
+	assert.Nil(t, db.cachedUser(tx, "a"))
+	db.cacheUsers(tx, &User{ID: "b"})
+	assert.Nil(t, db.cachedUser(ctx, "b"))
+
+	assert.Nil(t, (&DB{}).cachedUser(ctx, "a"), "caching is off without a cache")
+}
+
+func TestDB_forgetUsers(t *testing.T) {
+	db := &DB{UserCache: NewMemoryUserCache(10, time.Minute, &fakeClock{})}
+	ctx := context.Background()
+	db.cacheUsers(ctx, &User{ID: "a"}, &User{ID: "b"})
+
+	// users read back in before a transaction commits are dropped again once it does
+	tx := &txn{}
+	txCtx := context.WithValue(ctx, transactionKey{}, tx)
+	db.forgetUsers(txCtx, "a")
+	assert.Nil(t, db.cachedUser(ctx, "a"))
+	db.cacheUsers(ctx, &User{ID: "a"})
+	for _, f := range tx.committed {
+		f()
+	}
+	assert.Nil(t, db.cachedUser(ctx, "a"))
+	assert.NotNil(t, db.cachedUser(ctx, "b"))
+}
+
+func TestDB_applyUserChange(t *testing.T) {
+	db := &DB{UserCache: NewMemoryUserCache(10, time.Minute, &fakeClock{})}
+	ctx := context.Background()
+	db.cacheUsers(ctx, &User{ID: "a"}, &User{ID: "b"})
+
+	change := &userChange{OperationType: "update"}
+	change.DocumentKey.ID = "a"
+	db.applyUserChange(ctx, change)
+	assert.Nil(t, db.cachedUser(ctx, "a"))
+	assert.NotNil(t, db.cachedUser(ctx, "b"))
+
+	db.applyUserChange(ctx, &userChange{OperationType: "invalidate"})
+	assert.Nil(t, db.cachedUser(ctx, "b"))
+}
+
+func TestFindUsersByIDs_cached(t *testing.T) {
+	// every user is cached, so mongo is never asked
+	db := &DB{UserCache: NewMemoryUserCache(10, time.Minute, &fakeClock{})}
+	ctx := context.Background()
+	db.cacheUsers(ctx, &User{ID: "a", Name: "Ann"}, &User{ID: "b", Name: "Bob", Status: UserHidden})
+
+	users, err := FindUsersByIDs(ctx, db, []string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*User{"a": {ID: "a", Name: "Ann"}}, users, "hidden users aren't visible")
+
+	users, err = FindUsersByIDs(WithInactiveUsers(ctx), db, []string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Len(t, users, 2)
+
+	u, err := FindUserByID(ctx, db, "b")
+	assert.Nil(t, err)
+	assert.Nil(t, u)
+
+	u, err = FindAccount(ctx, db, "b")
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob", u.Name)
+}
+
+func TestAdminUserCacheStats(t *testing.T) {
+	app := &appContext{DB: &DB{}}
+	r := gin.New()
+	r.GET("/admin/cache/users", app.adminUserCacheStats)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/cache/users", nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":{"capacity":0,"evictions":0,"expirations":0,"hitRate":0,"hits":0,"invalidations":0,"misses":0,"size":0,"store":"none"}}`, w.Body.String())
+
+	app.DB.UserCache = NewMemoryUserCache(10, time.Minute, &fakeClock{})
+	app.DB.cacheUsers(context.Background(), &User{ID: "a"})
+	app.DB.cachedUser(context.Background(), "a")
+
+	w = get()
+	assert.JSONEq(t, `{"data":{"capacity":10,"evictions":0,"expirations":0,"hitRate":1,"hits":1,"invalidations":0,"misses":0,"size":1,"store":"memory"}}`, w.Body.String())
+}
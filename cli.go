@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+)
+
+// command is a subcommand of the binary, e.g. `backend-homework seed --reset`
+type command struct {
+	name string
+	args string
+	help string
+	run  func(cfg *Config, args []string, out io.Writer) error
+}
+
+// every subcommand, in the order they are listed in the usage text
+func commands() []*command {
+	return []*command{
+		{"serve", "", "run the http api (default)", serveCommand},
+		{"seed", "[--reset] [--fixture name] [--file path]", "load a built in fixture, or a json/yaml fixture file", seedCommand},
+		{"generate", "[--users n] [--seed n] [--ratings n] [--reset] [--out file]", "generate a random, reproducible data set", generateCommand},
+		{"matches", "backfill", "create matches for users who like each other but have none", matchesCommand},
+		{"migrate", "up|down [--steps n]|status", "apply, roll back or list database migrations", migrateCommand},
+		{"users", "list|show <id>|export <id>|erase <id>", "print users as json, export everything about a user, or erase them", usersCommand},
+		{"ratings", "export [--format json|csv] [--type t] [--from id] [--to id]", "print ratings as json lines or csv", ratingsCommand},
+	}
+}
+
+// run parses global flags and hands the rest of the arguments to the chosen subcommand
+func run(args []string, out io.Writer) error {
+	cfg, args, err := LoadConfig(args)
+	if err != nil {
+		return fmt.Errorf("error loading config: %s", err)
+	}
+	setLogLevel(cfg.LogLevel)
+
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd.run(cfg, args, out)
+		}
+	}
+
+	return fmt.Errorf("unknown command %q\n\n%s", name, usage())
+}
+
+// usage lists the available subcommands
+func usage() string {
+	lines := []string{"usage: backend-homework [flags] <command>", "", "commands:"}
+	for _, cmd := range commands() {
+		lines = append(lines, fmt.Sprintf("  %-70s %s", cmd.name+" "+cmd.args, cmd.help))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// newAppContext connects to the database described by cfg and sets up the stores it needs
+func newAppContext(cfg *Config) *appContext {
+	content, err := NewContentFilter(&cfg.Content)
+	if err != nil {
+		log.Fatalf("error setting up content filter: %s", err)
+	}
+
+	app := &appContext{
+		Blobs:   NewLocalBlobStore(cfg.Photos.Dir, cfg.Photos.BaseURL),
+		Clock:   systemClock{},
+		Config:  cfg,
+		Content: content,
+		DB:      NewDB(&cfg.Mongo),
+	}
+
+	if cfg.Cache.Store == "memory" {
+		app.DB.UserCache = NewMemoryUserCache(cfg.Cache.Size, cfg.Cache.TTL, app.Clock)
+	}
+
+	if cfg.Limits.Store == "mongo" {
+		app.RateLimits = NewMongoRateLimitStore(app.DB)
+		app.Quotas = NewMongoQuotaStore(app.DB)
+	} else {
+		app.RateLimits = NewMemoryRateLimitStore()
+		app.Quotas = NewMemoryQuotaStore()
+	}
+
+	app.DailyQuotas = make(map[string]*DailyQuota)
+	if cfg.Features.LikeQuota {
+		// already validated with the rest of the config
+		hour, minute, _ := parseResetTime(cfg.Limits.QuotaReset)
+		limits := map[string]int{
+			"likes":      cfg.Limits.DailyLikes,
+			"superlikes": cfg.Limits.DailySuperlikes,
+		}
+		for name, limit := range limits {
+			app.DailyQuotas[name] = &DailyQuota{
+				Name:        name,
+				Limit:       limit,
+				ResetHour:   hour,
+				ResetMinute: minute,
+			}
+		}
+	}
+
+	return app
+}
+
+// run the http server, seeding an empty database first if enabled
+func serveCommand(cfg *Config, args []string, out io.Writer) error {
+	logf(levelInfo, "starting with config:\n%s\n", cfg)
+
+	if cfg.LogLevel != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// tracing is a no-op unless an exporter is configured
+	shutdownTracing, err := InitTracing(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		return fmt.Errorf("error setting up tracing: %s", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	app := newAppContext(cfg)
+
+	// ids stored as ObjectIDs, and the unique indexes writes rely on, come from migrations
+	if cfg.Features.MigrateOnStartup {
+		ran, err := MigrateUp(context.Background(), app.DB)
+		for _, m := range ran {
+			logf(levelInfo, "applied migration %d: %s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		statuses, err := MigrationStatuses(context.Background(), app.DB)
+		if err != nil {
+			return err
+		}
+		if err := checkMigrations(statuses); err != nil {
+			return err
+		}
+	}
+
+	// load default data in database
+	if cfg.Features.SeedOnStartup {
+		if err := PopulateDatabase(context.Background(), app.DB, cfg.SeedFixture); err != nil {
+			return err
+		}
+	}
+
+	// register handlers
+	r := setupRouter(app)
+
+	// internal services call the grpc api on its own port. It's opened before anything starts,
+	// so failing to listen leaves nothing to stop
+	var grpcLis net.Listener
+	if cfg.Features.GRPC {
+		if grpcLis, err = net.Listen("tcp", fmt.Sprintf(":%s", cfg.GRPC.Port)); err != nil {
+			return fmt.Errorf("error listening for grpc: %s", err)
+		}
+	}
+
+	// background jobs
+	schedulers := []*Scheduler{
+		NewScheduler("account purge", app.Clock, cfg.Accounts.PurgeInterval, accountPurgeJob(app.DB, app.Blobs, cfg.Accounts.DeletionGracePeriod)),
+	}
+	if cfg.Features.MatchExpiry {
+		job := matchExpiryJob(app.DB, cfg.Matches.ExpireAfter)
+		schedulers = append(schedulers, NewScheduler("match expiry", app.Clock, cfg.Matches.ExpiryInterval, job))
+	}
+	if cfg.Features.Webhooks {
+		job := webhookDispatchJob(NewWebhookDispatcher(app.DB, &cfg.Webhooks, app.Clock))
+		schedulers = append(schedulers, NewScheduler("webhook dispatch", app.Clock, cfg.Webhooks.Interval, job))
+	}
+	if cfg.Features.Notifications {
+		job := notificationJob(NewNotifier(app.DB, &cfg.Notifications, app.Clock))
+		schedulers = append(schedulers, NewScheduler("notifications", app.Clock, cfg.Notifications.Interval, job))
+	}
+	for _, s := range schedulers {
+		s.Start()
+	}
+
+	// other instances change users too, the change stream tells this one
+	var watcher *UserCacheWatcher
+	if app.DB.UserCache != nil && cfg.Cache.Watch {
+		watcher = NewUserCacheWatcher(app.DB)
+		watcher.Start()
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.Port),
+		Handler: r,
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	var grpcSrv *grpc.Server
+	if grpcLis != nil {
+		// the tokens were validated with the rest of the config
+		services, _ := cfg.GRPC.Services()
+		grpcSrv = newGRPCServer(app, services)
+		go func() {
+			errs <- grpcSrv.Serve(grpcLis)
+		}()
+	}
+
+	// stop on ctrl + c or a kill, letting in flight requests and jobs finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		logf(levelInfo, "shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+	}
+
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+
+	for _, s := range schedulers {
+		s.Stop()
+	}
+
+	if watcher != nil {
+		watcher.Stop()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error running server: %s", err)
+	}
+
+	return nil
+}
+
+// load a fixture into the database, optionally clearing it first
+func seedCommand(cfg *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	reset := fs.Bool("reset", false, "remove all users and ratings before seeding")
+	fixture := fs.String("fixture", cfg.SeedFixture, "built in fixture to load: "+strings.Join(FixtureNames(), ", "))
+	file := fs.String("file", "", "json or yaml fixture file to load instead of a built in one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// load the fixture before touching the database so a bad file doesn't leave it empty
+	var f *Fixture
+	var err error
+	if *file != "" {
+		f, err = LoadFixtureFile(*file)
+	} else {
+		f, err = LoadFixture(*fixture)
+	}
+	if err != nil {
+		return err
+	}
+
+	app := newAppContext(cfg)
+	ctx := context.Background()
+
+	if *reset {
+		if err := ResetDatabase(ctx, app.DB); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "removed all users and ratings")
+	}
+
+	if err := SeedDatabase(ctx, app.DB, f); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "loaded fixture %s: %d users and %d ratings\n", f.Name, len(f.Users), len(f.Ratings))
+	return nil
+}
+
+// generate synthetic users and ratings, writing them to the database or a fixture file
+func generateCommand(cfg *Config, args []string, out io.Writer) error {
+	opts := DefaultGeneratorOptions()
+
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.IntVar(&opts.Users, "users", opts.Users, "number of users")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed, the same seed gives the same data")
+	fs.IntVar(&opts.RatingsPerUser, "ratings", opts.RatingsPerUser, "average number of ratings made by each user")
+	fs.Float64Var(&opts.BlockRate, "block-rate", opts.BlockRate, "fraction of ratings that are BLOCKs")
+	fs.Float64Var(&opts.ReportRate, "report-rate", opts.ReportRate, "fraction of ratings that are REPORTs")
+	fs.Float64Var(&opts.Exponent, "exponent", opts.Exponent, "power law exponent for popularity, greater than 1")
+	reset := fs.Bool("reset", false, "remove all users and ratings before inserting")
+	file := fs.String("out", "", "write the data set to this json fixture file instead of the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := GenerateFixture(opts)
+	if err != nil {
+		return err
+	}
+
+	if *file != "" {
+		b, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*file, b, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "wrote %d users and %d ratings to %s\n", len(f.Users), len(f.Ratings), *file)
+		return nil
+	}
+
+	app := newAppContext(cfg)
+	ctx := context.Background()
+
+	if *reset {
+		if err := ResetDatabase(ctx, app.DB); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "removed all users and ratings")
+	}
+
+	if err := SeedDatabase(ctx, app.DB, f); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "inserted %d users and %d ratings\n", len(f.Users), len(f.Ratings))
+	return nil
+}
+
+// create matches from existing ratings
+func matchesCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) != 1 || args[0] != "backfill" {
+		return errors.New("usage: matches backfill")
+	}
+
+	app := newAppContext(cfg)
+
+	n, err := BackfillMatches(context.Background(), app.DB)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "created %d matches\n", n)
+	return nil
+}
+
+// apply, roll back or list migrations
+func migrateCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [--steps n]|status")
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if action != "up" && action != "down" && action != "status" {
+		return fmt.Errorf("unknown migrate action %q. must be one of up, down, status", action)
+	}
+
+	app := newAppContext(cfg)
+	ctx := context.Background()
+
+	switch action {
+	case "up":
+		ran, err := MigrateUp(ctx, app.DB)
+		for _, m := range ran {
+			fmt.Fprintf(out, "applied %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			fmt.Fprintln(out, "nothing to apply")
+		}
+	case "down":
+		ran, err := MigrateDown(ctx, app.DB, *steps)
+		for _, m := range ran {
+			fmt.Fprintf(out, "rolled back %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		statuses, err := MigrationStatuses(ctx, app.DB)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedDate != nil {
+				applied = "applied " + s.AppliedDate.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%3d  %-60s %s\n", s.Migration.Version, s.Migration.Name, applied)
+		}
+	}
+
+	return nil
+}
+
+// print all users or a single user
+func usersCommand(cfg *Config, args []string, out io.Writer) error {
+	valid := len(args) == 1 && args[0] == "list"
+	if len(args) == 2 {
+		switch args[0] {
+		case "show", "export", "erase":
+			valid = true
+		}
+	}
+	if !valid {
+		return errors.New("usage: users list|show <id>|export <id>|erase <id>")
+	}
+
+	app := newAppContext(cfg)
+	// operators see every account, whatever its status
+	ctx := WithInactiveUsers(context.Background())
+
+	if args[0] == "list" {
+		users, err := FindAllUsers(ctx, app.DB)
+		if err != nil {
+			return err
+		}
+		prettyPrint(out, users)
+		return nil
+	}
+
+	switch args[0] {
+	case "export":
+		export, err := ExportUser(ctx, app.DB, args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		if export == nil {
+			return fmt.Errorf("user %s not found", args[1])
+		}
+		prettyPrint(out, export)
+		return nil
+	case "erase":
+		result, err := EraseUser(ctx, app.DB, app.Blobs, args[1])
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			return fmt.Errorf("user %s not found", args[1])
+		}
+		prettyPrint(out, result)
+		return nil
+	}
+
+	user, err := FindUserByID(ctx, app.DB, args[1])
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return fmt.Errorf("user %s not found", args[1])
+	}
+
+	prettyPrint(out, user)
+	return nil
+}
+
+// write ratings as json lines or csv
+func ratingsCommand(cfg *Config, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "export" {
+		return errors.New("usage: ratings export [--format json|csv] [--type t] [--from id] [--to id]")
+	}
+
+	fs := flag.NewFlagSet("ratings export", flag.ContinueOnError)
+	format := fs.String("format", "json", "output format, json (one rating per line) or csv")
+	ratingType := fs.String("type", "", "only export ratings of this type")
+	from := fs.String("from", "", "only export ratings made by this user id")
+	to := fs.String("to", "", "only export ratings made to this user id")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q. must be json or csv", *format)
+	}
+
+	app := newAppContext(cfg)
+
+	params := &RatingParams{
+		Filter: &Rating{
+			FromUserID: *from,
+			ToUserID:   *to,
+			Type:       RatingType(*ratingType),
+		},
+	}
+
+	query, err := params.query()
+	if err != nil {
+		return fmt.Errorf("error building rating query: %s", err)
+	}
+
+	// streamed from the cursor like the admin export, so memory doesn't grow with the ratings
+	dumpFormat := DumpNDJSON
+	if *format == "csv" {
+		dumpFormat = DumpCSV
+	}
+	_, err = WriteDump(context.Background(), app.DB, out, ratingDump, dumpFormat, query, func() {})
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_run_argumentErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"bogus"}, `unknown command "bogus"`},
+		{[]string{"migrate"}, "usage: migrate up|down [--steps n]|status"},
+		{[]string{"migrate", "sideways"}, `unknown migrate action "sideways". must be one of up, down, status`},
+		{[]string{"users", "show"}, "usage: users list|show <id>|export <id>|erase <id>"},
+		{[]string{"users", "erase"}, "usage: users list|show <id>|export <id>|erase <id>"},
+		{[]string{"ratings"}, "usage: ratings export"},
+		{[]string{"ratings", "export", "--format", "xml"}, `unknown format "xml". must be json or csv`},
+	}
+
+	for _, tt := range tests {
+		err := run(tt.args, &bytes.Buffer{})
+		if assert.Error(t, err, tt.args) {
+			assert.Contains(t, err.Error(), tt.wantErr)
+		}
+	}
+}
+
+func Test_migrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be sequential")
+		assert.NotNil(t, m.Up, "migration %d has no up step", m.Version)
+		assert.NotNil(t, m.Down, "migration %d has no down step", m.Version)
+	}
+}
+
+func Test_checkMigrations(t *testing.T) {
+	applied := time.Now()
+	statuses := []*MigrationStatus{
+		{Migration: &Migration{Version: 11}, AppliedDate: &applied},
+		{Migration: &Migration{Version: 12}},
+		{Migration: &Migration{Version: 13}},
+	}
+	assert.EqualError(t, checkMigrations(statuses), "migrations 12, 13 are pending. run `migrate up` first, or set FEATURE_MIGRATE_ON_STARTUP=true")
+
+	statuses[1].AppliedDate, statuses[2].AppliedDate = &applied, &applied
+	assert.Nil(t, checkMigrations(statuses))
+}
@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// Clock tells the time. Anything time dependent takes one so tests can control it
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
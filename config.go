@@ -0,0 +1,795 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds everything the service needs to start. Values are resolved in order:
+// defaults, then environment (including .env), then command line flags.
+type Config struct {
+	Port           string
+	LogLevel       string
+	TracesExporter string
+	SeedFixture    string
+	Mongo          MongoConfig
+	Limits         LimitsConfig
+	Matches        MatchesConfig
+	Accounts       AccountsConfig
+	Photos         PhotosConfig
+	Content        ContentConfig
+	API            APIConfig
+	GRPC           GRPCConfig
+	Admin          AdminConfig
+	Webhooks       WebhooksConfig
+	Notifications  NotificationsConfig
+	Cache          CacheConfig
+	Features       FeatureFlags
+}
+
+// MongoConfig describes how to reach the database. URI takes precedence over Host/Port
+type MongoConfig struct {
+	URI        string
+	Host       string
+	Port       string
+	Username   string
+	Password   string
+	AuthSource string
+	Database   string
+	ReplicaSet string
+	// Transactions writes changes and their events together. It needs a replica set or sharded
+	// cluster, standalone servers don't support transactions
+	Transactions           bool
+	TLS                    bool
+	MaxPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+}
+
+// LimitsConfig sets request rate limits and daily quotas. Rates are requests per second
+type LimitsConfig struct {
+	// Store is where limits are counted: memory (per instance) or mongo (shared)
+	Store           string
+	IPRate          float64
+	IPBurst         int
+	UserRate        float64
+	UserBurst       int
+	DailyLikes      int
+	DailySuperlikes int
+	// QuotaReset is the time of day, HH:MM in UTC, that daily quotas start over
+	QuotaReset string
+	// RatingBatchSize is the most ratings one batch request can send
+	RatingBatchSize int
+}
+
+// MatchesConfig sets how long a match lasts without any interaction, used when
+// Features.MatchExpiry is on
+type MatchesConfig struct {
+	ExpireAfter time.Duration
+	// ExpiryInterval is how often to look for matches to expire
+	ExpiryInterval time.Duration
+}
+
+// AccountsConfig sets how long deleted accounts can be reactivated before they're purged
+type AccountsConfig struct {
+	DeletionGracePeriod time.Duration
+	// PurgeInterval is how often to look for deleted accounts to purge
+	PurgeInterval time.Duration
+}
+
+// PhotosConfig sets where photos are stored and what uploads are accepted
+type PhotosConfig struct {
+	// Dir is where the local blob store keeps files
+	Dir string
+	// BaseURL is put in front of blob keys to make photo urls, e.g. a CDN in front of /blobs
+	BaseURL       string
+	MaxBytes      int
+	MaxPerUser    int
+	ThumbnailSize int
+}
+
+// ContentConfig sets how user written text is filtered
+type ContentConfig struct {
+	MaxBioLength    int
+	MaxReasonLength int
+	// BannedWordsFile is a list of words to reject or review, none if empty
+	BannedWordsFile string
+}
+
+// APIConfig sets how api versions are retired
+type APIConfig struct {
+	// LegacySunset is the date (YYYY-MM-DD) the unversioned routes, aliases of /v1, go away
+	LegacySunset string
+}
+
+// GRPCConfig sets up the grpc server for internal services, used when Features.GRPC is on
+type GRPCConfig struct {
+	Port string
+	// Tokens are the services allowed to call, as comma separated name:token pairs, e.g.
+	// notifications:s3cret,analytics:0ther
+	Tokens string
+}
+
+// AdminConfig sets who is trusted to call the admin api, used when Features.Admin is on. There
+// is no login yet, so a proxy in front of the api authenticates callers and names them in the
+// X-User-Id header. It proves the request came through it with ProxyToken, sent in X-Proxy-Token
+type AdminConfig struct {
+	ProxyToken string
+}
+
+// WebhooksConfig sets how events are delivered to webhooks, used when Features.Webhooks is on.
+// Failed deliveries are retried after Backoff, doubling up to MaxBackoff, until MaxAttempts
+type WebhooksConfig struct {
+	// Interval is how often to look for events to deliver
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// NotificationsConfig sets how users are notified, used when Features.Notifications is on.
+// Email is sent over smtp or logged, push through the stub provider or logged, and none turns
+// a channel off. A failed send is retried after Interval, doubling up to an hour, until MaxAttempts
+type NotificationsConfig struct {
+	// Interval is how often to look for notifications to send
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxAttempts int
+	// LikeDigest is how long likes are gathered, from the first, before they're sent as one
+	LikeDigest     time.Duration
+	EmailTransport string
+	PushTransport  string
+	SMTP           SMTPConfig
+}
+
+// SMTPConfig is the mail server email notifications are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// From is the sender, e.g. "Homework <noreply@example.com>"
+	From string
+}
+
+// CacheConfig sets up the read cache for user profiles. Cached users are dropped after TTL, or
+// sooner when they change
+type CacheConfig struct {
+	// Store is memory (per instance) or none to read every user from mongo
+	Store string
+	// Size is how many users to keep, least recently used go first
+	Size int
+	TTL  time.Duration
+	// Watch follows changes to users in mongo, so edits made by other instances drop cached
+	// users too. It needs a replica set, elsewhere only the TTL covers them
+	Watch bool
+}
+
+// FeatureFlags toggles optional behavior
+type FeatureFlags struct {
+	SeedOnStartup bool
+	// MigrateOnStartup applies pending migrations when serving. Without it serve refuses to
+	// start until they're applied
+	MigrateOnStartup bool
+	RateLimiting     bool
+	LikeQuota        bool
+	MatchExpiry      bool
+	GRPC             bool
+	Admin            bool
+	Webhooks         bool
+	Notifications    bool
+}
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() *Config {
+	return &Config{
+		Port:        "8080",
+		LogLevel:    "info",
+		SeedFixture: "default",
+		Mongo: MongoConfig{
+			Host:                   "localhost",
+			Port:                   "27017",
+			Database:               "backend-homework",
+			MaxPoolSize:            100,
+			ConnectTimeout:         10 * time.Second,
+			ServerSelectionTimeout: 30 * time.Second,
+		},
+		Limits: LimitsConfig{
+			Store:           "memory",
+			IPRate:          20,
+			IPBurst:         40,
+			UserRate:        5,
+			UserBurst:       10,
+			DailyLikes:      100,
+			DailySuperlikes: 3,
+			QuotaReset:      "00:00",
+			RatingBatchSize: 100,
+		},
+		Matches: MatchesConfig{
+			ExpireAfter:    24 * time.Hour,
+			ExpiryInterval: time.Minute,
+		},
+		Accounts: AccountsConfig{
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			PurgeInterval:       time.Hour,
+		},
+		Photos: PhotosConfig{
+			Dir:           "data/blobs",
+			BaseURL:       "/blobs",
+			MaxBytes:      10 << 20,
+			MaxPerUser:    6,
+			ThumbnailSize: 320,
+		},
+		Content: ContentConfig{
+			MaxBioLength:    500,
+			MaxReasonLength: 1000,
+		},
+		API: APIConfig{
+			LegacySunset: "2027-06-30",
+		},
+		GRPC: GRPCConfig{
+			Port: "9090",
+		},
+		Webhooks: WebhooksConfig{
+			Interval:    5 * time.Second,
+			Timeout:     10 * time.Second,
+			MaxAttempts: 10,
+			Backoff:     30 * time.Second,
+			MaxBackoff:  6 * time.Hour,
+		},
+		Notifications: NotificationsConfig{
+			Interval:       30 * time.Second,
+			Timeout:        10 * time.Second,
+			MaxAttempts:    5,
+			LikeDigest:     time.Hour,
+			EmailTransport: "log",
+			PushTransport:  "log",
+			SMTP: SMTPConfig{
+				Port: "587",
+				From: "Homework <noreply@example.com>",
+			},
+		},
+		Cache: CacheConfig{
+			Store: "memory",
+			Size:  10000,
+			TTL:   time.Minute,
+			Watch: true,
+		},
+		Features: FeatureFlags{
+			SeedOnStartup:    true,
+			MigrateOnStartup: true,
+			RateLimiting:     true,
+			LikeQuota:        true,
+		},
+	}
+}
+
+// LoadConfig builds a Config from the environment and the given command line arguments,
+// then validates it. Arguments left after the flags are returned as is.
+func LoadConfig(args []string) (*Config, []string, error) {
+	cfg := DefaultConfig()
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	fs := flag.NewFlagSet("backend-homework", flag.ContinueOnError)
+	cfg.registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// read known environment variables on top of the current values
+func (c *Config) loadEnv() error {
+	e := &envReader{}
+
+	e.string("PORT", &c.Port)
+	e.string("LOG_LEVEL", &c.LogLevel)
+	e.string("OTEL_TRACES_EXPORTER", &c.TracesExporter)
+	e.string("SEED_FIXTURE", &c.SeedFixture)
+
+	e.string("MONGO_URI", &c.Mongo.URI)
+	e.string("MONGO_HOST", &c.Mongo.Host)
+	e.string("MONGO_PORT", &c.Mongo.Port)
+	e.string("MONGO_USERNAME", &c.Mongo.Username)
+	e.string("MONGO_PASSWORD", &c.Mongo.Password)
+	e.string("MONGO_AUTH_SOURCE", &c.Mongo.AuthSource)
+	e.string("MONGO_DATABASE", &c.Mongo.Database)
+	e.string("MONGO_REPLICA_SET", &c.Mongo.ReplicaSet)
+	e.bool("MONGO_TRANSACTIONS", &c.Mongo.Transactions)
+	e.bool("MONGO_TLS", &c.Mongo.TLS)
+	e.uint("MONGO_MAX_POOL_SIZE", &c.Mongo.MaxPoolSize)
+	e.duration("MONGO_CONNECT_TIMEOUT", &c.Mongo.ConnectTimeout)
+	e.duration("MONGO_SERVER_SELECTION_TIMEOUT", &c.Mongo.ServerSelectionTimeout)
+
+	e.string("RATE_LIMIT_STORE", &c.Limits.Store)
+	e.float("RATE_LIMIT_IP_RATE", &c.Limits.IPRate)
+	e.int("RATE_LIMIT_IP_BURST", &c.Limits.IPBurst)
+	e.float("RATE_LIMIT_USER_RATE", &c.Limits.UserRate)
+	e.int("RATE_LIMIT_USER_BURST", &c.Limits.UserBurst)
+	e.int("DAILY_LIKE_LIMIT", &c.Limits.DailyLikes)
+	e.int("DAILY_SUPERLIKE_LIMIT", &c.Limits.DailySuperlikes)
+	e.string("QUOTA_RESET_TIME", &c.Limits.QuotaReset)
+	e.int("RATING_BATCH_LIMIT", &c.Limits.RatingBatchSize)
+
+	e.duration("MATCH_EXPIRE_AFTER", &c.Matches.ExpireAfter)
+	e.duration("MATCH_EXPIRY_INTERVAL", &c.Matches.ExpiryInterval)
+
+	e.duration("ACCOUNT_DELETION_GRACE_PERIOD", &c.Accounts.DeletionGracePeriod)
+	e.duration("ACCOUNT_PURGE_INTERVAL", &c.Accounts.PurgeInterval)
+
+	e.string("PHOTO_DIR", &c.Photos.Dir)
+	e.string("PHOTO_BASE_URL", &c.Photos.BaseURL)
+	e.int("PHOTO_MAX_BYTES", &c.Photos.MaxBytes)
+	e.int("PHOTO_MAX_PER_USER", &c.Photos.MaxPerUser)
+	e.int("PHOTO_THUMBNAIL_SIZE", &c.Photos.ThumbnailSize)
+
+	e.int("CONTENT_MAX_BIO_LENGTH", &c.Content.MaxBioLength)
+	e.int("CONTENT_MAX_REASON_LENGTH", &c.Content.MaxReasonLength)
+	e.string("CONTENT_BANNED_WORDS_FILE", &c.Content.BannedWordsFile)
+
+	e.string("API_LEGACY_SUNSET", &c.API.LegacySunset)
+
+	e.string("GRPC_PORT", &c.GRPC.Port)
+	e.string("GRPC_TOKENS", &c.GRPC.Tokens)
+
+	e.string("ADMIN_PROXY_TOKEN", &c.Admin.ProxyToken)
+
+	e.duration("WEBHOOK_INTERVAL", &c.Webhooks.Interval)
+	e.duration("WEBHOOK_TIMEOUT", &c.Webhooks.Timeout)
+	e.int("WEBHOOK_MAX_ATTEMPTS", &c.Webhooks.MaxAttempts)
+	e.duration("WEBHOOK_BACKOFF", &c.Webhooks.Backoff)
+	e.duration("WEBHOOK_MAX_BACKOFF", &c.Webhooks.MaxBackoff)
+
+	e.duration("NOTIFY_INTERVAL", &c.Notifications.Interval)
+	e.duration("NOTIFY_TIMEOUT", &c.Notifications.Timeout)
+	e.int("NOTIFY_MAX_ATTEMPTS", &c.Notifications.MaxAttempts)
+	e.duration("NOTIFY_LIKE_DIGEST", &c.Notifications.LikeDigest)
+	e.string("NOTIFY_EMAIL_TRANSPORT", &c.Notifications.EmailTransport)
+	e.string("NOTIFY_PUSH_TRANSPORT", &c.Notifications.PushTransport)
+	e.string("SMTP_HOST", &c.Notifications.SMTP.Host)
+	e.string("SMTP_PORT", &c.Notifications.SMTP.Port)
+	e.string("SMTP_USERNAME", &c.Notifications.SMTP.Username)
+	e.string("SMTP_PASSWORD", &c.Notifications.SMTP.Password)
+	e.string("SMTP_FROM", &c.Notifications.SMTP.From)
+
+	e.string("USER_CACHE_STORE", &c.Cache.Store)
+	e.int("USER_CACHE_SIZE", &c.Cache.Size)
+	e.duration("USER_CACHE_TTL", &c.Cache.TTL)
+	e.bool("USER_CACHE_WATCH", &c.Cache.Watch)
+
+	e.bool("FEATURE_SEED_ON_STARTUP", &c.Features.SeedOnStartup)
+	e.bool("FEATURE_MIGRATE_ON_STARTUP", &c.Features.MigrateOnStartup)
+	e.bool("FEATURE_RATE_LIMITING", &c.Features.RateLimiting)
+	e.bool("FEATURE_LIKE_QUOTA", &c.Features.LikeQuota)
+	e.bool("FEATURE_MATCH_EXPIRY", &c.Features.MatchExpiry)
+	e.bool("FEATURE_GRPC", &c.Features.GRPC)
+	e.bool("FEATURE_ADMIN", &c.Features.Admin)
+	e.bool("FEATURE_WEBHOOKS", &c.Features.Webhooks)
+	e.bool("FEATURE_NOTIFICATIONS", &c.Features.Notifications)
+
+	return e.err
+}
+
+// flags default to the values already loaded, so an unset flag never overrides the env
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", c.Port, "http port to listen on")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "one of debug, info, warn, error")
+	fs.StringVar(&c.Mongo.URI, "mongo-uri", c.Mongo.URI, "full mongo connection string, overrides host and port")
+	fs.StringVar(&c.Mongo.Host, "mongo-host", c.Mongo.Host, "mongo host")
+	fs.StringVar(&c.Mongo.Port, "mongo-port", c.Mongo.Port, "mongo port")
+	fs.StringVar(&c.Mongo.Database, "mongo-db", c.Mongo.Database, "mongo database name")
+	fs.BoolVar(&c.Features.SeedOnStartup, "seed", c.Features.SeedOnStartup, "load sample data into an empty database on startup")
+	fs.StringVar(&c.SeedFixture, "seed-fixture", c.SeedFixture, "built in fixture to load when seeding")
+}
+
+// Validate checks that the config is usable
+func (c *Config) Validate() error {
+	if _, err := strconv.Atoi(c.Port); err != nil {
+		return fmt.Errorf("invalid port %q", c.Port)
+	}
+
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("invalid log level %q. must be one of debug, info, warn, error", c.LogLevel)
+	}
+
+	switch c.TracesExporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		return fmt.Errorf("invalid traces exporter %q. must be one of none, stdout, otlp", c.TracesExporter)
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		return err
+	}
+
+	if c.Matches.ExpireAfter <= 0 || c.Matches.ExpiryInterval <= 0 {
+		return errors.New("match expiry and expiry interval must be greater than 0")
+	}
+
+	if c.Accounts.DeletionGracePeriod <= 0 || c.Accounts.PurgeInterval <= 0 {
+		return errors.New("account deletion grace period and purge interval must be greater than 0")
+	}
+
+	if c.Photos.Dir == "" || c.Photos.MaxBytes < 1 || c.Photos.MaxPerUser < 1 || c.Photos.ThumbnailSize < 1 {
+		return errors.New("photo dir is required and photo limits must be greater than 0")
+	}
+
+	if c.Content.MaxBioLength < 1 || c.Content.MaxReasonLength < 1 {
+		return errors.New("content length limits must be greater than 0")
+	}
+
+	if _, err := c.API.Sunset(); err != nil {
+		return fmt.Errorf("invalid api legacy sunset %q. must be a YYYY-MM-DD date", c.API.LegacySunset)
+	}
+
+	if c.Features.GRPC {
+		if _, err := strconv.Atoi(c.GRPC.Port); err != nil || c.GRPC.Port == c.Port {
+			return fmt.Errorf("invalid grpc port %q. must be a number other than the http port", c.GRPC.Port)
+		}
+
+		services, err := c.GRPC.Services()
+		if err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			return errors.New("grpc tokens are required when grpc is on")
+		}
+	}
+
+	if c.Features.Admin && c.Admin.ProxyToken == "" {
+		return errors.New("admin proxy token is required when the admin api is on")
+	}
+
+	w := c.Webhooks
+	if w.Interval <= 0 || w.Timeout <= 0 || w.MaxAttempts < 1 || w.Backoff <= 0 || w.MaxBackoff < w.Backoff {
+		return errors.New("webhook interval, timeout, attempts and backoff must be greater than 0, and max backoff at least the backoff")
+	}
+
+	if err := c.Notifications.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Cache.Validate(); err != nil {
+		return err
+	}
+
+	return c.Mongo.Validate()
+}
+
+// Sunset is when the unversioned routes go away, at the start of the sunset date in UTC
+func (a *APIConfig) Sunset() (time.Time, error) {
+	return time.Parse("2006-01-02", a.LegacySunset)
+}
+
+// Services maps each grpc token to the name of the service it belongs to
+func (g *GRPCConfig) Services() (map[string]string, error) {
+	services := make(map[string]string)
+	if g.Tokens == "" {
+		return services, nil
+	}
+
+	for _, pair := range strings.Split(g.Tokens, ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" || token == "" {
+			// the pair could be a bare token, so it's left out of the error
+			return nil, errors.New("invalid grpc tokens. each must be name:token")
+		}
+		if _, ok := services[token]; ok {
+			return nil, fmt.Errorf("grpc token of %s is used more than once", name)
+		}
+		services[token] = name
+	}
+
+	return services, nil
+}
+
+// Validate checks that the limits are usable
+func (l *LimitsConfig) Validate() error {
+	if l.Store != "memory" && l.Store != "mongo" {
+		return fmt.Errorf("invalid rate limit store %q. must be memory or mongo", l.Store)
+	}
+
+	if l.IPRate <= 0 || l.UserRate <= 0 || l.IPBurst < 1 || l.UserBurst < 1 {
+		return errors.New("rate limits and bursts must be greater than 0")
+	}
+
+	if l.DailyLikes < 0 || l.DailySuperlikes < 0 {
+		return errors.New("daily limits cannot be negative")
+	}
+
+	if l.RatingBatchSize < 1 {
+		return errors.New("rating batch limit must be greater than 0")
+	}
+
+	_, _, err := parseResetTime(l.QuotaReset)
+	return err
+}
+
+// Validate checks that the notification settings are usable. The smtp server is only needed
+// when email goes through it
+func (n *NotificationsConfig) Validate() error {
+	if n.Interval <= 0 || n.Timeout <= 0 || n.MaxAttempts < 1 || n.LikeDigest <= 0 {
+		return errors.New("notification interval, timeout, attempts and like digest must be greater than 0")
+	}
+
+	switch n.EmailTransport {
+	case "smtp", "log", "none":
+	default:
+		return fmt.Errorf("invalid email transport %q. must be one of smtp, log, none", n.EmailTransport)
+	}
+
+	switch n.PushTransport {
+	case "stub", "log", "none":
+	default:
+		return fmt.Errorf("invalid push transport %q. must be one of stub, log, none", n.PushTransport)
+	}
+
+	if n.EmailTransport != "smtp" {
+		return nil
+	}
+
+	if _, err := strconv.Atoi(n.SMTP.Port); err != nil || n.SMTP.Host == "" {
+		return errors.New("smtp host and port are required for the smtp email transport")
+	}
+	if n.SMTP.Password != "" && n.SMTP.Username == "" {
+		return errors.New("smtp password set without a username")
+	}
+	if _, err := mail.ParseAddress(n.SMTP.From); err != nil {
+		return fmt.Errorf("invalid smtp from address %q", n.SMTP.From)
+	}
+
+	return nil
+}
+
+// Validate checks that the user cache is usable
+func (c *CacheConfig) Validate() error {
+	switch c.Store {
+	case "memory":
+		if c.Size < 1 || c.TTL <= 0 {
+			return errors.New("user cache size and ttl must be greater than 0")
+		}
+	case "none":
+	default:
+		return fmt.Errorf("invalid user cache store %q. must be memory or none", c.Store)
+	}
+
+	return nil
+}
+
+// Validate checks that the mongo config is usable
+func (m *MongoConfig) Validate() error {
+	if m.URI != "" {
+		if !strings.HasPrefix(m.URI, "mongodb://") && !strings.HasPrefix(m.URI, "mongodb+srv://") {
+			return errors.New("mongo uri must start with mongodb:// or mongodb+srv://")
+		}
+	} else if m.Host == "" || m.Port == "" {
+		return errors.New("either mongo uri or mongo host and port are required")
+	}
+
+	if m.Password != "" && m.Username == "" {
+		return errors.New("mongo password set without a username")
+	}
+
+	if m.Database == "" {
+		return errors.New("mongo database name is required")
+	}
+
+	if m.MaxPoolSize == 0 {
+		return errors.New("mongo max pool size must be greater than 0")
+	}
+
+	if m.ConnectTimeout <= 0 || m.ServerSelectionTimeout <= 0 {
+		return errors.New("mongo timeouts must be greater than 0")
+	}
+
+	return nil
+}
+
+// ConnectionURI returns the uri to connect with, built from host and port if no uri was given
+func (m *MongoConfig) ConnectionURI() string {
+	if m.URI != "" {
+		return m.URI
+	}
+	return fmt.Sprintf("mongodb://%s:%s", m.Host, m.Port)
+}
+
+// String prints the config with secrets removed, safe for logs
+func (c *Config) String() string {
+	password := ""
+	if c.Mongo.Password != "" {
+		password = "xxxxx"
+	}
+	smtpPassword := ""
+	if c.Notifications.SMTP.Password != "" {
+		smtpPassword = "xxxxx"
+	}
+	proxyToken := ""
+	if c.Admin.ProxyToken != "" {
+		proxyToken = "xxxxx"
+	}
+
+	lines := []string{
+		"port=" + c.Port,
+		"logLevel=" + c.LogLevel,
+		"tracesExporter=" + c.TracesExporter,
+		"seedFixture=" + c.SeedFixture,
+		"mongo.uri=" + redactURI(c.Mongo.ConnectionURI()),
+		"mongo.username=" + c.Mongo.Username,
+		"mongo.password=" + password,
+		"mongo.authSource=" + c.Mongo.AuthSource,
+		"mongo.database=" + c.Mongo.Database,
+		"mongo.replicaSet=" + c.Mongo.ReplicaSet,
+		"mongo.transactions=" + strconv.FormatBool(c.Mongo.Transactions),
+		"mongo.tls=" + strconv.FormatBool(c.Mongo.TLS),
+		"mongo.maxPoolSize=" + strconv.FormatUint(c.Mongo.MaxPoolSize, 10),
+		"mongo.connectTimeout=" + c.Mongo.ConnectTimeout.String(),
+		"mongo.serverSelectionTimeout=" + c.Mongo.ServerSelectionTimeout.String(),
+		"limits.store=" + c.Limits.Store,
+		"limits.ipRate=" + strconv.FormatFloat(c.Limits.IPRate, 'g', -1, 64),
+		"limits.ipBurst=" + strconv.Itoa(c.Limits.IPBurst),
+		"limits.userRate=" + strconv.FormatFloat(c.Limits.UserRate, 'g', -1, 64),
+		"limits.userBurst=" + strconv.Itoa(c.Limits.UserBurst),
+		"limits.dailyLikes=" + strconv.Itoa(c.Limits.DailyLikes),
+		"limits.dailySuperlikes=" + strconv.Itoa(c.Limits.DailySuperlikes),
+		"limits.quotaReset=" + c.Limits.QuotaReset,
+		"limits.ratingBatchSize=" + strconv.Itoa(c.Limits.RatingBatchSize),
+		"matches.expireAfter=" + c.Matches.ExpireAfter.String(),
+		"matches.expiryInterval=" + c.Matches.ExpiryInterval.String(),
+		"accounts.deletionGracePeriod=" + c.Accounts.DeletionGracePeriod.String(),
+		"accounts.purgeInterval=" + c.Accounts.PurgeInterval.String(),
+		"photos.dir=" + c.Photos.Dir,
+		"photos.baseUrl=" + c.Photos.BaseURL,
+		"photos.maxBytes=" + strconv.Itoa(c.Photos.MaxBytes),
+		"photos.maxPerUser=" + strconv.Itoa(c.Photos.MaxPerUser),
+		"photos.thumbnailSize=" + strconv.Itoa(c.Photos.ThumbnailSize),
+		"content.maxBioLength=" + strconv.Itoa(c.Content.MaxBioLength),
+		"content.maxReasonLength=" + strconv.Itoa(c.Content.MaxReasonLength),
+		"content.bannedWordsFile=" + c.Content.BannedWordsFile,
+		"api.legacySunset=" + c.API.LegacySunset,
+		"grpc.port=" + c.GRPC.Port,
+		"grpc.services=" + strings.Join(c.GRPC.serviceNames(), ","),
+		"admin.proxyToken=" + proxyToken,
+		"webhooks.interval=" + c.Webhooks.Interval.String(),
+		"webhooks.timeout=" + c.Webhooks.Timeout.String(),
+		"webhooks.maxAttempts=" + strconv.Itoa(c.Webhooks.MaxAttempts),
+		"webhooks.backoff=" + c.Webhooks.Backoff.String(),
+		"webhooks.maxBackoff=" + c.Webhooks.MaxBackoff.String(),
+		"notifications.interval=" + c.Notifications.Interval.String(),
+		"notifications.timeout=" + c.Notifications.Timeout.String(),
+		"notifications.maxAttempts=" + strconv.Itoa(c.Notifications.MaxAttempts),
+		"notifications.likeDigest=" + c.Notifications.LikeDigest.String(),
+		"notifications.emailTransport=" + c.Notifications.EmailTransport,
+		"notifications.pushTransport=" + c.Notifications.PushTransport,
+		"notifications.smtp.host=" + c.Notifications.SMTP.Host,
+		"notifications.smtp.port=" + c.Notifications.SMTP.Port,
+		"notifications.smtp.username=" + c.Notifications.SMTP.Username,
+		"notifications.smtp.password=" + smtpPassword,
+		"notifications.smtp.from=" + c.Notifications.SMTP.From,
+		"cache.store=" + c.Cache.Store,
+		"cache.size=" + strconv.Itoa(c.Cache.Size),
+		"cache.ttl=" + c.Cache.TTL.String(),
+		"cache.watch=" + strconv.FormatBool(c.Cache.Watch),
+		"features.seedOnStartup=" + strconv.FormatBool(c.Features.SeedOnStartup),
+		"features.migrateOnStartup=" + strconv.FormatBool(c.Features.MigrateOnStartup),
+		"features.rateLimiting=" + strconv.FormatBool(c.Features.RateLimiting),
+		"features.likeQuota=" + strconv.FormatBool(c.Features.LikeQuota),
+		"features.matchExpiry=" + strconv.FormatBool(c.Features.MatchExpiry),
+		"features.grpc=" + strconv.FormatBool(c.Features.GRPC),
+		"features.admin=" + strconv.FormatBool(c.Features.Admin),
+		"features.webhooks=" + strconv.FormatBool(c.Features.Webhooks),
+		"features.notifications=" + strconv.FormatBool(c.Features.Notifications),
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// serviceNames lists the services with a grpc token, without the tokens, for logs
+func (g *GRPCConfig) serviceNames() []string {
+	services, _ := g.Services()
+	names := make([]string, 0, len(services))
+	for _, name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hide the password of a connection string, if it has one
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "<invalid uri>"
+	}
+	return u.Redacted()
+}
+
+// envReader reads typed environment variables, keeping the first parse error
+type envReader struct {
+	err error
+}
+
+func (e *envReader) lookup(key string) (string, bool) {
+	if e.err != nil {
+		return "", false
+	}
+	v, ok := os.LookupEnv(key)
+	return v, ok && v != ""
+}
+
+func (e *envReader) string(key string, dst *string) {
+	if v, ok := e.lookup(key); ok {
+		*dst = v
+	}
+}
+
+func (e *envReader) bool(key string, dst *bool) {
+	if v, ok := e.lookup(key); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e.err = fmt.Errorf("invalid boolean for %s: %q", key, v)
+			return
+		}
+		*dst = b
+	}
+}
+
+func (e *envReader) int(key string, dst *int) {
+	if v, ok := e.lookup(key); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.err = fmt.Errorf("invalid number for %s: %q", key, v)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) float(key string, dst *float64) {
+	if v, ok := e.lookup(key); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			e.err = fmt.Errorf("invalid number for %s: %q", key, v)
+			return
+		}
+		*dst = f
+	}
+}
+
+func (e *envReader) uint(key string, dst *uint64) {
+	if v, ok := e.lookup(key); ok {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			e.err = fmt.Errorf("invalid number for %s: %q", key, v)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) duration(key string, dst *time.Duration) {
+	if v, ok := e.lookup(key); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.err = fmt.Errorf("invalid duration for %s: %q", key, v)
+			return
+		}
+		*dst = d
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ContentField is a user written field that goes through the content filter
+type ContentField string
+
+const (
+	FieldBio    ContentField = "bio"
+	FieldReason ContentField = "reason"
+)
+
+// Verdict is what to do with a piece of content. Higher verdicts win
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	// NeedsReview saves the content but queues it for a moderator
+	NeedsReview
+	Reject
+)
+
+// ContentCheck is one step of the content filter. It returns Allow with no reason for content
+// it has no problem with
+type ContentCheck interface {
+	Check(field ContentField, text string) (Verdict, string)
+}
+
+// ContentResult is the outcome of running content through every check
+type ContentResult struct {
+	Verdict Verdict
+	Reasons []string
+}
+
+// ContentFilter runs content through a pipeline of checks, keeping the strictest verdict
+type ContentFilter struct {
+	checks []ContentCheck
+}
+
+// NewContentFilter is a constructor for a ContentFilter with the checks given in cfg
+func NewContentFilter(cfg *ContentConfig) (*ContentFilter, error) {
+	f := &ContentFilter{}
+
+	f.Add(&maxLengthCheck{limits: map[ContentField]int{
+		FieldBio:    cfg.MaxBioLength,
+		FieldReason: cfg.MaxReasonLength,
+	}})
+
+	if cfg.BannedWordsFile != "" {
+		words, err := LoadBannedWords(cfg.BannedWordsFile)
+		if err != nil {
+			return nil, err
+		}
+		f.Add(words)
+	}
+
+	f.Add(&contactInfoCheck{fields: map[ContentField]bool{FieldBio: true}})
+
+	return f, nil
+}
+
+// Add appends a check to the pipeline
+func (f *ContentFilter) Add(c ContentCheck) {
+	f.checks = append(f.checks, c)
+}
+
+// Check runs text through every check
+func (f *ContentFilter) Check(field ContentField, text string) *ContentResult {
+	result := &ContentResult{Verdict: Allow}
+	if text == "" {
+		return result
+	}
+
+	for _, c := range f.checks {
+		verdict, reason := c.Check(field, text)
+		if verdict > result.Verdict {
+			result.Verdict = verdict
+		}
+		if verdict != Allow {
+			result.Reasons = append(result.Reasons, reason)
+		}
+	}
+
+	return result
+}
+
+// maxLengthCheck rejects content longer than its field's limit, counted in characters
+type maxLengthCheck struct {
+	limits map[ContentField]int
+}
+
+func (c *maxLengthCheck) Check(field ContentField, text string) (Verdict, string) {
+	limit, ok := c.limits[field]
+	if ok && utf8.RuneCountInString(text) > limit {
+		return Reject, fmt.Sprintf("%s is longer than %d characters", field, limit)
+	}
+	return Allow, ""
+}
+
+// BannedWords rejects content containing any of its words, or sends it for review for
+// borderline ones. Words match whole and ignoring case
+type BannedWords struct {
+	words map[string]Verdict
+}
+
+// LoadBannedWords reads a banned word list, one word or phrase per line. Lines starting with
+// "?" are borderline and only need review, and lines starting with "#" are comments
+func LoadBannedWords(path string) (*BannedWords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, NewErrorf("error opening banned words file: %s", err)
+	}
+	defer f.Close()
+
+	b := &BannedWords{words: make(map[string]Verdict)}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		verdict := Reject
+		if strings.HasPrefix(line, "?") {
+			verdict = NeedsReview
+			line = line[1:]
+		}
+
+		if word := normalizeWords(line); word != "" {
+			b.words[word] = verdict
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, NewErrorf("error reading banned words file: %s", err)
+	}
+
+	return b, nil
+}
+
+func (b *BannedWords) Check(field ContentField, text string) (Verdict, string) {
+	// padded with spaces so phrases only match whole words
+	normalized := " " + normalizeWords(text) + " "
+
+	worst, found := Allow, ""
+	for word, verdict := range b.words {
+		if verdict > worst && strings.Contains(normalized, " "+word+" ") {
+			worst, found = verdict, word
+		}
+	}
+
+	switch worst {
+	case Reject:
+		return Reject, fmt.Sprintf("%s contains a banned word", field)
+	case NeedsReview:
+		return NeedsReview, fmt.Sprintf("%s contains %q", field, found)
+	}
+	return Allow, ""
+}
+
+// normalizeWords lower cases text and separates words with single spaces, dropping punctuation
+func normalizeWords(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+var (
+	emailPattern = regexp.MustCompile(`(?i)[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}`)
+	urlPattern   = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+	// a domain without a scheme or www could also be two sentences run together, "jazz.Me too"
+	domainPattern = regexp.MustCompile(`(?i)\b[a-z0-9\-]+\.(com|net|org|io|me|co|app|link|ly|gg)\b`)
+	// seven or more digits, allowing the separators phone numbers are written with
+	phonePattern = regexp.MustCompile(`\+?\d(?:[\s.\-()]*\d){6,}`)
+	// contact details written to get past the patterns above, e.g. "jen at gmail dot com" or "insta: @jen"
+	// the app has to be followed by a separator or handle, so "science" or "instant" don't count
+	obfuscatedPattern = regexp.MustCompile(`(?i)\b(at|@)\s*\w+\s*(dot|\.)\s*(com|net|org)\b|\b(insta(gram)?|snap(chat)?|whatsapp|telegram|ig|sc)\b\s*([:\-]\s*@?|@)\w+|@\w{3,}`)
+)
+
+// contactInfoCheck keeps emails, urls and phone numbers out of fields where users could use them
+// to move the conversation off the app. Likely attempts to get around it need review
+type contactInfoCheck struct {
+	fields map[ContentField]bool
+}
+
+func (c *contactInfoCheck) Check(field ContentField, text string) (Verdict, string) {
+	if !c.fields[field] {
+		return Allow, ""
+	}
+
+	switch {
+	case emailPattern.MatchString(text):
+		return Reject, fmt.Sprintf("%s cannot contain an email address", field)
+	case urlPattern.MatchString(text):
+		return Reject, fmt.Sprintf("%s cannot contain a link", field)
+	case phonePattern.MatchString(text):
+		return Reject, fmt.Sprintf("%s cannot contain a phone number", field)
+	case domainPattern.MatchString(text):
+		return NeedsReview, fmt.Sprintf("%s may contain a link", field)
+	case obfuscatedPattern.MatchString(text):
+		return NeedsReview, fmt.Sprintf("%s may contain contact details", field)
+	}
+
+	return Allow, ""
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentFilter_Check(t *testing.T) {
+	f, err := NewContentFilter(&ContentConfig{MaxBioLength: 40, MaxReasonLength: 40})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		field   ContentField
+		text    string
+		verdict Verdict
+		reasons []string
+	}{
+		{"empty", FieldBio, "", Allow, nil},
+		{"plain", FieldBio, "I like hiking", Allow, nil},
+		{"too long", FieldBio, strings.Repeat("a", 41), Reject, []string{"bio is longer than 40 characters"}},
+		{"counts characters", FieldBio, strings.Repeat("é", 40), Allow, nil},
+		{"email", FieldBio, "jen@example.com", Reject, []string{"bio cannot contain an email address"}},
+		{"link", FieldBio, "see www.jen.dev", Reject, []string{"bio cannot contain a link"}},
+		{"link with scheme", FieldBio, "https://jen.example", Reject, []string{"bio cannot contain a link"}},
+		{"bare domain", FieldBio, "find me on jen.io", NeedsReview, []string{"bio may contain a link"}},
+		{"sentences run together", FieldBio, "I love jazz.Me too", NeedsReview, []string{"bio may contain a link"}},
+		{"phone", FieldBio, "call 555-123-4567", Reject, []string{"bio cannot contain a phone number"}},
+		{"handle", FieldBio, "insta: jen_92", NeedsReview, []string{"bio may contain contact details"}},
+		{"handle with at", FieldBio, "snap @jen", NeedsReview, []string{"bio may contain contact details"}},
+		{"spelled out", FieldBio, "jen at gmail dot com", NeedsReview, []string{"bio may contain contact details"}},
+		{"starts like an app", FieldBio, "I teach science at school", Allow, nil},
+		{"starts like a handle", FieldBio, "Scuba diver, ignore the rest", Allow, nil},
+		{"starts like insta", FieldBio, "Instant coffee lover", Allow, nil},
+		{"app without a handle", FieldBio, "not on instagram much", Allow, nil},
+		{"reason contact", FieldReason, "sent me spam from bad.com", Allow, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := f.Check(tt.field, tt.text)
+			assert.Equal(t, tt.verdict, result.Verdict)
+			assert.Equal(t, tt.reasons, result.Reasons)
+		})
+	}
+}
+
+func TestLoadBannedWords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	list := "# comments are skipped\nfreak\n?Hook Up\n\n"
+	assert.Nil(t, os.WriteFile(path, []byte(list), 0644))
+
+	words, err := LoadBannedWords(path)
+	assert.Nil(t, err)
+
+	verdict, reason := words.Check(FieldBio, "Total FREAK!")
+	assert.Equal(t, Reject, verdict)
+	assert.Equal(t, "bio contains a banned word", reason)
+
+	verdict, reason = words.Check(FieldBio, "looking to hook-up")
+	assert.Equal(t, NeedsReview, verdict)
+	assert.Equal(t, `bio contains "hook up"`, reason)
+
+	// only whole words match
+	verdict, _ = words.Check(FieldBio, "freaky friday fan")
+	assert.Equal(t, Allow, verdict)
+
+	_, err = LoadBannedWords(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.NotNil(t, err)
+}
@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
 	"log"
-	"os"
-	"time"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -16,25 +14,94 @@ import (
 // DB abstracts database clients
 type DB struct {
 	MongoClient *mongo.Database
+	// Transactions is on when the deployment supports them
+	Transactions bool
+	// UserCache holds recently read users, nil when caching is off
+	UserCache UserCache
 }
 
 // NewDB is a constructor for initializing the database connections
-func NewDB() *DB {
+func NewDB(cfg *MongoConfig) *DB {
 	return &DB{
-		MongoClient: initMongo(),
+		MongoClient:  initMongo(cfg),
+		Transactions: cfg.Transactions,
 	}
 }
 
+type transactionKey struct{}
+
+// txn is a transaction in progress
+type txn struct {
+	committed []func()
+}
+
+// transaction runs fn in a transaction, so its writes all happen or none do. fn must do every
+// read and write with the context it's given, and may be run again if the transaction has to
+// be retried. Without transactions, or within one already, fn runs as is
+func (db *DB) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !db.Transactions || inTransaction(ctx) {
+		return fn(ctx)
+	}
+
+	var tx *txn
+	err := db.MongoClient.Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			// a retry starts over
+			tx = &txn{}
+			return nil, fn(context.WithValue(sc, transactionKey{}, tx))
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range tx.committed {
+		f()
+	}
+	return nil
+}
+
+// inTransaction tells if ctx is within a transaction, whose writes others can't see yet
+func inTransaction(ctx context.Context) bool {
+	return ctx.Value(transactionKey{}) != nil
+}
+
+// afterCommit runs f once the transaction ctx is in commits, or right away outside of one
+func afterCommit(ctx context.Context, f func()) {
+	if tx, ok := ctx.Value(transactionKey{}).(*txn); ok {
+		tx.committed = append(tx.committed, f)
+		return
+	}
+	f()
+}
+
 // called from main, connect to mongo
-func initMongo() *mongo.Database {
-	mongoHost := os.Getenv("MONGO_HOST")
-	mongoPort := os.Getenv("MONGO_PORT")
+func initMongo(cfg *MongoConfig) *mongo.Database {
+	opts := options.Client().
+		ApplyURI(cfg.ConnectionURI()).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetServerSelectionTimeout(cfg.ServerSelectionTimeout).
+		SetMonitor(newMongoMonitor())
+
+	if cfg.Username != "" {
+		opts.SetAuth(options.Credential{
+			AuthSource: cfg.AuthSource,
+			Username:   cfg.Username,
+			Password:   cfg.Password,
+		})
+	}
+
+	if cfg.ReplicaSet != "" {
+		opts.SetReplicaSet(cfg.ReplicaSet)
+	}
 
-	if mongoHost == "" || mongoPort == "" {
-		log.Fatal("error MONGO_HOST or MONGO_PORT does not exist.")
+	if cfg.TLS {
+		opts.SetTLSConfig(&tls.Config{})
 	}
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%s", mongoHost, mongoPort)))
+	client, err := mongo.NewClient(opts)
 	if err != nil {
 		log.Fatal("error creating a mongo client: ", err)
 	}
@@ -49,180 +116,103 @@ func initMongo() *mongo.Database {
 		log.Fatal("error pinging the mongo server: ", err)
 	}
 
-	return client.Database("backend-homework")
+	return client.Database(cfg.Database)
 }
 
-// PopulateDatabase sets up sample users and sample "likes" between the users
-func PopulateDatabase(db *DB) {
+// PopulateDatabase loads the named built in fixture, only if the database has no users yet
+func PopulateDatabase(ctx context.Context, db *DB, fixture string) error {
 	usersColl := db.MongoClient.Collection("users")
-	ctx := context.Background()
 
 	// if no users in db, add defaults
 	c, err := usersColl.CountDocuments(ctx, bson.M{})
 	if err != nil {
-		log.Fatal("error counting users from mongo: ", err)
+		return NewErrorf("error counting users from mongo: %s", err)
 	}
 
 	if c != 0 {
-		return
+		return nil
 	}
 
-	users := createUserData()
-	ui := make([]interface{}, 0, len(users))
-
-	for _, v := range users {
-		ui = append(ui, v)
+	f, err := LoadFixture(fixture)
+	if err != nil {
+		return err
 	}
 
-	if _, err := usersColl.InsertMany(ctx, ui); err != nil {
-		log.Fatal("error inserting users: ", err)
+	return SeedDatabase(ctx, db, f)
+}
+
+// SeedDatabase inserts the users and ratings of a fixture as they are, then creates the
+// matches their likes imply
+func SeedDatabase(ctx context.Context, db *DB, f *Fixture) error {
+	if err := InsertUsers(ctx, db, f.Users); err != nil {
+		return err
 	}
 
-	likes := createRatingsData()
-	li := make([]interface{}, 0, len(likes))
+	if err := InsertRatings(ctx, db, f.Ratings); err != nil {
+		return err
+	}
 
-	for _, v := range likes {
-		li = append(li, v)
+	details := bson.M{"fixture": f.Name, "users": len(f.Users), "ratings": len(f.Ratings)}
+	if err := recordChange(ctx, db, "database.seed", "database", db.MongoClient.Name(), nil, nil, details); err != nil {
+		return err
 	}
 
-	ratingsColl := db.MongoClient.Collection("ratings")
-	if _, err := ratingsColl.InsertMany(ctx, li); err != nil {
-		log.Fatal("error inserting likes: ", err)
+	_, err := BackfillMatches(ctx, db)
+	return err
+}
+
+// ResetDatabase removes all users, ratings and matches. Indexes and the audit log are kept
+func ResetDatabase(ctx context.Context, db *DB) error {
+	details := bson.M{}
+	for _, name := range []string{"users", "ratings", "matches"} {
+		res, err := db.MongoClient.Collection(name).DeleteMany(ctx, bson.M{})
+		if err != nil {
+			return NewErrorf("error clearing %s: %s", name, err)
+		}
+		details[name] = res.DeletedCount
 	}
+	db.forgetAllUsers(ctx)
+
+	return recordChange(ctx, db, "database.reset", "database", db.MongoClient.Name(), nil, nil, details)
 }
 
-// sample user data
-func createUserData() []*User {
-	return []*User{
-		{
-			Age:         30,
-			Bio:         "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.",
-			CreatedDate: time.Now(),
-			ID:          "5e2e39ee290f5a56ffda9ed5",
-			JobTitle:    "Software Engineer",
-			Name:        "Jennifer",
-		},
-		{
-			Age:         43,
-			Bio:         "Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat",
-			CreatedDate: time.Now(),
-			ID:          "5e2e39ee290f5a56ffda9ed6",
-			JobTitle:    "Musician",
-			Name:        "Bob",
-		},
-		{
-			Age:         22,
-			Bio:         "Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.",
-			CreatedDate: time.Now(),
-			ID:          "5e2e39ee290f5a56ffda9ed7",
-			JobTitle:    "Professor",
-			Name:        "Susan",
-		},
-		{
-			Age:         27,
-			Bio:         "Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.",
-			CreatedDate: time.Now(),
-			ID:          "5e2e39ee290f5a56ffda9ed8",
-			JobTitle:    "Professional Dancer",
-			Name:        "Michael",
-		},
-		{
-			Age:         35,
-			Bio:         "Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.",
-			CreatedDate: time.Now(),
-			ID:          "5e2e39ee290f5a56ffda9ed9",
-			JobTitle:    "Accountant",
-			Name:        "Alexis",
-		},
-		{
-			Age:         38,
-			Bio:         "Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.",
-			CreatedDate: time.Now(),
-			ID:          "5e2e39ee290f5a56ffda9eda",
-			JobTitle:    "Security Officer",
-			Name:        "Andrew",
-		},
+// insertBatchSize caps how many documents go in a single insert request
+const insertBatchSize = 1000
+
+// insertMany inserts documents in batches, doing nothing for an empty list
+func insertMany(ctx context.Context, coll *mongo.Collection, docs []interface{}) error {
+	for start := 0; start < len(docs); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		if _, err := coll.InsertMany(ctx, docs[start:end]); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-// sample ratings data
-func createRatingsData() []*Rating {
-	// Bob like Susan
-	// Bob likes Michael
-	// Alexis likes Michael
-	// Alexis likes Andrew
-	// {Everyone} likes Jennifer
-	// Jennifer likes Michael (match)
-	return []*Rating{
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed5",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed8",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed6",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed5",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed6",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed7",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed6",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed8",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed7",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed5",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed8",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed5",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed9",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed5",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed9",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed8",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9ed9",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9eda",
-			Type:        LIKE,
-		},
-		{
-			CreatedDate: time.Now(),
-			FromUserID:  "5e2e39ee290f5a56ffda9eda",
-			ID:          primitive.NewObjectID().Hex(),
-			ToUserID:    "5e2e39ee290f5a56ffda9ed5",
-			Type:        LIKE,
-		},
+// isDuplicateKeyError reports whether a write failed because of a unique index
+func isDuplicateKeyError(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == 11000 {
+				return true
+			}
+		}
+	case mongo.BulkWriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == 11000 {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return e.Code == 11000
 	}
+
+	return err != nil && strings.Contains(err.Error(), "E11000")
 }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserUpdate is the body of a profile edit. Only the fields given are changed
+type UserUpdate struct {
+	Age      int    `json:"age,omitempty"`
+	Bio      string `json:"bio,omitempty"`
+	JobTitle string `json:"jobTitle,omitempty"`
+	Location string `json:"location,omitempty"`
+	Name     string `json:"name,omitempty"`
+	// Notifications replace the stored preferences as a whole
+	Notifications *NotificationPreferences `json:"notifications,omitempty"`
+}
+
+// User maps the edit onto the user with the given id
+func (u *UserUpdate) User(id string) *User {
+	return &User{
+		Age:           u.Age,
+		Bio:           u.Bio,
+		ID:            DocID(id),
+		JobTitle:      u.JobTitle,
+		Location:      u.Location,
+		Name:          u.Name,
+		Notifications: u.Notifications,
+	}
+}
+
+// Validate checks the fields that have rules of their own. Bios go through the content filter
+func (u *UserUpdate) Validate() error {
+	if u.Notifications != nil {
+		return u.Notifications.Validate()
+	}
+	return nil
+}
+
+// AdminUserUpdate is a profile edit by an admin, who can also change the user's role
+type AdminUserUpdate struct {
+	UserUpdate
+	Role Role `json:"role,omitempty"`
+}
+
+// User maps the edit onto the user with the given id
+func (u *AdminUserUpdate) User(id string) *User {
+	user := u.UserUpdate.User(id)
+	user.Role = u.Role
+	return user
+}
+
+// RatingRequest is the body of a new rating
+type RatingRequest struct {
+	Reason   string     `json:"reason,omitempty"`
+	ToUserID string     `json:"toUserId,omitempty"`
+	Type     RatingType `json:"type,omitempty"`
+}
+
+// Rating maps the request onto a rating from the given user
+func (r *RatingRequest) Rating(fromUserID string) *Rating {
+	return &Rating{
+		FromUserID: fromUserID,
+		Reason:     r.Reason,
+		ToUserID:   r.ToUserID,
+		Type:       r.Type,
+	}
+}
+
+// ProfileView is a user as other users see them
+type ProfileView struct {
+	Age      int          `json:"age,omitempty"`
+	Bio      string       `json:"bio,omitempty"`
+	ID       string       `json:"id"`
+	JobTitle string       `json:"jobTitle,omitempty"`
+	Location string       `json:"location,omitempty"`
+	Name     string       `json:"name,omitempty"`
+	Photos   []*PhotoView `json:"photos"`
+}
+
+// NewProfileView maps a user to their public profile
+func NewProfileView(u *User) *ProfileView {
+	if u == nil {
+		return nil
+	}
+
+	photos := make([]*PhotoView, 0, len(u.Photos))
+	for _, p := range u.Photos {
+		photos = append(photos, NewPhotoView(p))
+	}
+
+	return &ProfileView{
+		Age:      u.Age,
+		Bio:      u.Bio,
+		ID:       string(u.ID),
+		JobTitle: u.JobTitle,
+		Location: u.Location,
+		Name:     u.Name,
+		Photos:   photos,
+	}
+}
+
+// NewProfileViews maps users to their public profiles
+func NewProfileViews(users []*User) []*ProfileView {
+	views := make([]*ProfileView, 0, len(users))
+	for _, u := range users {
+		views = append(views, NewProfileView(u))
+	}
+	return views
+}
+
+// AccountView is a user as they see themselves, and as moderators see them: their profile
+// along with the state of their account
+type AccountView struct {
+	*ProfileView
+	CreatedDate   time.Time                `json:"createdDate"`
+	DeletedDate   *time.Time               `json:"deletedDate,omitempty"`
+	Notifications *NotificationPreferences `json:"notifications,omitempty"`
+	Role          Role                     `json:"role"`
+	Status        UserStatus               `json:"status"`
+}
+
+// NewAccountView maps a user to their own view of their account
+func NewAccountView(u *User) *AccountView {
+	if u == nil {
+		return nil
+	}
+
+	// users from before roles and statuses have neither stored
+	role, status := u.Role, u.Status
+	if role == "" {
+		role = RoleUser
+	}
+	if status == "" {
+		status = UserActive
+	}
+
+	return &AccountView{
+		ProfileView:   NewProfileView(u),
+		CreatedDate:   u.CreatedDate,
+		DeletedDate:   u.DeletedDate,
+		Notifications: u.Notifications,
+		Role:          role,
+		Status:        status,
+	}
+}
+
+// NewAccountViews maps users to the full view of their accounts
+func NewAccountViews(users []*User) []*AccountView {
+	views := make([]*AccountView, 0, len(users))
+	for _, u := range users {
+		views = append(views, NewAccountView(u))
+	}
+	return views
+}
+
+// PhotoView is a photo on a profile. The first one is the primary photo
+type PhotoView struct {
+	Height       int    `json:"height"`
+	ID           string `json:"id"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	URL          string `json:"url"`
+	Width        int    `json:"width"`
+}
+
+// NewPhotoView maps a stored photo to what clients need to show it
+func NewPhotoView(p *Photo) *PhotoView {
+	return &PhotoView{
+		Height:       p.Height,
+		ID:           string(p.ID),
+		ThumbnailURL: p.ThumbnailURL,
+		URL:          p.URL,
+		Width:        p.Width,
+	}
+}
+
+// RatingView is a rating one user gave another. When it was given is only shown in exports
+type RatingView struct {
+	CreatedDate *time.Time `json:"createdDate,omitempty"`
+	FromUserID  string     `json:"fromUserId"`
+	ID          string     `json:"id"`
+	Reason      string     `json:"reason,omitempty"`
+	ToUserID    string     `json:"toUserId"`
+	Type        RatingType `json:"type"`
+}
+
+// NewRatingView maps a stored rating
+func NewRatingView(r *Rating) *RatingView {
+	return &RatingView{
+		FromUserID: r.FromUserID,
+		ID:         string(r.ID),
+		Reason:     r.Reason,
+		ToUserID:   r.ToUserID,
+		Type:       r.Type,
+	}
+}
+
+// NewRatingViews maps stored ratings
+func NewRatingViews(ratings []*Rating) []*RatingView {
+	views := make([]*RatingView, 0, len(ratings))
+	for _, r := range ratings {
+		views = append(views, NewRatingView(r))
+	}
+	return views
+}
+
+// MatchView is a match between two users. In a user's list of matches it has the other user
+type MatchView struct {
+	CreatedDate      time.Time    `json:"createdDate"`
+	EndedBy          string       `json:"endedBy,omitempty"`
+	EndedDate        *time.Time   `json:"endedDate,omitempty"`
+	ExpiresAt        *time.Time   `json:"expiresAt,omitempty"`
+	ID               string       `json:"id"`
+	LastActivityDate time.Time    `json:"lastActivityDate"`
+	Status           MatchStatus  `json:"status"`
+	User             *ProfileView `json:"user,omitempty"`
+	UserIDs          []string     `json:"userIds"`
+}
+
+// NewMatchView maps a stored match
+func NewMatchView(m *Match) *MatchView {
+	return &MatchView{
+		CreatedDate:      m.CreatedDate,
+		EndedBy:          m.EndedBy,
+		EndedDate:        m.EndedDate,
+		ExpiresAt:        m.ExpiresAt,
+		ID:               string(m.ID),
+		LastActivityDate: m.LastActivityDate,
+		Status:           m.Status,
+		UserIDs:          m.UserIDs,
+	}
+}
+
+// NewUserMatchViews maps a user's matches, with the other user's public profile
+func NewUserMatchViews(matches []*UserMatch) []*MatchView {
+	views := make([]*MatchView, 0, len(matches))
+	for _, m := range matches {
+		v := NewMatchView(m.Match)
+		v.User = NewProfileView(m.User)
+		views = append(views, v)
+	}
+	return views
+}
+
+// ExportView is everything stored about a user, for them to download. Unlike elsewhere, their
+// ratings say when they were given
+type ExportView struct {
+	Activity        []*AuditEntry    `json:"activity"`
+	Events          []*EventView     `json:"events"`
+	ExportedDate    time.Time        `json:"exportedDate"`
+	Matches         []*MatchView     `json:"matches"`
+	Notifications   []*Notification  `json:"notifications"`
+	Profile         *AccountView     `json:"profile"`
+	RatingsGiven    []*RatingView    `json:"ratingsGiven"`
+	RatingsReceived []*RatingView    `json:"ratingsReceived"`
+	ReportsFiled    []*RatingView    `json:"reportsFiled"`
+	Reviews         []*ContentReview `json:"reviews"`
+}
+
+// EventView is an event as webhooks are sent it
+type EventView struct {
+	CreatedDate time.Time       `json:"createdDate"`
+	Data        json.RawMessage `json:"data"`
+	ID          DocID           `json:"id"`
+	Type        string          `json:"type"`
+}
+
+// NewEventView maps a stored event
+func NewEventView(e *Event) *EventView {
+	return &EventView{
+		CreatedDate: e.CreatedDate,
+		Data:        json.RawMessage(e.Data),
+		ID:          e.ID,
+		Type:        e.Type,
+	}
+}
+
+// NewExportView maps a user export
+func NewExportView(e *UserExport) *ExportView {
+	matches := make([]*MatchView, 0, len(e.Matches))
+	for _, m := range e.Matches {
+		matches = append(matches, NewMatchView(m))
+	}
+
+	dated := func(ratings []*Rating) []*RatingView {
+		views := make([]*RatingView, 0, len(ratings))
+		for _, r := range ratings {
+			v := NewRatingView(r)
+			createdDate := r.CreatedDate
+			v.CreatedDate = &createdDate
+			views = append(views, v)
+		}
+		return views
+	}
+
+	events := make([]*EventView, 0, len(e.Events))
+	for _, ev := range e.Events {
+		events = append(events, NewEventView(ev))
+	}
+
+	return &ExportView{
+		Activity:        e.Activity,
+		Events:          events,
+		ExportedDate:    e.ExportedDate,
+		Matches:         matches,
+		Notifications:   e.Notifications,
+		Profile:         NewAccountView(e.Profile),
+		RatingsGiven:    dated(e.RatingsGiven),
+		RatingsReceived: dated(e.RatingsReceived),
+		ReportsFiled:    dated(e.ReportsFiled),
+		Reviews:         e.Reviews,
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProfileView(t *testing.T) {
+	deleted := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	u := &User{
+		ID:          "5e2e39ee290f5a56ffda9ed5",
+		Name:        "Jennifer",
+		Role:        RoleAdmin,
+		Status:      UserDeleted,
+		DeletedDate: &deleted,
+		Photos:      []*Photo{{ID: "p1", Key: "photos/x/p1.jpg", URL: "/blobs/photos/x/p1.jpg", Size: 100}},
+	}
+
+	b, err := json.Marshal(NewProfileView(u))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"id": "5e2e39ee290f5a56ffda9ed5",
+		"name": "Jennifer",
+		"photos": [{"id": "p1", "url": "/blobs/photos/x/p1.jpg", "thumbnailUrl": "", "width": 0, "height": 0}]
+	}`, string(b))
+
+	assert.Nil(t, NewProfileView(nil))
+}
+
+func TestNewAccountView(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// users from before roles and statuses are plain active users
+	v := NewAccountView(&User{ID: "a", CreatedDate: created})
+	assert.Equal(t, RoleUser, v.Role)
+	assert.Equal(t, UserActive, v.Status)
+
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id": "a", "photos": [], "createdDate": "2020-01-01T00:00:00Z", "role": "user", "status": "active"}`, string(b))
+}
+
+func TestNewExportView(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &Rating{ID: "r", CreatedDate: created, FromUserID: "a", ToUserID: "b", Type: LIKE}
+
+	// ratings don't say when they were given, except in the export of the user's own data
+	assert.Nil(t, NewRatingView(r).CreatedDate)
+
+	e := NewExportView(&UserExport{Profile: &User{ID: "a"}, RatingsGiven: []*Rating{r}})
+	assert.Equal(t, &created, e.RatingsGiven[0].CreatedDate)
+	assert.Equal(t, "a", e.Profile.ID)
+	assert.Empty(t, e.RatingsReceived)
+
+	ev := &Event{CreatedDate: created, Data: `{"id":"a"}`, ID: "e", Status: EventPending, Type: EventUserUpdated}
+	e = NewExportView(&UserExport{Events: []*Event{ev}, Profile: &User{ID: "a"}})
+	b, err := json.Marshal(e.Events[0])
+	assert.Nil(t, err)
+	body, err := ev.Body()
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(body), string(b), "events are exported as webhooks are sent them")
+}
+
+func TestRequestMappers(t *testing.T) {
+	u := (&AdminUserUpdate{UserUpdate: UserUpdate{Name: "Bob"}, Role: RoleModerator}).User("a")
+	assert.Equal(t, &User{ID: "a", Name: "Bob", Role: RoleModerator}, u)
+
+	r := (&RatingRequest{ToUserID: "b", Type: REPORT, Reason: "spam"}).Rating("a")
+	assert.Equal(t, &Rating{FromUserID: "a", ToUserID: "b", Type: REPORT, Reason: "spam"}, r)
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DumpFormat is how a dump is written: one json document per line, or csv with a header row
+type DumpFormat string
+
+const (
+	DumpNDJSON DumpFormat = "ndjson"
+	DumpCSV    DumpFormat = "csv"
+)
+
+const (
+	// dumpFlushEvery is how many documents are written between flushes to the client
+	dumpFlushEvery = 1000
+	// maxDumpLineSize is the longest ndjson line an import reads
+	maxDumpLineSize = 1 << 20
+	// maxImportErrors caps how many invalid records an import describes. All of them are counted
+	maxImportErrors = 100
+
+	// dumpCountTrailer and dumpErrorTrailer end a dump, since its status was sent before the
+	// first document. A dump without a count was cut off
+	dumpCountTrailer = "X-Dump-Count"
+	dumpErrorTrailer = "X-Dump-Error"
+)
+
+// ParseDumpFormat reads a format name. Empty is ndjson
+func ParseDumpFormat(s string) (DumpFormat, error) {
+	switch DumpFormat(s) {
+	case "", DumpNDJSON:
+		return DumpNDJSON, nil
+	case DumpCSV:
+		return DumpCSV, nil
+	}
+	return "", NewErrorf("format must be one of %s, %s", DumpNDJSON, DumpCSV)
+}
+
+// ContentType is sent with dumps in the format
+func (f DumpFormat) ContentType() string {
+	if f == DumpCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// DumpParams filters a dump. From and To are of the created date, From inclusive and To
+// exclusive. Types only apply to ratings
+type DumpParams struct {
+	From  time.Time
+	To    time.Time
+	Types []RatingType
+}
+
+// query builds the mongo filter for the dump
+func (p *DumpParams) query() bson.M {
+	q := bson.M{}
+
+	created := bson.M{}
+	if !p.From.IsZero() {
+		created["$gte"] = p.From
+	}
+	if !p.To.IsZero() {
+		created["$lt"] = p.To
+	}
+	if len(created) > 0 {
+		q["createdDate"] = created
+	}
+
+	if len(p.Types) > 0 {
+		q["type"] = bson.M{"$in": p.Types}
+	}
+
+	return q
+}
+
+// bindDates reads the from and to query params, RFC 3339 times
+func (p *DumpParams) bindDates(c *gin.Context) error {
+	for name, dst := range map[string]*time.Time{"from": &p.From, "to": &p.To} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return NewErrorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+	return nil
+}
+
+// dumpKind is a collection that can be dumped and imported. ndjson lines are the stored
+// documents, csv rows have the columns, in order
+type dumpKind[T any] struct {
+	collection string
+	columns    []string
+	row        func(doc *T) []string
+	fromRow    func(row map[string]string) (*T, error)
+	// upsert validates an imported document and returns the write that saves it
+	upsert func(doc *T, now time.Time) (mongo.WriteModel, error)
+}
+
+// userDump leaves photos out of csv, and out of imports: their blobs are only added through the
+// photo routes
+var userDump = &dumpKind[User]{
+	collection: "users",
+	columns:    []string{"_id", "name", "age", "bio", "jobTitle", "location", "role", "status", "createdDate", "deletedDate"},
+	row: func(u *User) []string {
+		var age string
+		if u.Age != 0 {
+			age = strconv.Itoa(u.Age)
+		}
+
+		var deleted time.Time
+		if u.DeletedDate != nil {
+			deleted = *u.DeletedDate
+		}
+
+		return []string{string(u.ID), u.Name, age, u.Bio, u.JobTitle, u.Location, string(u.Role), string(u.Status), formatDumpTime(u.CreatedDate), formatDumpTime(deleted)}
+	},
+	fromRow: func(row map[string]string) (*User, error) {
+		u := &User{
+			Bio:      row["bio"],
+			ID:       DocID(row["_id"]),
+			JobTitle: row["jobTitle"],
+			Location: row["location"],
+			Name:     row["name"],
+			Role:     Role(row["role"]),
+			Status:   UserStatus(row["status"]),
+		}
+
+		if v := row["age"]; v != "" {
+			age, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.New("age must be a number")
+			}
+			u.Age = age
+		}
+
+		created, err := parseDumpTime("createdDate", row["createdDate"])
+		if err != nil {
+			return nil, err
+		}
+		u.CreatedDate = created
+
+		deleted, err := parseDumpTime("deletedDate", row["deletedDate"])
+		if err != nil {
+			return nil, err
+		}
+		if !deleted.IsZero() {
+			u.DeletedDate = &deleted
+		}
+
+		return u, nil
+	},
+	upsert: func(u *User, now time.Time) (mongo.WriteModel, error) {
+		switch {
+		case u.ID == "":
+			return nil, errors.New("missing _id")
+		case u.Age < 0:
+			return nil, errors.New("age cannot be negative")
+		case u.Role != "" && !ValidRole(u.Role):
+			return nil, fmt.Errorf("unknown role %q", u.Role)
+		case !ValidStatus(u.Status):
+			return nil, fmt.Errorf("unknown status %q", u.Status)
+		}
+
+		set, err := toDoc(u)
+		if err != nil {
+			return nil, err
+		}
+		delete(set, "_id")
+		delete(set, "createdDate")
+		delete(set, "photos")
+
+		return upsertModel(bson.M{"_id": u.ID}, set, bson.M{}, u.CreatedDate, now), nil
+	},
+}
+
+// ratingDump imports ratings by rater, rated user and type, like they're unique in the
+// collection. A rating already stored keeps its id
+var ratingDump = &dumpKind[Rating]{
+	collection: "ratings",
+	columns:    []string{"_id", "fromUserId", "toUserId", "type", "reason", "createdDate"},
+	row: func(r *Rating) []string {
+		return []string{string(r.ID), r.FromUserID, r.ToUserID, string(r.Type), r.Reason, formatDumpTime(r.CreatedDate)}
+	},
+	fromRow: func(row map[string]string) (*Rating, error) {
+		created, err := parseDumpTime("createdDate", row["createdDate"])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Rating{
+			CreatedDate: created,
+			FromUserID:  row["fromUserId"],
+			ID:          DocID(row["_id"]),
+			Reason:      row["reason"],
+			ToUserID:    row["toUserId"],
+			Type:        RatingType(row["type"]),
+		}, nil
+	},
+	upsert: func(r *Rating, now time.Time) (mongo.WriteModel, error) {
+		if r.FromUserID == "" || r.ToUserID == "" {
+			return nil, errors.New("missing fromUserId or toUserId")
+		}
+		if r.FromUserID == r.ToUserID {
+			return nil, errors.New("rating is from a user to themselves")
+		}
+
+		info, ok := LookupRatingType(r.Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q", r.Type)
+		}
+		if info.RequiresReason && r.Reason == "" {
+			return nil, fmt.Errorf("%s without a reason", r.Type)
+		}
+
+		id := r.ID
+		if id == "" {
+			id = NewDocID()
+		}
+
+		set := bson.M{}
+		if r.Reason != "" {
+			set["reason"] = r.Reason
+		}
+
+		filter := bson.M{"fromUserId": r.FromUserID, "toUserId": r.ToUserID, "type": r.Type}
+		return upsertModel(filter, set, bson.M{"_id": id}, r.CreatedDate, now), nil
+	},
+}
+
+// upsertModel sets the fields of the document matching filter, or inserts it with the fields
+// of setOnInsert too. Documents without a created date are created now
+func upsertModel(filter, set, setOnInsert bson.M, created, now time.Time) mongo.WriteModel {
+	if created.IsZero() {
+		setOnInsert["createdDate"] = now
+	} else {
+		set["createdDate"] = created
+	}
+
+	// older servers turn down empty operators
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(setOnInsert) > 0 {
+		update["$setOnInsert"] = setOnInsert
+	}
+
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+}
+
+// formatDumpTime writes a csv time. Zero times are empty
+func formatDumpTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseDumpTime(name, v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+// WriteDump streams the documents of the collection matching query to w, by id. They're read
+// from a cursor, so memory doesn't grow with the collection. flush is called every
+// dumpFlushEvery documents. It returns how many documents were written
+func WriteDump[T any](ctx context.Context, db *DB, w io.Writer, kind *dumpKind[T], format DumpFormat, query bson.M, flush func()) (int, error) {
+	coll := db.MongoClient.Collection(kind.collection)
+
+	cur, err := coll.Find(ctx, query, options.Find().SetSort(bson.M{"_id": 1}).SetBatchSize(dumpFlushEvery))
+	if err != nil {
+		return 0, NewErrorf("error finding %s from mongo: %s", kind.collection, err)
+	}
+	defer cur.Close(ctx)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	cw := csv.NewWriter(w)
+	if format == DumpCSV {
+		if err := cw.Write(kind.columns); err != nil {
+			return 0, NewErrorf("error writing %s: %s", kind.collection, err)
+		}
+	}
+
+	n := 0
+	for cur.Next(ctx) {
+		var doc T
+		if err := cur.Decode(&doc); err != nil {
+			return n, NewErrorf("error decoding %s: %s", kind.collection, err)
+		}
+
+		if format == DumpCSV {
+			err = cw.Write(kind.row(&doc))
+		} else {
+			err = enc.Encode(&doc)
+		}
+		if err != nil {
+			return n, NewErrorf("error writing %s: %s", kind.collection, err)
+		}
+
+		n++
+		if n%dumpFlushEvery == 0 {
+			cw.Flush()
+			flush()
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, NewErrorf("error writing %s: %s", kind.collection, err)
+	}
+
+	if err := cur.Err(); err != nil {
+		return n, NewErrorf("mongo error: %s", err)
+	}
+
+	return n, nil
+}
+
+// ImportResult is what came of an import. Errors describe the first invalid records, by line
+type ImportResult struct {
+	Created int      `json:"created"`
+	Errors  []string `json:"errors,omitempty"`
+	Invalid int      `json:"invalid"`
+	Updated int      `json:"updated"`
+}
+
+func (res *ImportResult) reject(line int, err error) {
+	res.Invalid++
+	if len(res.Errors) < maxImportErrors {
+		res.Errors = append(res.Errors, fmt.Sprintf("line %d: %s", line, err))
+	}
+}
+
+// invalidRecordError is a record of an import that can't be read. The import skips it
+type invalidRecordError struct {
+	err error
+}
+
+func (e *invalidRecordError) Error() string {
+	return e.err.Error()
+}
+
+// ImportDump validates the records read from r and upserts them, insertBatchSize at a time.
+// Invalid records are skipped. It stops at the first error reading r or from mongo, and the
+// records before it stay imported
+func ImportDump[T any](ctx context.Context, db *DB, r io.Reader, kind *dumpKind[T], format DumpFormat, now time.Time) (*ImportResult, error) {
+	next, err := dumpReader(r, kind, format)
+	if err != nil {
+		return nil, err
+	}
+
+	coll := db.MongoClient.Collection(kind.collection)
+	res := &ImportResult{}
+	models := make([]mongo.WriteModel, 0, insertBatchSize)
+	lines := make([]int, 0, insertBatchSize)
+
+	write := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+
+		out, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if kind.collection == "users" {
+			db.forgetAllUsers(ctx)
+		}
+		if out != nil {
+			res.Created += int(out.UpsertedCount)
+			res.Updated += int(out.MatchedCount)
+		}
+
+		// records the collection turned down, e.g. users with an id that's taken, are invalid too
+		if e, ok := err.(mongo.BulkWriteException); ok && e.WriteConcernError == nil {
+			for _, we := range e.WriteErrors {
+				res.reject(lines[we.Index], errors.New(we.Message))
+			}
+			err = nil
+		}
+
+		models, lines = models[:0], lines[:0]
+		if err != nil {
+			return NewErrorf("error importing %s: %s", kind.collection, err)
+		}
+		return nil
+	}
+
+	for {
+		doc, line, err := next()
+		if err == io.EOF {
+			break
+		}
+		if e, ok := err.(*invalidRecordError); ok {
+			res.reject(line, e)
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+
+		model, err := kind.upsert(doc, now)
+		if err != nil {
+			res.reject(line, err)
+			continue
+		}
+
+		models = append(models, model)
+		lines = append(lines, line)
+		if len(models) == insertBatchSize {
+			if err := write(); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	return res, write()
+}
+
+// dumpReader returns a function reading the records of a dump one at a time, with their line,
+// until io.EOF. Records that can't be read return an *invalidRecordError. csv columns are
+// checked against the kind's first
+func dumpReader[T any](r io.Reader, kind *dumpKind[T], format DumpFormat) (func() (*T, int, error), error) {
+	if format == DumpCSV {
+		return csvDumpReader(r, kind)
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxDumpLineSize)
+
+	line := 0
+	return func() (*T, int, error) {
+		for sc.Scan() {
+			line++
+
+			b := bytes.TrimSpace(sc.Bytes())
+			if len(b) == 0 {
+				continue
+			}
+
+			var doc T
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&doc); err != nil {
+				return nil, line, &invalidRecordError{err}
+			}
+			return &doc, line, nil
+		}
+
+		if err := sc.Err(); err != nil {
+			return nil, line + 1, NewErrorf("error reading line %d: %s", line+1, err)
+		}
+		return nil, line, io.EOF
+	}, nil
+}
+
+func csvDumpReader[T any](r io.Reader, kind *dumpKind[T]) (func() (*T, int, error), error) {
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return func() (*T, int, error) { return nil, 0, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, NewErrorf("error reading csv header: %s", err)
+	}
+
+	known := make(map[string]bool, len(kind.columns))
+	for _, col := range kind.columns {
+		known[col] = true
+	}
+	seen := make(map[string]bool, len(header))
+	for _, col := range header {
+		if !known[col] || seen[col] {
+			return nil, NewErrorf("invalid csv column %q. columns must be some of %s", col, strings.Join(kind.columns, ", "))
+		}
+		seen[col] = true
+	}
+	cr.FieldsPerRecord = len(header)
+
+	return func() (*T, int, error) {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if e, ok := err.(*csv.ParseError); ok {
+			return nil, e.StartLine, &invalidRecordError{e.Err}
+		}
+		if err != nil {
+			return nil, 0, NewErrorf("error reading csv: %s", err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			row[col] = record[i]
+		}
+
+		doc, err := kind.fromRow(row)
+		if err != nil {
+			return nil, line, &invalidRecordError{err}
+		}
+		return doc, line, nil
+	}, nil
+}
+
+// stream users as ndjson or csv, optionally only those created between from and to
+func (app *appContext) adminExportUsers(c *gin.Context) {
+	exportDump(c, app.DB, userDump, false)
+}
+
+// stream ratings as ndjson or csv, optionally only those created between from and to, of some types
+func (app *appContext) adminExportRatings(c *gin.Context) {
+	exportDump(c, app.DB, ratingDump, true)
+}
+
+// upsert users from an ndjson or csv body
+func (app *appContext) adminImportUsers(c *gin.Context) {
+	importDump(c, app, userDump)
+}
+
+// upsert ratings from an ndjson or csv body
+func (app *appContext) adminImportRatings(c *gin.Context) {
+	importDump(c, app, ratingDump)
+}
+
+func exportDump[T any](c *gin.Context, db *DB, kind *dumpKind[T], types bool) {
+	format, err := ParseDumpFormat(c.Query("format"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	p := &DumpParams{}
+	if err := p.bindDates(c); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if v := c.Query("type"); v != "" && types {
+		for _, t := range strings.Split(v, ",") {
+			if _, ok := LookupRatingType(RatingType(t)); !ok {
+				errorResponse(c, http.StatusBadRequest, NewErrorf("type must be some of %s", strings.Join(RatingTypeNames(), ", ")))
+				return
+			}
+			p.Types = append(p.Types, RatingType(t))
+		}
+	}
+
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, kind.collection, format))
+	c.Header("Trailer", dumpCountTrailer+", "+dumpErrorTrailer)
+	c.Status(http.StatusOK)
+
+	n, err := WriteDump(c.Request.Context(), db, c.Writer, kind, format, p.query(), c.Writer.Flush)
+	if err != nil {
+		logf(levelError, "error dumping %s after %d documents: %s", kind.collection, n, err)
+		c.Writer.Header().Set(dumpErrorTrailer, err.Error())
+		return
+	}
+	c.Writer.Header().Set(dumpCountTrailer, strconv.Itoa(n))
+}
+
+func importDump[T any](c *gin.Context, app *appContext, kind *dumpKind[T]) {
+	format, err := ParseDumpFormat(c.Query("format"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	res, err := ImportDump(ctx, app.DB, c.Request.Body, kind, format, app.Clock.Now())
+	if err != nil && res == nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// the import is one change to the collection, its records aren't audited one by one
+	details := bson.M{"created": res.Created, "format": string(format), "invalid": res.Invalid, "updated": res.Updated}
+	if auditErr := recordChange(ctx, app.DB, "dump.import", "collection", kind.collection, nil, nil, details); auditErr != nil && err == nil {
+		err = auditErr
+	}
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"data":  res,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": res,
+	})
+}
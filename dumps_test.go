@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDumpParams_query(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	assert.Equal(t, bson.M{}, (&DumpParams{}).query())
+	assert.Equal(t, bson.M{
+		"createdDate": bson.M{"$gte": from, "$lt": to},
+		"type":        bson.M{"$in": []RatingType{LIKE, PASS}},
+	}, (&DumpParams{From: from, To: to, Types: []RatingType{LIKE, PASS}}).query())
+}
+
+func TestParseDumpFormat(t *testing.T) {
+	f, err := ParseDumpFormat("")
+	assert.Nil(t, err)
+	assert.Equal(t, DumpNDJSON, f)
+
+	f, err = ParseDumpFormat("csv")
+	assert.Nil(t, err)
+	assert.Equal(t, DumpCSV, f)
+
+	_, err = ParseDumpFormat("xml")
+	assert.EqualError(t, err, "format must be one of ndjson, csv")
+}
+
+// readDump reads every record of a dump, with the errors of the invalid ones by line
+func readDump[T any](t *testing.T, kind *dumpKind[T], format DumpFormat, body string) ([]*T, map[int]string) {
+	next, err := dumpReader(strings.NewReader(body), kind, format)
+	assert.Nil(t, err)
+
+	docs := make([]*T, 0)
+	invalid := make(map[int]string)
+	for {
+		doc, line, err := next()
+		if err == io.EOF {
+			return docs, invalid
+		}
+		if err != nil {
+			invalid[line] = err.Error()
+			continue
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func TestDumpReader_ndjson(t *testing.T) {
+	body := `{"_id": "a", "fromUserId": "u1", "toUserId": "u2", "type": "LIKE"}
+
+{"_id": "b", "colour": "red"}
+not json
+{"_id": "c", "fromUserId": "u2", "toUserId": "u1", "type": "PASS"}`
+
+	docs, invalid := readDump(t, ratingDump, DumpNDJSON, body)
+	assert.Equal(t, []*Rating{
+		{FromUserID: "u1", ID: "a", ToUserID: "u2", Type: LIKE},
+		{FromUserID: "u2", ID: "c", ToUserID: "u1", Type: PASS},
+	}, docs)
+	assert.Len(t, invalid, 2)
+	assert.Contains(t, invalid, 3)
+	assert.Contains(t, invalid, 4)
+}
+
+func TestDumpReader_csv(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	u := &User{Age: 30, CreatedDate: created, ID: "u1", Name: "Ann, \"Annie\"", Role: RoleAdmin}
+
+	var b bytes.Buffer
+	b.WriteString(strings.Join(userDump.columns, ",") + "\n")
+	b.WriteString(`u1,"Ann, ""Annie""",30,,,,admin,,2020-01-02T03:04:05Z,` + "\n")
+	b.WriteString("u2,Bo,thirty,,,,,,,\n")
+	b.WriteString("u3,too few\n")
+	b.WriteString("u4,Cy,,,,,,,yesterday,\n")
+
+	docs, invalid := readDump(t, userDump, DumpCSV, b.String())
+	assert.Equal(t, []*User{u}, docs)
+	assert.Equal(t, map[int]string{
+		3: "age must be a number",
+		4: "wrong number of fields",
+		5: "createdDate must be an RFC 3339 time",
+	}, invalid)
+
+	// rows are written in the order of the columns, and read back the same
+	assert.Equal(t, []string{"u1", `Ann, "Annie"`, "30", "", "", "", "admin", "", "2020-01-02T03:04:05Z", ""}, userDump.row(u))
+
+	// columns can be left out, but not made up
+	docs, _ = readDump(t, userDump, DumpCSV, "_id,name\nu1,Ann\n")
+	assert.Equal(t, []*User{{ID: "u1", Name: "Ann"}}, docs)
+
+	_, err := dumpReader(strings.NewReader("_id,password\n"), userDump, DumpCSV)
+	assert.EqualError(t, err, `invalid csv column "password". columns must be some of _id, name, age, bio, jobTitle, location, role, status, createdDate, deletedDate`)
+}
+
+func TestDumpKind_upsert(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		upsert  func() (mongo.WriteModel, error)
+		wantErr string
+	}{
+		{"user", func() (mongo.WriteModel, error) { return userDump.upsert(&User{ID: "u1", Name: "Ann"}, now) }, ""},
+		{"user without id", func() (mongo.WriteModel, error) { return userDump.upsert(&User{Name: "Ann"}, now) }, "missing _id"},
+		{"user with bad status", func() (mongo.WriteModel, error) { return userDump.upsert(&User{ID: "u1", Status: "away"}, now) }, `unknown status "away"`},
+		{"user with bad role", func() (mongo.WriteModel, error) { return userDump.upsert(&User{ID: "u1", Role: "owner"}, now) }, `unknown role "owner"`},
+		{"rating", func() (mongo.WriteModel, error) {
+			return ratingDump.upsert(&Rating{FromUserID: "u1", ToUserID: "u2", Type: LIKE}, now)
+		}, ""},
+		{"rating to self", func() (mongo.WriteModel, error) {
+			return ratingDump.upsert(&Rating{FromUserID: "u1", ToUserID: "u1", Type: LIKE}, now)
+		}, "rating is from a user to themselves"},
+		{"rating with bad type", func() (mongo.WriteModel, error) {
+			return ratingDump.upsert(&Rating{FromUserID: "u1", ToUserID: "u2", Type: "WINK"}, now)
+		}, `unknown type "WINK"`},
+		{"report without reason", func() (mongo.WriteModel, error) {
+			return ratingDump.upsert(&Rating{FromUserID: "u1", ToUserID: "u2", Type: REPORT}, now)
+		}, "REPORT without a reason"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.upsert()
+			if tt.wantErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	// ratings are upserted by their unique fields, and keep the id they were stored with
+	m, _ := ratingDump.upsert(&Rating{FromUserID: "u1", ID: "r1", Reason: "spam", ToUserID: "u2", Type: REPORT}, now)
+	assert.Equal(t, bson.M{"fromUserId": "u1", "toUserId": "u2", "type": REPORT}, m.(*mongo.UpdateOneModel).Filter)
+	assert.Equal(t, bson.M{
+		"$set":         bson.M{"reason": "spam"},
+		"$setOnInsert": bson.M{"_id": DocID("r1"), "createdDate": now},
+	}, m.(*mongo.UpdateOneModel).Update)
+
+	// users' photos are never imported
+	m, _ = userDump.upsert(&User{CreatedDate: now, ID: "u1", Photos: []*Photo{{ID: "p1"}}}, now)
+	assert.Equal(t, bson.M{"$set": bson.M{"createdDate": now}}, m.(*mongo.UpdateOneModel).Update)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// events other than the ones of rating types
+const (
+	EventMatchCreated = "match.created"
+	EventMatchEnded   = "match.ended"
+	EventUserUpdated  = "user.updated"
+)
+
+// EventTypes lists every type of event, for webhooks to pick from
+func EventTypes() []string {
+	types := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, info := range ratingTypes {
+		if info.Event != "" && !seen[info.Event] {
+			types = append(types, info.Event)
+			seen[info.Event] = true
+		}
+	}
+	return append(types, EventMatchCreated, EventMatchEnded, EventUserUpdated)
+}
+
+// EventStatus tells if an event has been handed to the webhooks that want it
+type EventStatus string
+
+const (
+	EventPending    EventStatus = "pending"
+	EventDispatched EventStatus = "dispatched"
+)
+
+// Event is something that happened that other systems may want to know about. It's written to
+// the outbox collection along with the change it describes, then delivered to webhooks
+type Event struct {
+	CreatedDate time.Time `json:"createdDate" bson:"createdDate"`
+	// Data is the json of what changed, as clients see it, when the event happened
+	Data   string      `json:"data" bson:"data"`
+	ID     DocID       `json:"id" bson:"_id"`
+	Status EventStatus `json:"status" bson:"status"`
+	Type   string      `json:"type" bson:"type"`
+	// UserIDs are the users the event is about, so it can be erased with them
+	UserIDs []string `json:"userIds,omitempty" bson:"userIds,omitempty"`
+}
+
+// Body is the json sent to webhooks
+func (e *Event) Body() ([]byte, error) {
+	return json.Marshal(NewEventView(e))
+}
+
+// EventPayload is what an event tells webhooks about a change. Only the views clients are shown
+// are payloads, so ids, roles, statuses and notification preferences stay internal
+type EventPayload interface {
+	// eventUserIDs are the users the payload is about
+	eventUserIDs() []string
+}
+
+func (v *ProfileView) eventUserIDs() []string { return []string{v.ID} }
+func (v *RatingView) eventUserIDs() []string  { return []string{v.FromUserID, v.ToUserID} }
+func (v *MatchView) eventUserIDs() []string   { return v.UserIDs }
+
+// newEvent describes a change that happened at now
+func newEvent(eventType string, payload EventPayload, now time.Time) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, NewErrorf("error encoding %s event: %s", eventType, err)
+	}
+
+	return &Event{
+		CreatedDate: now,
+		Data:        string(data),
+		ID:          NewDocID(),
+		Status:      EventPending,
+		Type:        eventType,
+		UserIDs:     payload.eventUserIDs(),
+	}, nil
+}
+
+// emitEvent adds an event about a change to the outbox. Called within the transaction of the
+// change, the event is only written if the change is
+func emitEvent(ctx context.Context, db *DB, eventType string, payload EventPayload, now time.Time) error {
+	e, err := newEvent(eventType, payload, now)
+	if err != nil {
+		return err
+	}
+
+	return RecordEvents(ctx, db, []*Event{e})
+}
+
+// RecordEvents adds many events to the outbox at once
+func RecordEvents(ctx context.Context, db *DB, events []*Event) error {
+	docs := make([]interface{}, 0, len(events))
+	for _, e := range events {
+		docs = append(docs, e)
+	}
+
+	if err := insertMany(ctx, db.MongoClient.Collection("outbox"), docs); err != nil {
+		return NewErrorf("error writing %d events to the outbox: %s", len(events), err)
+	}
+
+	return nil
+}
+
+// FindPendingEvents returns up to limit events not yet handed to webhooks, oldest first
+func FindPendingEvents(ctx context.Context, db *DB, limit int64) ([]*Event, error) {
+	return findEvents(ctx, db, bson.M{"status": EventPending}, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit))
+}
+
+// FindEventByID returns nil if there's no such event
+func FindEventByID(ctx context.Context, db *DB, id DocID) (*Event, error) {
+	var e Event
+	err := db.MongoClient.Collection("outbox").FindOne(ctx, bson.M{"_id": id}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewErrorf("error finding event %s: %s", id, err)
+	}
+	return &e, nil
+}
+
+func findEvents(ctx context.Context, db *DB, filter bson.M, opts *options.FindOptions) ([]*Event, error) {
+	cur, err := db.MongoClient.Collection("outbox").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, NewErrorf("error finding events from mongo: %s", err)
+	}
+	defer cur.Close(ctx)
+
+	events := make([]*Event, 0)
+	for cur.Next(ctx) {
+		var e Event
+		if err := cur.Decode(&e); err != nil {
+			return nil, NewErrorf("error decoding into event struct: %s", err)
+		}
+		events = append(events, &e)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return events, nil
+}
+
+// MarkEventsDispatched records that events were handed to their webhooks
+func MarkEventsDispatched(ctx context.Context, db *DB, ids []DocID) error {
+	_, err := db.MongoClient.Collection("outbox").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"status": EventDispatched}},
+	)
+	if err != nil {
+		return NewErrorf("error marking events dispatched: %s", err)
+	}
+	return nil
+}
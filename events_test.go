@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTypes(t *testing.T) {
+	assert.Equal(t, []string{"like.created", "block.created", "report.created", "match.created", "match.ended", "user.updated"}, EventTypes())
+}
+
+func TestEvent_Body(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	e, err := newEvent(EventUserUpdated, NewProfileView(&User{ID: "u1", Name: "Ann"}), now)
+	assert.Nil(t, err)
+	assert.Equal(t, EventPending, e.Status)
+	assert.Equal(t, now, e.CreatedDate)
+
+	e.ID = "e1"
+
+	body, err := e.Body()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"createdDate": "2020-01-02T03:04:05Z",
+		"data": {"id": "u1", "name": "Ann", "photos": []},
+		"id": "e1",
+		"type": "user.updated"
+	}`, string(body))
+}
+
+func TestEvent_Body_private(t *testing.T) {
+	u := &User{
+		ID:            "u1",
+		Name:          "Ann",
+		Notifications: &NotificationPreferences{Email: "ann@example.com", PushToken: "device-1"},
+		Role:          RoleAdmin,
+		Status:        UserHidden,
+	}
+	e, err := newEvent(EventUserUpdated, NewProfileView(u), time.Now())
+	assert.Nil(t, err)
+
+	body, err := e.Body()
+	assert.Nil(t, err)
+	for _, private := range []string{"ann@example.com", "device-1", "notifications", "role", "status", "_id"} {
+		assert.NotContains(t, string(body), private)
+	}
+}
+
+func TestNewEvent_userIDs(t *testing.T) {
+	tests := []struct {
+		payload EventPayload
+		want    []string
+	}{
+		{NewProfileView(&User{ID: "u1"}), []string{"u1"}},
+		{NewRatingView(&Rating{FromUserID: "u1", ToUserID: "u2", Type: LIKE}), []string{"u1", "u2"}},
+		{NewMatchView(&Match{UserIDs: []string{"u1", "u2"}}), []string{"u1", "u2"}},
+	}
+
+	for _, tt := range tests {
+		e, err := newEvent("test", tt.payload, time.Now())
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, e.UserIDs)
+	}
+}
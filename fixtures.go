@@ -0,0 +1,138 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// fixtureFS holds the built in fixture sets, loaded by name with LoadFixture
+//
+//go:embed fixtures
+var fixtureFS embed.FS
+
+// Fixture is a named set of users and ratings that can be loaded into an empty database.
+// Fixture files are json or yaml and use the same field names as the api.
+type Fixture struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Users       []*User   `json:"users"`
+	Ratings     []*Rating `json:"ratings"`
+}
+
+// FixtureNames lists the built in fixtures
+func FixtureNames() []string {
+	entries, _ := fs.ReadDir(fixtureFS, "fixtures")
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// LoadFixture loads and validates a built in fixture by name, e.g. "default" or "blocked-pair"
+func LoadFixture(name string) (*Fixture, error) {
+	for _, ext := range []string{".yaml", ".yml", ".json"} {
+		b, err := fixtureFS.ReadFile("fixtures/" + name + ext)
+		if err != nil {
+			continue
+		}
+		return parseFixture(name+ext, b)
+	}
+
+	return nil, NewErrorf("unknown fixture %q. must be one of: %s", name, strings.Join(FixtureNames(), ", "))
+}
+
+// LoadFixtureFile loads and validates a fixture from a json or yaml file on disk
+func LoadFixtureFile(filename string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, NewErrorf("error reading fixture file: %s", err)
+	}
+
+	return parseFixture(filepath.Base(filename), b)
+}
+
+// parse a fixture file and fill in the fields the database needs. yaml is converted to json
+// first so both formats go through the same json tags.
+func parseFixture(filename string, b []byte) (*Fixture, error) {
+	var f Fixture
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, NewErrorf("error parsing fixture %s: %s", filename, err)
+	}
+
+	if f.Name == "" {
+		f.Name = strings.TrimSuffix(filename, path.Ext(filename))
+	}
+
+	if err := f.Validate(); err != nil {
+		return nil, NewErrorf("invalid fixture %s: %s", filename, err)
+	}
+
+	now := time.Now()
+	for _, u := range f.Users {
+		if u.CreatedDate.IsZero() {
+			u.CreatedDate = now
+		}
+	}
+
+	for _, r := range f.Ratings {
+		if r.ID == "" {
+			r.ID = NewDocID()
+		}
+		if r.CreatedDate.IsZero() {
+			r.CreatedDate = now
+		}
+	}
+
+	return &f, nil
+}
+
+// Validate checks that user ids are unique and every rating is between two known users
+func (f *Fixture) Validate() error {
+	ids := make(map[string]bool, len(f.Users))
+	for i, u := range f.Users {
+		if u.ID == "" {
+			return fmt.Errorf("user %d has no _id", i)
+		}
+		if ids[string(u.ID)] {
+			return fmt.Errorf("duplicate user _id %s", u.ID)
+		}
+		if u.Role != "" && !ValidRole(u.Role) {
+			return fmt.Errorf("user %s has unknown role %q", u.ID, u.Role)
+		}
+		ids[string(u.ID)] = true
+	}
+
+	for i, r := range f.Ratings {
+		if !ids[r.FromUserID] {
+			return fmt.Errorf("rating %d is from unknown user %q", i, r.FromUserID)
+		}
+		if !ids[r.ToUserID] {
+			return fmt.Errorf("rating %d is to unknown user %q", i, r.ToUserID)
+		}
+		if r.FromUserID == r.ToUserID {
+			return fmt.Errorf("rating %d is from user %s to themselves", i, r.FromUserID)
+		}
+		info, ok := LookupRatingType(r.Type)
+		if !ok {
+			return fmt.Errorf("rating %d has unknown type %q", i, r.Type)
+		}
+		if info.RequiresReason && r.Reason == "" {
+			return fmt.Errorf("rating %d is a %s without a reason", i, r.Type)
+		}
+	}
+
+	return nil
+}
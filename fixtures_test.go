@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFixture(t *testing.T) {
+	names := FixtureNames()
+	assert.Equal(t, []string{"basic", "blocked-pair", "default", "popular-user"}, names)
+
+	for _, name := range names {
+		f, err := LoadFixture(name)
+		if assert.Nil(t, err, name) {
+			assert.Equal(t, name, f.Name)
+			assert.NotEmpty(t, f.Users, name)
+			assert.NotEmpty(t, f.Ratings, name)
+		}
+	}
+}
+
+func TestLoadFixture_default(t *testing.T) {
+	f, err := LoadFixture("default")
+	assert.Nil(t, err)
+
+	// the default fixture is the data the README and manual testing rely on
+	ids := map[string]bool{
+		"5e2e39ee290f5a56ffda9ed5": true,
+		"5e2e39ee290f5a56ffda9ed6": true,
+		"5e2e39ee290f5a56ffda9ed7": true,
+		"5e2e39ee290f5a56ffda9ed8": true,
+		"5e2e39ee290f5a56ffda9ed9": true,
+		"5e2e39ee290f5a56ffda9eda": true,
+	}
+
+	assert.Len(t, f.Users, len(ids))
+	for _, v := range f.Users {
+		if _, ok := ids[string(v.ID)]; !ok {
+			t.Errorf("user id %s does not belong in known userIds list", v.ID)
+		}
+		assert.False(t, v.CreatedDate.IsZero(), "createdDate should be filled in")
+	}
+
+	assert.Len(t, f.Ratings, 10)
+	for _, v := range f.Ratings {
+		assert.NotEmpty(t, v.ID, "rating ids should be filled in")
+	}
+}
+
+func TestLoadFixture_unknown(t *testing.T) {
+	_, err := LoadFixture("nope")
+	assert.EqualError(t, err, `unknown fixture "nope". must be one of: basic, blocked-pair, default, popular-user`)
+}
+
+func TestFixture_Validate(t *testing.T) {
+	users := []*User{{ID: "a"}, {ID: "b"}}
+
+	tests := []struct {
+		name    string
+		fixture *Fixture
+		wantErr string
+	}{
+		{"valid", &Fixture{Users: users, Ratings: []*Rating{{FromUserID: "a", ToUserID: "b", Type: LIKE}}}, ""},
+		{"missing id", &Fixture{Users: []*User{{Name: "x"}}}, "user 0 has no _id"},
+		{"duplicate id", &Fixture{Users: []*User{{ID: "a"}, {ID: "a"}}}, "duplicate user _id a"},
+		{"unknown role", &Fixture{Users: []*User{{ID: "a", Role: "owner"}}}, `user a has unknown role "owner"`},
+		{"unknown from", &Fixture{Users: users, Ratings: []*Rating{{FromUserID: "c", ToUserID: "b", Type: LIKE}}}, `rating 0 is from unknown user "c"`},
+		{"unknown to", &Fixture{Users: users, Ratings: []*Rating{{FromUserID: "a", ToUserID: "c", Type: LIKE}}}, `rating 0 is to unknown user "c"`},
+		{"self rating", &Fixture{Users: users, Ratings: []*Rating{{FromUserID: "a", ToUserID: "a", Type: LIKE}}}, "rating 0 is from user a to themselves"},
+		{"bad type", &Fixture{Users: users, Ratings: []*Rating{{FromUserID: "a", ToUserID: "b", Type: "LOVE"}}}, `rating 0 has unknown type "LOVE"`},
+		{"report without reason", &Fixture{Users: users, Ratings: []*Rating{{FromUserID: "a", ToUserID: "b", Type: REPORT}}}, "rating 0 is a REPORT without a reason"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fixture.Validate()
+			if tt.wantErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserExport is everything stored about a user, as handed to them on request. Blocks and
+// reports against them don't say who gave them
+type UserExport struct {
+	Activity        []*AuditEntry    `json:"activity"`
+	Events          []*Event         `json:"events"`
+	ExportedDate    time.Time        `json:"exportedDate"`
+	Matches         []*Match         `json:"matches"`
+	Notifications   []*Notification  `json:"notifications"`
+	Profile         *User            `json:"profile"`
+	RatingsGiven    []*Rating        `json:"ratingsGiven"`
+	RatingsReceived []*Rating        `json:"ratingsReceived"`
+	ReportsFiled    []*Rating        `json:"reportsFiled"`
+	Reviews         []*ContentReview `json:"reviews"`
+}
+
+// ExportUser collects everything stored about a user, whatever the status of their account.
+// Returns nil if there is no such user
+func ExportUser(ctx context.Context, db *DB, id string, now time.Time) (*UserExport, error) {
+	profile, err := FindAccount(ctx, db, id)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+
+	e := &UserExport{
+		ExportedDate:    now,
+		Profile:         profile,
+		RatingsGiven:    make([]*Rating, 0),
+		RatingsReceived: make([]*Rating, 0),
+		ReportsFiled:    make([]*Rating, 0),
+	}
+
+	given, err := FindRatings(ctx, db, &RatingParams{Filter: &Rating{FromUserID: id}})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range given {
+		if r.Type == REPORT {
+			e.ReportsFiled = append(e.ReportsFiled, r)
+		} else {
+			e.RatingsGiven = append(e.RatingsGiven, r)
+		}
+	}
+
+	received, err := FindRatings(ctx, db, &RatingParams{Filter: &Rating{ToUserID: id}})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range received {
+		if info, ok := LookupRatingType(r.Type); ok && info.HidesRater {
+			r.FromUserID = ""
+		}
+		e.RatingsReceived = append(e.RatingsReceived, r)
+	}
+
+	if e.Matches, err = findAllUserMatches(ctx, db, id); err != nil {
+		return nil, err
+	}
+
+	if e.Activity, err = findUserAudit(ctx, db, id); err != nil {
+		return nil, err
+	}
+
+	if e.Notifications, err = findNotifications(ctx, db, bson.M{"userId": id}); err != nil {
+		return nil, err
+	}
+
+	if e.Reviews, err = findReviews(ctx, db, bson.M{"userId": id}, options.Find().SetSort(bson.M{"createdDate": 1})); err != nil {
+		return nil, err
+	}
+
+	events, err := findEvents(ctx, db, userEventsFilter(id), options.Find().SetSort(bson.M{"createdDate": 1}))
+	if err != nil {
+		return nil, err
+	}
+	e.Events = exportedEvents(events, id)
+
+	if err := recordChange(ctx, db, "user.export", "user", id, nil, nil, nil); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// ErasureResult counts what erasing a user removed or anonymized
+type ErasureResult struct {
+	AnonymizedAudit      int64  `json:"anonymizedAudit"`
+	AnonymizedReports    int64  `json:"anonymizedReports"`
+	DeletedDeliveries    int64  `json:"deletedDeliveries"`
+	DeletedEvents        int64  `json:"deletedEvents"`
+	DeletedMatches       int64  `json:"deletedMatches"`
+	DeletedNotifications int64  `json:"deletedNotifications"`
+	DeletedRatings       int64  `json:"deletedRatings"`
+	DeletedReviews       int64  `json:"deletedReviews"`
+	Pseudonym            string `json:"pseudonym"`
+}
+
+// EraseUser removes a user and everything about them, whatever the status of their account.
+// Returns nil if there is no such user. The user goes last, so if a step fails erasing them again
+// picks up what's left
+func EraseUser(ctx context.Context, db *DB, blobs BlobStore, id string) (*ErasureResult, error) {
+	u, err := FindCurrentAccount(ctx, db, id)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	result, err := eraseUserData(ctx, db, blobs, id, "user.erase")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.MongoClient.Collection("users").DeleteOne(ctx, bson.M{"_id": DocID(id)}); err != nil {
+		return nil, NewErrorf("error erasing user %s: %s", id, err)
+	}
+	db.forgetUsers(ctx, id)
+
+	return result, nil
+}
+
+// eraseUserData removes everything about a user but their user document, which the caller
+// deletes once this succeeds. Every step can run again after a failure. Reports they filed against
+// others are kept for trust & safety, under a pseudonym instead of their id. Their other ratings,
+// ratings about them, their matches, their photos and the webhook events about them are deleted.
+// Audit entries about them, their ratings, matches and reviews lose the changes and details, and
+// name the pseudonym instead.
+func eraseUserData(ctx context.Context, db *DB, blobs BlobStore, id, action string) (*ErasureResult, error) {
+	result := &ErasureResult{Pseudonym: "erased-" + primitive.NewObjectID().Hex()}
+
+	if err := blobs.DeletePrefix(ctx, photoPrefix(id)); err != nil {
+		return nil, err
+	}
+
+	// audit entries about their ratings, matches and reviews hold copies of them
+	owned := make([]string, 0)
+	for _, c := range []struct {
+		collection string
+		filter     bson.M
+	}{
+		{"ratings", bson.M{"$or": []bson.M{{"fromUserId": id}, {"toUserId": id}}}},
+		{"matches", bson.M{"userIds": id}},
+		{"reviews", bson.M{"userId": id}},
+	} {
+		ids, err := findIDs(ctx, db.MongoClient.Collection(c.collection), c.filter)
+		if err != nil {
+			return nil, NewErrorf("error finding %s of user %s: %s", c.collection, id, err)
+		}
+		owned = append(owned, ids...)
+	}
+
+	ratings := db.MongoClient.Collection("ratings")
+
+	reports := bson.M{"fromUserId": id, "type": REPORT}
+	res, err := ratings.UpdateMany(ctx, reports, bson.M{"$set": bson.M{"fromUserId": result.Pseudonym}})
+	if err != nil {
+		return nil, NewErrorf("error anonymizing reports of user %s: %s", id, err)
+	}
+	result.AnonymizedReports = res.ModifiedCount
+
+	deleted, err := ratings.DeleteMany(ctx, bson.M{"$or": []bson.M{{"fromUserId": id}, {"toUserId": id}}})
+	if err != nil {
+		return nil, NewErrorf("error erasing ratings of user %s: %s", id, err)
+	}
+	result.DeletedRatings = deleted.DeletedCount
+
+	deleted, err = db.MongoClient.Collection("matches").DeleteMany(ctx, bson.M{"userIds": id})
+	if err != nil {
+		return nil, NewErrorf("error erasing matches of user %s: %s", id, err)
+	}
+	result.DeletedMatches = deleted.DeletedCount
+
+	deleted, err = db.MongoClient.Collection("notifications").DeleteMany(ctx, bson.M{"userId": id})
+	if err != nil {
+		return nil, NewErrorf("error erasing notifications of user %s: %s", id, err)
+	}
+	result.DeletedNotifications = deleted.DeletedCount
+
+	// flagged bios go with the profile, flagged reasons stay with the reports they came from
+	reviews := db.MongoClient.Collection("reviews")
+	deleted, err = reviews.DeleteMany(ctx, bson.M{"userId": id, "targetType": "user"})
+	if err != nil {
+		return nil, NewErrorf("error erasing reviews of user %s: %s", id, err)
+	}
+	result.DeletedReviews = deleted.DeletedCount
+
+	if _, err := reviews.UpdateMany(ctx, bson.M{"userId": id}, bson.M{"$set": bson.M{"userId": result.Pseudonym}}); err != nil {
+		return nil, NewErrorf("error anonymizing reviews of user %s: %s", id, err)
+	}
+
+	// events hold copies of the profile, ratings and matches, and could be replayed to webhooks
+	events, err := findEvents(ctx, db, userEventsFilter(id), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		ids := make([]DocID, 0, len(events))
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+
+		deleted, err = db.MongoClient.Collection("deliveries").DeleteMany(ctx, bson.M{"eventId": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, NewErrorf("error erasing webhook deliveries of user %s: %s", id, err)
+		}
+		result.DeletedDeliveries = deleted.DeletedCount
+
+		deleted, err = db.MongoClient.Collection("outbox").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, NewErrorf("error erasing events of user %s: %s", id, err)
+		}
+		result.DeletedEvents = deleted.DeletedCount
+	}
+
+	audit := db.MongoClient.Collection("audit")
+	for _, u := range erasureAuditUpdates(id, result.Pseudonym, owned) {
+		res, err := audit.UpdateMany(ctx, u.filter, u.update)
+		if err != nil {
+			return nil, NewErrorf("error anonymizing audit entries of user %s: %s", id, err)
+		}
+		result.AnonymizedAudit += res.ModifiedCount
+	}
+
+	details := bson.M{
+		"anonymizedAudit":      result.AnonymizedAudit,
+		"anonymizedReports":    result.AnonymizedReports,
+		"deletedDeliveries":    result.DeletedDeliveries,
+		"deletedEvents":        result.DeletedEvents,
+		"deletedMatches":       result.DeletedMatches,
+		"deletedNotifications": result.DeletedNotifications,
+		"deletedRatings":       result.DeletedRatings,
+		"deletedReviews":       result.DeletedReviews,
+	}
+	if err := recordChange(ctx, db, action, "user", result.Pseudonym, nil, nil, details); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// exportedEvents are the events about a user they can see. Events of blocks and reports they got
+// would say who gave them
+func exportedEvents(events []*Event, id string) []*Event {
+	hidden := make(map[string]bool)
+	for _, info := range ratingTypes {
+		if info.HidesRater && info.Event != "" {
+			hidden[info.Event] = true
+		}
+	}
+
+	exported := make([]*Event, 0, len(events))
+	for _, e := range events {
+		if hidden[e.Type] {
+			var r RatingView
+			if err := json.Unmarshal([]byte(e.Data), &r); err != nil || r.FromUserID != id {
+				continue
+			}
+		}
+		exported = append(exported, e)
+	}
+	return exported
+}
+
+// auditUpdate is a change to the audit entries of an erased user
+type auditUpdate struct {
+	filter bson.M
+	update bson.M
+}
+
+// erasureAuditUpdates anonymizes the audit entries of a user and of the ratings, matches and
+// reviews with the owned ids. Their changes and details are dropped, they copy the user's data
+func erasureAuditUpdates(id, pseudonym string, owned []string) []auditUpdate {
+	redact := bson.M{"changes": "", "details": ""}
+	return []auditUpdate{
+		{bson.M{"targetId": id}, bson.M{"$set": bson.M{"targetId": pseudonym}, "$unset": redact}},
+		{bson.M{"targetId": bson.M{"$in": owned}}, bson.M{"$unset": redact}},
+		{bson.M{"actorId": id}, bson.M{"$set": bson.M{"actorId": pseudonym}}},
+	}
+}
+
+// findIDs returns the ids of the documents matching filter
+func findIDs(ctx context.Context, collection *mongo.Collection, filter bson.M) ([]string, error) {
+	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	ids := make([]string, 0)
+	for cur.Next(ctx) {
+		var doc struct {
+			ID DocID `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, string(doc.ID))
+	}
+
+	return ids, cur.Err()
+}
+
+// userEventsFilter finds the events about a user. Events written before they named their users
+// are found by the quoted id in their data
+func userEventsFilter(id string) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"userIds": id},
+		{"userIds": bson.M{"$exists": false}, "data": primitive.Regex{Pattern: regexp.QuoteMeta(`"` + id + `"`)}},
+	}}
+}
+
+// every match the user has been in, newest first
+func findAllUserMatches(ctx context.Context, db *DB, id string) ([]*Match, error) {
+	return findMatches(ctx, db, bson.M{"userIds": id})
+}
+
+// audit entries about the user or by them, newest first
+func findUserAudit(ctx context.Context, db *DB, id string) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+	for _, p := range []*AuditParams{{TargetID: id}, {ActorID: id}} {
+		found, err := FindAudit(ctx, db, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range found {
+			// changes to their own things show up under both
+			if p.ActorID != "" && e.TargetID == id {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedDate.After(entries[j].CreatedDate)
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUserEventsFilter(t *testing.T) {
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"userIds": "5e2f+1"},
+		{"userIds": bson.M{"$exists": false}, "data": primitive.Regex{Pattern: `"5e2f\+1"`}},
+	}}, userEventsFilter("5e2f+1"))
+}
+
+func TestErasureAuditUpdates(t *testing.T) {
+	redact := bson.M{"changes": "", "details": ""}
+	assert.Equal(t, []auditUpdate{
+		{bson.M{"targetId": "u1"}, bson.M{"$set": bson.M{"targetId": "erased-1"}, "$unset": redact}},
+		{bson.M{"targetId": bson.M{"$in": []string{"r1", "m1"}}}, bson.M{"$unset": redact}},
+		{bson.M{"actorId": "u1"}, bson.M{"$set": bson.M{"actorId": "erased-1"}}},
+	}, erasureAuditUpdates("u1", "erased-1", []string{"r1", "m1"}))
+}
+
+func TestExportedEvents(t *testing.T) {
+	liked := &Event{Data: `{"fromUserId":"b","id":"r1","toUserId":"a","type":"LIKE"}`, Type: "like.created"}
+	blocked := &Event{Data: `{"fromUserId":"b","id":"r2","toUserId":"a","type":"BLOCK"}`, Type: "block.created"}
+	blocking := &Event{Data: `{"fromUserId":"a","id":"r3","toUserId":"c","type":"BLOCK"}`, Type: "block.created"}
+	reported := &Event{Data: `{"fromUserId":"b","id":"r4","reason":"spam","toUserId":"a","type":"REPORT"}`, Type: "report.created"}
+	updated := &Event{Data: `{"id":"a"}`, Type: EventUserUpdated}
+
+	exported := exportedEvents([]*Event{liked, blocked, blocking, reported, updated}, "a")
+	assert.Equal(t, []*Event{liked, blocking, updated}, exported, "blocks and reports against a don't say who gave them")
+}
+
+func TestEraseUser(t *testing.T) {
+	app := initAppContext()
+	app.Blobs = NewLocalBlobStore(t.TempDir(), "/blobs")
+	ctx := context.Background()
+	now := time.Now()
+
+	id := string(NewDocID())
+	u := &User{
+		ID:            DocID(id),
+		Name:          "Ann",
+		Notifications: &NotificationPreferences{Email: "ann@example.com"},
+		Status:        UserActive,
+	}
+	assert.Nil(t, InsertUsers(ctx, app.DB, []*User{u}))
+
+	e, err := newEvent(EventUserUpdated, NewProfileView(u), now)
+	assert.Nil(t, err)
+	assert.Nil(t, RecordEvents(ctx, app.DB, []*Event{e}))
+	assert.Nil(t, writeDeliveries(ctx, app.DB, []mongo.WriteModel{queueDelivery(e, NewDocID(), now)}))
+
+	r := &Rating{ID: NewDocID(), FromUserID: string(NewDocID()), Reason: "ann is rude", ToUserID: id, Type: REPORT}
+	assert.Nil(t, InsertRatings(ctx, app.DB, []*Rating{r}))
+	assert.Nil(t, recordChange(ctx, app.DB, "rating.moderate", "rating", string(r.ID), bson.M{"reason": r.Reason}, bson.M{}, bson.M{"userId": id}))
+
+	result, err := EraseUser(ctx, app.DB, app.Blobs, id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), result.DeletedEvents)
+	assert.Equal(t, int64(1), result.DeletedDeliveries)
+
+	event, err := FindEventByID(ctx, app.DB, e.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, event, "replaying can't send the user again")
+
+	n, err := app.DB.MongoClient.Collection("deliveries").CountDocuments(ctx, bson.M{"eventId": e.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+
+	entries, err := FindAudit(ctx, app.DB, &AuditParams{TargetID: string(r.ID)})
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Nil(t, entries[0].Changes, "the rating's text goes with the user")
+		assert.Nil(t, entries[0].Details)
+	}
+
+	gone, err := FindCurrentAccount(ctx, app.DB, id)
+	assert.Nil(t, err)
+	assert.Nil(t, gone, "the user is deleted once the rest is erased")
+
+	again, err := EraseUser(ctx, app.DB, app.Blobs, id)
+	assert.Nil(t, err)
+	assert.Nil(t, again)
+}
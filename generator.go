@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// GeneratorOptions controls the shape of a generated data set
+type GeneratorOptions struct {
+	// Seed makes the output reproducible. The same seed and options give the same data
+	Seed int64
+	// Users is how many users to create
+	Users int
+	// RatingsPerUser is the average number of ratings each user makes
+	RatingsPerUser int
+	// BlockRate and ReportRate are the fractions of ratings that are a BLOCK or REPORT, the rest are LIKEs
+	BlockRate  float64
+	ReportRate float64
+	// Exponent of the power law that decides who gets rated. Higher means a few users get most
+	// of the ratings. Must be greater than 1
+	Exponent float64
+	// Now is the latest createdDate given out. Dates are spread over the 90 days before it
+	Now time.Time
+}
+
+// DefaultGeneratorOptions returns options for a small, mostly friendly data set
+func DefaultGeneratorOptions() GeneratorOptions {
+	return GeneratorOptions{
+		Seed:           1,
+		Users:          1000,
+		RatingsPerUser: 20,
+		BlockRate:      0.02,
+		ReportRate:     0.005,
+		Exponent:       1.3,
+		Now:            time.Now(),
+	}
+}
+
+// Validate checks the options can produce a data set
+func (o GeneratorOptions) Validate() error {
+	if o.Users < 2 {
+		return errors.New("need at least 2 users")
+	}
+	if o.RatingsPerUser < 0 || o.RatingsPerUser >= o.Users {
+		return fmt.Errorf("ratings per user must be between 0 and %d", o.Users-1)
+	}
+	if o.BlockRate < 0 || o.ReportRate < 0 || o.BlockRate+o.ReportRate > 1 {
+		return errors.New("block and report rates must be between 0 and 1 and add up to at most 1")
+	}
+	if o.Exponent <= 1 {
+		return errors.New("exponent must be greater than 1")
+	}
+	return nil
+}
+
+// GenerateFixture builds a random but reproducible set of users and ratings. Who gets rated
+// follows a power law, so a handful of users are very popular and most get a few ratings.
+// Blocked pairs never have a LIKE between them, the same as after Rating.Save.
+func GenerateFixture(opts GeneratorOptions) (*Fixture, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	window := int64(90 * 24 * time.Hour)
+
+	users := make([]*User, 0, opts.Users)
+	for i := 0; i < opts.Users; i++ {
+		users = append(users, &User{
+			Age:         18 + int(rnd.ExpFloat64()*10)%50,
+			Bio:         fmt.Sprintf("%s %s. %s", pick(rnd, bioOpeners), pick(rnd, bioHobbies), pick(rnd, bioClosers)),
+			CreatedDate: opts.Now.Add(-time.Duration(rnd.Int63n(window))),
+			ID:          DocID(randomObjectID(rnd)),
+			JobTitle:    pick(rnd, generatorJobs),
+			Location:    pick(rnd, generatorLocations),
+			Name:        pick(rnd, generatorNames),
+		})
+	}
+
+	// popularity rank -> user, so the most popular users are spread randomly through the list
+	byRank := rnd.Perm(opts.Users)
+	zipf := rand.NewZipf(rnd, opts.Exponent, 1, uint64(opts.Users-1))
+
+	type pair struct{ from, to int }
+	rated := make(map[pair]RatingType)
+	order := make([]pair, 0, opts.Users*opts.RatingsPerUser)
+
+	for from := range users {
+		// between 0 and twice the average, so the mean works out
+		n := rnd.Intn(2*opts.RatingsPerUser + 1)
+
+		// give up on a user after enough collisions with people they've already rated
+		for attempts := 0; n > 0 && attempts < 1000; attempts++ {
+			to := byRank[zipf.Uint64()]
+			p := pair{from, to}
+			if to == from || rated[p] != "" {
+				continue
+			}
+
+			t := LIKE
+			switch x := rnd.Float64(); {
+			case x < opts.BlockRate:
+				t = BLOCK
+			case x < opts.BlockRate+opts.ReportRate:
+				t = REPORT
+			}
+
+			rated[p] = t
+			order = append(order, p)
+			n--
+		}
+	}
+
+	ratings := make([]*Rating, 0, len(order))
+	for _, p := range order {
+		t := rated[p]
+
+		// a block from either side removes the likes both ways
+		if t == LIKE && (rated[pair{p.to, p.from}] == BLOCK) {
+			continue
+		}
+
+		r := &Rating{
+			CreatedDate: opts.Now.Add(-time.Duration(rnd.Int63n(window))),
+			FromUserID:  string(users[p.from].ID),
+			ID:          DocID(randomObjectID(rnd)),
+			ToUserID:    string(users[p.to].ID),
+			Type:        t,
+		}
+		if t == REPORT {
+			r.Reason = pick(rnd, reportReasons)
+		}
+
+		ratings = append(ratings, r)
+	}
+
+	f := &Fixture{
+		Name:        fmt.Sprintf("generated-%d", opts.Seed),
+		Description: fmt.Sprintf("%d generated users with seed %d", opts.Users, opts.Seed),
+		Users:       users,
+		Ratings:     ratings,
+	}
+
+	return f, f.Validate()
+}
+
+// randomObjectID makes a 12 byte hex id from rnd, so ids are reproducible unlike primitive.NewObjectID
+func randomObjectID(rnd *rand.Rand) string {
+	b := make([]byte, 12)
+	rnd.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func pick(rnd *rand.Rand, list []string) string {
+	return list[rnd.Intn(len(list))]
+}
+
+var generatorNames = []string{
+	"Aaliyah", "Aaron", "Abigail", "Adam", "Aisha", "Alejandro", "Amara", "Amir", "Ana", "Andre",
+	"Anna", "Arjun", "Ava", "Ben", "Bianca", "Caleb", "Camila", "Carlos", "Chloe", "Chris",
+	"Daniel", "Deja", "Diego", "Elena", "Eli", "Emily", "Emma", "Ethan", "Fatima", "Felix",
+	"Gabriel", "Grace", "Hana", "Hannah", "Hugo", "Isaac", "Isabella", "Jack", "Jamal", "James",
+	"Jasmine", "Jin", "Jordan", "Julia", "Kai", "Kayla", "Kenji", "Layla", "Leo", "Lily",
+	"Lucas", "Luis", "Maya", "Mei", "Mia", "Mohammed", "Nadia", "Noah", "Nora", "Oliver",
+	"Olivia", "Omar", "Priya", "Rafael", "Riley", "Rosa", "Ryan", "Sam", "Sara", "Sofia",
+	"Tariq", "Tessa", "Theo", "Valentina", "Victor", "Yara", "Yusuf", "Zara", "Zoe", "Zoran",
+}
+
+var generatorJobs = []string{
+	"Accountant", "Architect", "Barista", "Biologist", "Carpenter", "Chef", "Civil Engineer",
+	"Copywriter", "Data Analyst", "Dentist", "Electrician", "Firefighter", "Graphic Designer",
+	"High School Teacher", "Lawyer", "Librarian", "Marketing Manager", "Mechanic", "Musician",
+	"Nurse", "Paramedic", "Pharmacist", "Photographer", "Physical Therapist", "Pilot",
+	"Product Manager", "Professor", "Real Estate Agent", "Sales Manager", "Social Worker",
+	"Software Engineer", "Veterinarian", "Video Editor", "Web Developer", "Yoga Instructor",
+}
+
+var generatorLocations = []string{
+	"Atlanta, GA", "Austin, TX", "Boston, MA", "Chicago, IL", "Dallas, TX", "Denver, CO",
+	"Detroit, MI", "Houston, TX", "Las Vegas, NV", "Los Angeles, CA", "Miami, FL",
+	"Minneapolis, MN", "Nashville, TN", "New Orleans, LA", "New York, NY", "Philadelphia, PA",
+	"Phoenix, AZ", "Pittsburgh, PA", "Portland, OR", "Raleigh, NC", "Salt Lake City, UT",
+	"San Diego, CA", "San Francisco, CA", "Seattle, WA", "Washington, DC",
+}
+
+var bioOpeners = []string{
+	"Happiest when", "Most weekends you'll find me", "I spend too much time", "Big fan of",
+	"Currently obsessed with", "Always up for", "Secretly very good at", "Looking for someone into",
+}
+
+var bioHobbies = []string{
+	"hiking with my dog", "trying every taco truck in town", "playing board games",
+	"running half marathons", "baking sourdough", "watching old movies", "rock climbing",
+	"learning to surf", "reading sci-fi", "going to live music", "growing tomatoes on my balcony",
+	"planning the next trip", "cooking for friends", "doing crosswords", "cycling",
+}
+
+var bioClosers = []string{
+	"Tell me your favorite book.", "Coffee first, then adventures.", "Bonus points if you can cook.",
+	"Let's grab a drink.", "Swipe right if you like puns.", "Not a morning person.",
+	"Ask me about my last trip.", "Looking for something real.",
+}
+
+var reportReasons = []string{
+	"spam", "fake profile", "inappropriate messages", "offensive bio", "asked for money",
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGeneratorOptions() GeneratorOptions {
+	opts := DefaultGeneratorOptions()
+	opts.Users = 500
+	opts.BlockRate = 0.1
+	opts.Now = time.Date(2020, 1, 27, 0, 0, 0, 0, time.UTC)
+	return opts
+}
+
+func TestGenerateFixture_deterministic(t *testing.T) {
+	a, err := GenerateFixture(testGeneratorOptions())
+	assert.Nil(t, err)
+	b, err := GenerateFixture(testGeneratorOptions())
+	assert.Nil(t, err)
+
+	assert.Equal(t, a, b, "same seed should give the same data")
+
+	opts := testGeneratorOptions()
+	opts.Seed = 2
+	c, err := GenerateFixture(opts)
+	assert.Nil(t, err)
+	assert.NotEqual(t, a.Users[0].ID, c.Users[0].ID)
+}
+
+func TestGenerateFixture_shape(t *testing.T) {
+	f, err := GenerateFixture(testGeneratorOptions())
+	assert.Nil(t, err)
+	assert.Len(t, f.Users, 500)
+
+	blocked := make(map[[2]string]bool)
+	incoming := make(map[string]int)
+	for _, r := range f.Ratings {
+		if r.Type == BLOCK {
+			blocked[[2]string{r.FromUserID, r.ToUserID}] = true
+			blocked[[2]string{r.ToUserID, r.FromUserID}] = true
+		}
+		incoming[r.ToUserID]++
+	}
+
+	for _, r := range f.Ratings {
+		if r.Type == LIKE && blocked[[2]string{r.FromUserID, r.ToUserID}] {
+			t.Errorf("like from %s to %s should have been removed by a block", r.FromUserID, r.ToUserID)
+		}
+	}
+
+	// popularity follows a power law, so the top user should get far more than the median
+	counts := make([]int, 0, len(f.Users))
+	for _, u := range f.Users {
+		counts = append(counts, incoming[string(u.ID)])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	assert.Greater(t, counts[0], 10*counts[len(counts)/2]+1)
+}
+
+func TestGeneratorOptions_Validate(t *testing.T) {
+	opts := testGeneratorOptions()
+	opts.Exponent = 1
+	assert.EqualError(t, opts.Validate(), "exponent must be greater than 1")
+
+	opts = testGeneratorOptions()
+	opts.BlockRate, opts.ReportRate = 0.6, 0.6
+	assert.EqualError(t, opts.Validate(), "block and report rates must be between 0 and 1 and add up to at most 1")
+}
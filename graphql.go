@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+// graphQLSchema is the schema of /graphql. Its types are the public views of /v1, so users
+// don't see each other's role, status or dates here either
+//
+//go:embed schema.graphql
+var graphQLSchema string
+
+const (
+	// graphQLBatchWait is how long a loader waits for more keys before fetching a batch
+	graphQLBatchWait = time.Millisecond
+	// graphQLParallelism is how many resolvers run at once. Resolvers waiting on a loader count
+	// toward it, so it also caps how many keys go in one batch
+	graphQLParallelism = 50
+	// graphQLMaxDepth stops queries from nesting likes of likes of likes without end
+	graphQLMaxDepth = 10
+)
+
+// setupGraphQLRoutes serves the graphql api at POST /graphql. It isn't versioned, the schema
+// evolves by adding fields
+func setupGraphQLRoutes(r *gin.Engine, app *appContext) {
+	h := &relay.Handler{Schema: newGraphQLSchema(app)}
+
+	r.POST("/graphql", func(c *gin.Context) {
+		ctx := withLoaders(c.Request.Context(), newLoaders(app.DB))
+		h.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	})
+}
+
+// newGraphQLSchema binds the schema to its resolvers. Queries need loaders in their context
+func newGraphQLSchema(app *appContext) *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &graphQLResolver{app: app},
+		graphql.MaxParallelism(graphQLParallelism),
+		graphql.MaxDepth(graphQLMaxDepth),
+	)
+}
+
+// loaders batch the lookups of one graphql request, so resolving the users in a list of likes
+// or matches takes one query however long the list is
+type loaders struct {
+	// likes are the incoming likes of users, by the liked user's id
+	likes *Loader[string, []*Rating]
+	// matches are the active matches of users, by user id
+	matches *Loader[string, []*Match]
+	users   *Loader[string, *User]
+}
+
+func newLoaders(db *DB) *loaders {
+	return &loaders{
+		likes: NewLoader(graphQLBatchWait, func(ctx context.Context, ids []string) (map[string][]*Rating, error) {
+			return FindIncomingLikesOfUsers(ctx, db, ids)
+		}),
+		matches: NewLoader(graphQLBatchWait, func(ctx context.Context, ids []string) (map[string][]*Match, error) {
+			return FindMatchesOfUsers(ctx, db, ids)
+		}),
+		users: NewLoader(graphQLBatchWait, func(ctx context.Context, ids []string) (map[string]*User, error) {
+			return FindUsersByIDs(ctx, db, ids)
+		}),
+	}
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// graphQLResolver resolves the queries and mutations of schema.graphql
+type graphQLResolver struct {
+	app *appContext
+}
+
+func (r *graphQLResolver) Users(ctx context.Context) ([]*userResolver, error) {
+	users, err := FindAllUsers(ctx, r.app.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*userResolver, 0, len(users))
+	for _, u := range users {
+		out = append(out, &userResolver{root: r, u: u})
+	}
+	return out, nil
+}
+
+func (r *graphQLResolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	u, err := loadersFrom(ctx).users.Load(ctx, string(args.ID))
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return &userResolver{root: r, u: u}, nil
+}
+
+func (r *graphQLResolver) Rate(ctx context.Context, args struct {
+	UserID graphql.ID
+	Input  struct {
+		Reason   *string
+		ToUserID graphql.ID
+		Type     string
+	}
+}) (bool, error) {
+	rating := &Rating{
+		FromUserID: string(args.UserID),
+		ToUserID:   string(args.Input.ToUserID),
+		Type:       RatingType(args.Input.Type),
+	}
+	if args.Input.Reason != nil {
+		rating.Reason = *args.Input.Reason
+	}
+
+	if _, err := r.app.rate(ctx, rating); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type userResolver struct {
+	root *graphQLResolver
+	u    *User
+}
+
+func (r *userResolver) ID() graphql.ID    { return graphql.ID(r.u.ID) }
+func (r *userResolver) Age() *int32       { return optionalInt(r.u.Age) }
+func (r *userResolver) Bio() *string      { return optionalString(r.u.Bio) }
+func (r *userResolver) JobTitle() *string { return optionalString(r.u.JobTitle) }
+func (r *userResolver) Location() *string { return optionalString(r.u.Location) }
+func (r *userResolver) Name() *string     { return optionalString(r.u.Name) }
+
+func (r *userResolver) Photos() []*photoResolver {
+	out := make([]*photoResolver, 0, len(r.u.Photos))
+	for _, p := range r.u.Photos {
+		out = append(out, &photoResolver{p: p})
+	}
+	return out
+}
+
+func (r *userResolver) IncomingLikes(ctx context.Context) ([]*ratingResolver, error) {
+	l := loadersFrom(ctx)
+
+	likes, err := l.likes.Load(ctx, string(r.u.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(likes))
+	for _, like := range likes {
+		ids = append(ids, like.FromUserID)
+	}
+
+	likers, err := l.users.LoadMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*ratingResolver, 0, len(likes))
+	for i, like := range likes {
+		if likers[i] != nil {
+			out = append(out, &ratingResolver{from: &userResolver{root: r.root, u: likers[i]}, r: like, to: r})
+		}
+	}
+	return out, nil
+}
+
+func (r *userResolver) Matches(ctx context.Context) ([]*matchResolver, error) {
+	l := loadersFrom(ctx)
+	id := string(r.u.ID)
+
+	matches, err := l.matches.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.OtherUserID(id))
+	}
+
+	others, err := l.users.LoadMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := r.root.app.Config
+	out := make([]*matchResolver, 0, len(matches))
+	for i, m := range matches {
+		if others[i] == nil {
+			continue
+		}
+
+		// matches are shared by both of their users' resolvers, so expiry goes on a copy
+		match := *m
+		if cfg.Features.MatchExpiry {
+			match.SetExpiresAt(cfg.Matches.ExpireAfter)
+		}
+		out = append(out, &matchResolver{m: &match, user: &userResolver{root: r.root, u: others[i]}})
+	}
+	return out, nil
+}
+
+type photoResolver struct {
+	p *Photo
+}
+
+func (r *photoResolver) ID() graphql.ID       { return graphql.ID(r.p.ID) }
+func (r *photoResolver) Height() int32        { return int32(r.p.Height) }
+func (r *photoResolver) ThumbnailURL() string { return r.p.ThumbnailURL }
+func (r *photoResolver) URL() string          { return r.p.URL }
+func (r *photoResolver) Width() int32         { return int32(r.p.Width) }
+
+type ratingResolver struct {
+	from *userResolver
+	r    *Rating
+	to   *userResolver
+}
+
+func (r *ratingResolver) ID() graphql.ID      { return graphql.ID(r.r.ID) }
+func (r *ratingResolver) From() *userResolver { return r.from }
+func (r *ratingResolver) Reason() *string     { return optionalString(r.r.Reason) }
+func (r *ratingResolver) To() *userResolver   { return r.to }
+func (r *ratingResolver) Type() string        { return string(r.r.Type) }
+
+type matchResolver struct {
+	m    *Match
+	user *userResolver
+}
+
+func (r *matchResolver) ID() graphql.ID            { return graphql.ID(r.m.ID) }
+func (r *matchResolver) CreatedDate() graphql.Time { return graphql.Time{Time: r.m.CreatedDate} }
+func (r *matchResolver) LastActivityDate() graphql.Time {
+	return graphql.Time{Time: r.m.LastActivityDate}
+}
+func (r *matchResolver) Status() string      { return string(r.m.Status) }
+func (r *matchResolver) User() *userResolver { return r.user }
+
+func (r *matchResolver) ExpiresAt() *graphql.Time {
+	if r.m.ExpiresAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.m.ExpiresAt}
+}
+
+// optionalInt is null for fields left out of the stored document
+func optionalInt(i int) *int32 {
+	if i == 0 {
+		return nil
+	}
+	v := int32(i)
+	return &v
+}
+
+// optionalString is null for fields left out of the stored document
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLoaders serve graphql lookups from memory and record which users were fetched together
+func fakeLoaders(users map[string]*User, likes map[string][]*Rating, matches map[string][]*Match) (*loaders, func() [][]string) {
+	var mu sync.Mutex
+	var batches [][]string
+
+	l := &loaders{
+		likes: NewLoader(time.Millisecond, func(_ context.Context, ids []string) (map[string][]*Rating, error) {
+			return likes, nil
+		}),
+		matches: NewLoader(time.Millisecond, func(_ context.Context, ids []string) (map[string][]*Match, error) {
+			return matches, nil
+		}),
+		users: NewLoader(time.Millisecond, func(_ context.Context, ids []string) (map[string]*User, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			batches = append(batches, ids)
+			return users, nil
+		}),
+	}
+
+	return l, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return batches
+	}
+}
+
+func TestGraphQL_user(t *testing.T) {
+	created := time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)
+	users := map[string]*User{
+		"a": {ID: "a", Name: "Jennifer", Role: RoleAdmin},
+		"b": {ID: "b", Name: "Bob", Photos: []*Photo{{ID: "p", URL: "/blobs/b.jpg"}}},
+		"c": {ID: "c", Name: "Carol"},
+	}
+	likes := map[string][]*Rating{
+		"a": {
+			{ID: "r1", FromUserID: "c", ToUserID: "a", Type: SUPERLIKE},
+			{ID: "r2", FromUserID: "b", ToUserID: "a", Type: LIKE},
+			// hidden users aren't found, so their likes are left out
+			{ID: "r3", FromUserID: "hidden", ToUserID: "a", Type: LIKE},
+		},
+	}
+	matches := map[string][]*Match{
+		"a": {{ID: "m", CreatedDate: created, LastActivityDate: created, Status: MatchActive, UserIDs: []string{"a", "b"}}},
+	}
+
+	l, batches := fakeLoaders(users, likes, matches)
+	cfg := DefaultConfig()
+	cfg.Features.MatchExpiry = true
+	cfg.Matches.ExpireAfter = 24 * time.Hour
+	schema := newGraphQLSchema(&appContext{Config: cfg})
+
+	res := schema.Exec(withLoaders(context.Background(), l), `{
+		user(id: "a") {
+			name
+			incomingLikes { type from { name photos { url } } }
+			matches { status expiresAt user { name } }
+		}
+	}`, "", nil)
+	assert.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"user": {
+		"name": "Jennifer",
+		"incomingLikes": [
+			{"type": "SUPERLIKE", "from": {"name": "Carol", "photos": []}},
+			{"type": "LIKE", "from": {"name": "Bob", "photos": [{"url": "/blobs/b.jpg"}]}}
+		],
+		"matches": [{"status": "active", "expiresAt": "2020-01-28T12:00:00Z", "user": {"name": "Bob"}}]
+	}}`, string(res.Data))
+
+	// the likers and the match are looked up in batches, each user only once
+	fetched := make(map[string]int)
+	for _, batch := range batches() {
+		for _, id := range batch {
+			fetched[id]++
+		}
+	}
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "hidden": 1}, fetched)
+	assert.LessOrEqual(t, len(batches()), 3)
+	assert.Nil(t, matches["a"][0].ExpiresAt, "cached matches shouldn't be changed")
+}
+
+func TestGraphQL_rate(t *testing.T) {
+	l, _ := fakeLoaders(nil, nil, nil)
+	schema := newGraphQLSchema(&appContext{Config: DefaultConfig()})
+
+	// turned down before anything is looked up
+	res := schema.Exec(withLoaders(context.Background(), l), `mutation {
+		rate(userId: "a", input: {toUserId: "b", type: REPORT})
+	}`, "", nil)
+
+	b, err := json.Marshal(res.Errors)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"message": "reason cannot be blank", "path": ["rate"], "extensions": {"status": 400}}]`, string(b))
+}
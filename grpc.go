@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/urvesh/backend-homework/homeworkpb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newGRPCServer serves the homework.v1 services to internal services. services maps each
+// token that may call to the name of its service
+func newGRPCServer(app *appContext, services map[string]string) *grpc.Server {
+	auth := &grpcAuth{services: services}
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.unary),
+		grpc.StreamInterceptor(auth.stream),
+	)
+
+	homeworkpb.RegisterUsersServer(s, &usersServer{app: app})
+	homeworkpb.RegisterRatingsServer(s, &ratingsServer{app: app})
+	homeworkpb.RegisterMatchesServer(s, &matchesServer{app: app})
+
+	return s
+}
+
+// grpcAuth lets in calls from services with a known token, sent as "authorization: Bearer
+// <token>" metadata. It's shared by every service, and their changes are audited as made by
+// "service:<name>"
+type grpcAuth struct {
+	services map[string]string
+}
+
+func (a *grpcAuth) authorize(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var token string
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+
+	// compare every token in constant time, so timing doesn't give any of them away
+	var name string
+	for t, n := range a.services {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name = n
+		}
+	}
+	if name == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing or unknown token")
+	}
+
+	actor := &AuditActor{ID: "service:" + name, RequestID: primitive.NewObjectID().Hex()}
+	if v := md.Get(requestIDHeader); len(v) > 0 && v[0] != "" {
+		actor.RequestID = v[0]
+	}
+
+	return WithAuditActor(ctx, actor), nil
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizedStream is a stream with the caller in its context
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+type usersServer struct {
+	homeworkpb.UnimplementedUsersServer
+	app *appContext
+}
+
+func (s *usersServer) ListUsers(ctx context.Context, _ *homeworkpb.ListUsersRequest) (*homeworkpb.ListUsersResponse, error) {
+	users, err := FindAllUsers(ctx, s.app.DB)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &homeworkpb.ListUsersResponse{Users: userPBs(users)}, nil
+}
+
+func (s *usersServer) GetUser(ctx context.Context, req *homeworkpb.GetUserRequest) (*homeworkpb.User, error) {
+	u, err := FindUserByID(ctx, s.app.DB, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if u == nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return userPB(u), nil
+}
+
+func (s *usersServer) ListIncomingLikes(ctx context.Context, req *homeworkpb.ListIncomingLikesRequest) (*homeworkpb.ListUsersResponse, error) {
+	users, err := FindIncomingLikes(ctx, s.app.DB, req.GetUserId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &homeworkpb.ListUsersResponse{Users: userPBs(users)}, nil
+}
+
+type ratingsServer struct {
+	homeworkpb.UnimplementedRatingsServer
+	app *appContext
+}
+
+func (s *ratingsServer) ListRatings(ctx context.Context, req *homeworkpb.ListRatingsRequest) (*homeworkpb.ListRatingsResponse, error) {
+	if req.GetFromUserId() == "" && req.GetToUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "from_user_id or to_user_id is required")
+	}
+
+	ratings, err := FindRatings(ctx, s.app.DB, &RatingParams{
+		Filter: &Rating{
+			FromUserID: req.GetFromUserId(),
+			ToUserID:   req.GetToUserId(),
+			Type:       ratingType(req.GetType()),
+		},
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	out := make([]*homeworkpb.Rating, 0, len(ratings))
+	for _, r := range ratings {
+		out = append(out, &homeworkpb.Rating{
+			CreatedDate: timestamppb.New(r.CreatedDate),
+			FromUserId:  r.FromUserID,
+			Id:          string(r.ID),
+			Reason:      r.Reason,
+			ToUserId:    r.ToUserID,
+			Type:        ratingTypePB(r.Type),
+		})
+	}
+	return &homeworkpb.ListRatingsResponse{Ratings: out}, nil
+}
+
+func (s *ratingsServer) CreateRating(ctx context.Context, req *homeworkpb.CreateRatingRequest) (*homeworkpb.CreateRatingResponse, error) {
+	_, err := s.app.rate(ctx, &Rating{
+		FromUserID: req.GetFromUserId(),
+		Reason:     req.GetReason(),
+		ToUserID:   req.GetToUserId(),
+		Type:       ratingType(req.GetType()),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &homeworkpb.CreateRatingResponse{}, nil
+}
+
+type matchesServer struct {
+	homeworkpb.UnimplementedMatchesServer
+	app *appContext
+}
+
+func (s *matchesServer) ListMatches(ctx context.Context, req *homeworkpb.ListMatchesRequest) (*homeworkpb.ListMatchesResponse, error) {
+	matches, err := FindMatches(ctx, s.app.DB, req.GetUserId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	cfg := s.app.Config
+	out := make([]*homeworkpb.Match, 0, len(matches))
+	for _, m := range matches {
+		if cfg.Features.MatchExpiry {
+			m.SetExpiresAt(cfg.Matches.ExpireAfter)
+		}
+
+		out = append(out, &homeworkpb.Match{
+			CreatedDate:      timestamppb.New(m.CreatedDate),
+			ExpiresAt:        optionalTimestamp(m.ExpiresAt),
+			Id:               string(m.ID),
+			LastActivityDate: timestamppb.New(m.LastActivityDate),
+			Status:           homeworkpb.MatchStatus(homeworkpb.MatchStatus_value["MATCH_STATUS_"+strings.ToUpper(string(m.Status))]),
+			User:             userPB(m.User),
+			UserIds:          m.UserIDs,
+		})
+	}
+	return &homeworkpb.ListMatchesResponse{Matches: out}, nil
+}
+
+// grpcError turns errors from the data functions into grpc statuses. Turned down ratings keep
+// the meaning of their http status
+func grpcError(err error) error {
+	e, ok := err.(*RatingError)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	switch e.Status {
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, e.Error())
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, e.Error())
+	}
+	return status.Error(codes.InvalidArgument, e.Error())
+}
+
+func userPB(u *User) *homeworkpb.User {
+	// the account view already fills in the role and status of users from before them
+	v := NewAccountView(u)
+
+	photos := make([]*homeworkpb.Photo, 0, len(v.Photos))
+	for _, p := range v.Photos {
+		photos = append(photos, &homeworkpb.Photo{
+			Height:       int32(p.Height),
+			Id:           p.ID,
+			ThumbnailUrl: p.ThumbnailURL,
+			Url:          p.URL,
+			Width:        int32(p.Width),
+		})
+	}
+
+	return &homeworkpb.User{
+		Age:         int32(v.Age),
+		Bio:         v.Bio,
+		CreatedDate: timestamppb.New(v.CreatedDate),
+		Id:          v.ID,
+		JobTitle:    v.JobTitle,
+		Location:    v.Location,
+		Name:        v.Name,
+		Photos:      photos,
+		Role:        string(v.Role),
+		Status:      string(v.Status),
+	}
+}
+
+func userPBs(users []*User) []*homeworkpb.User {
+	out := make([]*homeworkpb.User, 0, len(users))
+	for _, u := range users {
+		out = append(out, userPB(u))
+	}
+	return out
+}
+
+// ratingType maps RATING_TYPE_LIKE to LIKE. Unspecified is empty
+func ratingType(t homeworkpb.RatingType) RatingType {
+	if t == homeworkpb.RatingType_RATING_TYPE_UNSPECIFIED {
+		return ""
+	}
+	return RatingType(strings.TrimPrefix(t.String(), "RATING_TYPE_"))
+}
+
+// ratingTypePB maps LIKE to RATING_TYPE_LIKE. Types the proto doesn't have yet are unspecified
+func ratingTypePB(t RatingType) homeworkpb.RatingType {
+	return homeworkpb.RatingType(homeworkpb.RatingType_value["RATING_TYPE_"+string(t)])
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
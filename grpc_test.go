@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urvesh/backend-homework/homeworkpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves the grpc api on an in process listener and connects to it
+func dialGRPC(t *testing.T, app *appContext, services map[string]string) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(app, services)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestGRPC_auth(t *testing.T) {
+	conn := dialGRPC(t, &appContext{Config: DefaultConfig()}, map[string]string{"s3cret": "notifications"})
+	ratings := homeworkpb.NewRatingsClient(conn)
+	req := &homeworkpb.CreateRatingRequest{FromUserId: "a", ToUserId: "b", Type: homeworkpb.RatingType_RATING_TYPE_REPORT}
+
+	_, err := ratings.CreateRating(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "calls without a token are turned away")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer guess")
+	_, err = ratings.CreateRating(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// with a token, calls go through to the same checks as the http api
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+	_, err = ratings.CreateRating(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "reason cannot be blank", status.Convert(err).Message())
+
+	_, err = ratings.ListRatings(ctx, &homeworkpb.ListRatingsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCAuth_authorize(t *testing.T) {
+	auth := &grpcAuth{services: map[string]string{"s3cret": "notifications"}}
+
+	md := metadata.Pairs("authorization", "Bearer s3cret", "x-request-id", "req-1")
+	ctx, err := auth.authorize(metadata.NewIncomingContext(context.Background(), md))
+	assert.Nil(t, err)
+	assert.Equal(t, &AuditActor{ID: "service:notifications", RequestID: "req-1"}, AuditActorFrom(ctx))
+}
+
+func Test_ratingTypePB(t *testing.T) {
+	// a new rating type needs to be added to homework.proto too
+	for _, info := range ratingTypes {
+		pb := ratingTypePB(info.Type)
+		assert.NotEqual(t, homeworkpb.RatingType_RATING_TYPE_UNSPECIFIED, pb, "%s is missing from homework.proto", info.Type)
+		assert.Equal(t, info.Type, ratingType(pb))
+	}
+}
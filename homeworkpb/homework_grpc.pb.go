@@ -0,0 +1,468 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: homeworkpb/homework.proto
+
+// homework.v1 is the grpc api for internal services. It's backed by the same data functions as
+// the http api. Regenerate the Go code with `make proto` after changing this file
+
+package homeworkpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Users_ListUsers_FullMethodName         = "/homework.v1.Users/ListUsers"
+	Users_GetUser_FullMethodName           = "/homework.v1.Users/GetUser"
+	Users_ListIncomingLikes_FullMethodName = "/homework.v1.Users/ListIncomingLikes"
+)
+
+// UsersClient is the client API for Users service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Users finds users. Only active users are found, like in the http api
+type UsersClient interface {
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// GetUser fails with NOT_FOUND if there's no such user
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+	// ListIncomingLikes returns the users who liked a user, superlikes first
+	ListIncomingLikes(ctx context.Context, in *ListIncomingLikesRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+}
+
+type usersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUsersClient(cc grpc.ClientConnInterface) UsersClient {
+	return &usersClient{cc}
+}
+
+func (c *usersClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, Users_ListUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Users_GetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ListIncomingLikes(ctx context.Context, in *ListIncomingLikesRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, Users_ListIncomingLikes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UsersServer is the server API for Users service.
+// All implementations must embed UnimplementedUsersServer
+// for forward compatibility.
+//
+// Users finds users. Only active users are found, like in the http api
+type UsersServer interface {
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// GetUser fails with NOT_FOUND if there's no such user
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	// ListIncomingLikes returns the users who liked a user, superlikes first
+	ListIncomingLikes(context.Context, *ListIncomingLikesRequest) (*ListUsersResponse, error)
+	mustEmbedUnimplementedUsersServer()
+}
+
+// UnimplementedUsersServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUsersServer struct{}
+
+func (UnimplementedUsersServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedUsersServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedUsersServer) ListIncomingLikes(context.Context, *ListIncomingLikesRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListIncomingLikes not implemented")
+}
+func (UnimplementedUsersServer) mustEmbedUnimplementedUsersServer() {}
+func (UnimplementedUsersServer) testEmbeddedByValue()               {}
+
+// UnsafeUsersServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UsersServer will
+// result in compilation errors.
+type UnsafeUsersServer interface {
+	mustEmbedUnimplementedUsersServer()
+}
+
+func RegisterUsersServer(s grpc.ServiceRegistrar, srv UsersServer) {
+	// If the following call pancis, it indicates UnimplementedUsersServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Users_ServiceDesc, srv)
+}
+
+func _Users_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Users_ListUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Users_GetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ListIncomingLikes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListIncomingLikesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ListIncomingLikes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Users_ListIncomingLikes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ListIncomingLikes(ctx, req.(*ListIncomingLikesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Users_ServiceDesc is the grpc.ServiceDesc for Users service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Users_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "homework.v1.Users",
+	HandlerType: (*UsersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListUsers",
+			Handler:    _Users_ListUsers_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _Users_GetUser_Handler,
+		},
+		{
+			MethodName: "ListIncomingLikes",
+			Handler:    _Users_ListIncomingLikes_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "homeworkpb/homework.proto",
+}
+
+const (
+	Ratings_ListRatings_FullMethodName  = "/homework.v1.Ratings/ListRatings"
+	Ratings_CreateRating_FullMethodName = "/homework.v1.Ratings/CreateRating"
+)
+
+// RatingsClient is the client API for Ratings service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Ratings finds and adds ratings
+type RatingsClient interface {
+	// ListRatings returns the ratings matching every field set in the request
+	ListRatings(ctx context.Context, in *ListRatingsRequest, opts ...grpc.CallOption) (*ListRatingsResponse, error)
+	// CreateRating goes through the same checks as the http api: turned down ratings fail with
+	// INVALID_ARGUMENT, PERMISSION_DENIED for unusable accounts or RESOURCE_EXHAUSTED for used up
+	// quotas
+	CreateRating(ctx context.Context, in *CreateRatingRequest, opts ...grpc.CallOption) (*CreateRatingResponse, error)
+}
+
+type ratingsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRatingsClient(cc grpc.ClientConnInterface) RatingsClient {
+	return &ratingsClient{cc}
+}
+
+func (c *ratingsClient) ListRatings(ctx context.Context, in *ListRatingsRequest, opts ...grpc.CallOption) (*ListRatingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRatingsResponse)
+	err := c.cc.Invoke(ctx, Ratings_ListRatings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ratingsClient) CreateRating(ctx context.Context, in *CreateRatingRequest, opts ...grpc.CallOption) (*CreateRatingResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateRatingResponse)
+	err := c.cc.Invoke(ctx, Ratings_CreateRating_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RatingsServer is the server API for Ratings service.
+// All implementations must embed UnimplementedRatingsServer
+// for forward compatibility.
+//
+// Ratings finds and adds ratings
+type RatingsServer interface {
+	// ListRatings returns the ratings matching every field set in the request
+	ListRatings(context.Context, *ListRatingsRequest) (*ListRatingsResponse, error)
+	// CreateRating goes through the same checks as the http api: turned down ratings fail with
+	// INVALID_ARGUMENT, PERMISSION_DENIED for unusable accounts or RESOURCE_EXHAUSTED for used up
+	// quotas
+	CreateRating(context.Context, *CreateRatingRequest) (*CreateRatingResponse, error)
+	mustEmbedUnimplementedRatingsServer()
+}
+
+// UnimplementedRatingsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRatingsServer struct{}
+
+func (UnimplementedRatingsServer) ListRatings(context.Context, *ListRatingsRequest) (*ListRatingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRatings not implemented")
+}
+func (UnimplementedRatingsServer) CreateRating(context.Context, *CreateRatingRequest) (*CreateRatingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateRating not implemented")
+}
+func (UnimplementedRatingsServer) mustEmbedUnimplementedRatingsServer() {}
+func (UnimplementedRatingsServer) testEmbeddedByValue()                 {}
+
+// UnsafeRatingsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RatingsServer will
+// result in compilation errors.
+type UnsafeRatingsServer interface {
+	mustEmbedUnimplementedRatingsServer()
+}
+
+func RegisterRatingsServer(s grpc.ServiceRegistrar, srv RatingsServer) {
+	// If the following call pancis, it indicates UnimplementedRatingsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Ratings_ServiceDesc, srv)
+}
+
+func _Ratings_ListRatings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRatingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RatingsServer).ListRatings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ratings_ListRatings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RatingsServer).ListRatings(ctx, req.(*ListRatingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ratings_CreateRating_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRatingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RatingsServer).CreateRating(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ratings_CreateRating_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RatingsServer).CreateRating(ctx, req.(*CreateRatingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Ratings_ServiceDesc is the grpc.ServiceDesc for Ratings service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Ratings_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "homework.v1.Ratings",
+	HandlerType: (*RatingsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListRatings",
+			Handler:    _Ratings_ListRatings_Handler,
+		},
+		{
+			MethodName: "CreateRating",
+			Handler:    _Ratings_CreateRating_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "homeworkpb/homework.proto",
+}
+
+const (
+	Matches_ListMatches_FullMethodName = "/homework.v1.Matches/ListMatches"
+)
+
+// MatchesClient is the client API for Matches service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Matches finds matches
+type MatchesClient interface {
+	// ListMatches returns a user's active matches, newest first, with the other user
+	ListMatches(ctx context.Context, in *ListMatchesRequest, opts ...grpc.CallOption) (*ListMatchesResponse, error)
+}
+
+type matchesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMatchesClient(cc grpc.ClientConnInterface) MatchesClient {
+	return &matchesClient{cc}
+}
+
+func (c *matchesClient) ListMatches(ctx context.Context, in *ListMatchesRequest, opts ...grpc.CallOption) (*ListMatchesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMatchesResponse)
+	err := c.cc.Invoke(ctx, Matches_ListMatches_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MatchesServer is the server API for Matches service.
+// All implementations must embed UnimplementedMatchesServer
+// for forward compatibility.
+//
+// Matches finds matches
+type MatchesServer interface {
+	// ListMatches returns a user's active matches, newest first, with the other user
+	ListMatches(context.Context, *ListMatchesRequest) (*ListMatchesResponse, error)
+	mustEmbedUnimplementedMatchesServer()
+}
+
+// UnimplementedMatchesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMatchesServer struct{}
+
+func (UnimplementedMatchesServer) ListMatches(context.Context, *ListMatchesRequest) (*ListMatchesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMatches not implemented")
+}
+func (UnimplementedMatchesServer) mustEmbedUnimplementedMatchesServer() {}
+func (UnimplementedMatchesServer) testEmbeddedByValue()                 {}
+
+// UnsafeMatchesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MatchesServer will
+// result in compilation errors.
+type UnsafeMatchesServer interface {
+	mustEmbedUnimplementedMatchesServer()
+}
+
+func RegisterMatchesServer(s grpc.ServiceRegistrar, srv MatchesServer) {
+	// If the following call pancis, it indicates UnimplementedMatchesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Matches_ServiceDesc, srv)
+}
+
+func _Matches_ListMatches_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMatchesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchesServer).ListMatches(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Matches_ListMatches_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchesServer).ListMatches(ctx, req.(*ListMatchesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Matches_ServiceDesc is the grpc.ServiceDesc for Matches service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Matches_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "homework.v1.Matches",
+	HandlerType: (*MatchesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMatches",
+			Handler:    _Matches_ListMatches_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "homeworkpb/homework.proto",
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// DocID is the id of a document. It's a hex string in Go and json, and stored as an ObjectID.
+// Ids that aren't ObjectIDs, e.g. in hand written fixtures, are stored as strings
+type DocID string
+
+// NewDocID makes a new unique id
+func NewDocID() DocID {
+	return DocID(primitive.NewObjectID().Hex())
+}
+
+// MarshalBSONValue stores the id as an ObjectID when it is one
+func (id DocID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if oid, err := primitive.ObjectIDFromHex(string(id)); err == nil {
+		return bson.MarshalValue(oid)
+	}
+	return bson.MarshalValue(string(id))
+}
+
+// UnmarshalBSONValue reads an id stored as either an ObjectID or a string
+func (id *DocID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bsoncore.Value{Type: t, Data: data}
+
+	if oid, ok := v.ObjectIDOK(); ok {
+		*id = DocID(oid.Hex())
+		return nil
+	}
+	if s, ok := v.StringValueOK(); ok {
+		*id = DocID(s)
+		return nil
+	}
+
+	return NewErrorf("cannot read a %s as an id", t)
+}
+
+// docIDs converts ids for use in a filter
+func docIDs(ids []string) []DocID {
+	out := make([]DocID, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, DocID(id))
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+func TestDocID_bson(t *testing.T) {
+	type doc struct {
+		ID    DocID   `bson:"_id,omitempty"`
+		Other []DocID `bson:"other,omitempty"`
+	}
+
+	b, err := bson.Marshal(&doc{ID: "5e2e39ee290f5a56ffda9ed5"})
+	assert.Nil(t, err)
+	assert.Equal(t, bsontype.ObjectID, bson.Raw(b).Lookup("_id").Type)
+
+	var got doc
+	assert.Nil(t, bson.Unmarshal(b, &got))
+	assert.Equal(t, DocID("5e2e39ee290f5a56ffda9ed5"), got.ID)
+
+	// ids that aren't ObjectIDs stay strings
+	b, err = bson.Marshal(&doc{ID: "a", Other: []DocID{"b"}})
+	assert.Nil(t, err)
+	assert.Equal(t, bsontype.String, bson.Raw(b).Lookup("_id").Type)
+	assert.Nil(t, bson.Unmarshal(b, &got))
+	assert.Equal(t, doc{ID: "a", Other: []DocID{"b"}}, got)
+
+	// an empty id is left out
+	b, err = bson.Marshal(&doc{})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.Raw(b).Lookup("_id").Type, bsontype.Type(0))
+
+	// and filters convert it too
+	b, err = bson.Marshal(bson.M{"_id": DocID("5e2e39ee290f5a56ffda9ed5")})
+	assert.Nil(t, err)
+	assert.Equal(t, bsontype.ObjectID, bson.Raw(b).Lookup("_id").Type)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// maxPhotoPixels stops decompression bombs, a small file that decodes to a huge image
+const maxPhotoPixels = 40 * 1000 * 1000
+
+// processedImage is an uploaded image ready to store. Re-encoding it drops all metadata,
+// including EXIF and GPS tags
+type processedImage struct {
+	ContentType string
+	Ext         string
+	Data        []byte
+	Thumbnail   []byte
+	Width       int
+	Height      int
+}
+
+// processImage checks that data is a jpeg, png or gif, turns it upright, strips its metadata
+// and makes a thumbnail that fits in a thumbSize square
+func processImage(data []byte, thumbSize int) (*processedImage, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, fmt.Errorf("unsupported image type %s. must be jpeg, png or gif", contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %s", err)
+	}
+	if cfg.Width*cfg.Height > maxPhotoPixels {
+		return nil, fmt.Errorf("image is too large, at most %d megapixels", maxPhotoPixels/1000/1000)
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		// only the first frame of animations is kept
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %s", err)
+	}
+
+	rgba := toRGBA(img)
+	if contentType == "image/jpeg" {
+		rgba = orient(rgba, jpegOrientation(data))
+	}
+
+	out := &processedImage{
+		Width:  rgba.Bounds().Dx(),
+		Height: rgba.Bounds().Dy(),
+	}
+
+	// photos are stored as jpeg, except ones that may have transparency
+	encode := func(img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		var err error
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		return buf.Bytes(), err
+	}
+
+	out.ContentType, out.Ext = "image/png", ".png"
+	if contentType == "image/jpeg" {
+		out.ContentType, out.Ext = "image/jpeg", ".jpg"
+	}
+
+	if out.Data, err = encode(rgba); err != nil {
+		return nil, NewErrorf("error encoding image: %s", err)
+	}
+
+	if out.Thumbnail, err = encode(thumbnail(rgba, thumbSize)); err != nil {
+		return nil, NewErrorf("error encoding thumbnail: %s", err)
+	}
+
+	return out, nil
+}
+
+// toRGBA copies an image into an RGBA image whose bounds start at 0, 0
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// thumbnail scales an image down to fit in a size by size square, averaging the pixels each
+// thumbnail pixel covers. Images that already fit are returned as is
+func thumbnail(src *image.RGBA, size int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, sh*size/sw
+	if sh > sw {
+		dw, dh = sw*size/sh, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			n := (x1 - x0) * (y1 - y0)
+			i := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// orient turns an image upright according to its EXIF orientation, 1 to 8. Cameras often save
+// photos sideways and rely on the tag, which is dropped when the image is re-encoded
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // turned left, needs a turn right
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // turned right, needs a turn left
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag of a jpeg, or 1 (upright) if there isn't one
+func jpegOrientation(data []byte) int {
+	orientation, err := readJPEGOrientation(data)
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+var errNoOrientation = errors.New("no orientation")
+
+func readJPEGOrientation(data []byte) (int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errNoOrientation
+	}
+
+	// walk the segments up to the image data, looking for the APP1 exif segment
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 0, errNoOrientation
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 0, errNoOrientation
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+
+	return 0, errNoOrientation
+}
+
+// tiffOrientation finds the orientation tag, 0x0112, in the first IFD of exif's tiff structure
+func tiffOrientation(tiff []byte) (int, error) {
+	if len(tiff) < 8 {
+		return 0, errNoOrientation
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, errNoOrientation
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0, errNoOrientation
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:])), nil
+		}
+	}
+
+	return 0, errNoOrientation
+}
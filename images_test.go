@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withExifOrientation adds an exif segment with just an orientation tag to a jpeg
+func withExifOrientation(t *testing.T, data []byte, orientation uint16) []byte {
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func encodeTestImage(t *testing.T, w, h int, format string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 10), G: uint8(y * 10), B: 100, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	assert.Nil(t, err)
+	return buf.Bytes()
+}
+
+func Test_processImage_jpeg(t *testing.T) {
+	data := withExifOrientation(t, encodeTestImage(t, 40, 20, "jpeg"), 6)
+	assert.Equal(t, 6, jpegOrientation(data))
+
+	img, err := processImage(data, 10)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "image/jpeg", img.ContentType)
+	assert.Equal(t, 20, img.Width, "image should be turned upright")
+	assert.Equal(t, 40, img.Height)
+	assert.False(t, bytes.Contains(img.Data, []byte("Exif")), "exif should be stripped")
+	assert.Equal(t, 1, jpegOrientation(img.Data))
+
+	thumb, _, err := image.DecodeConfig(bytes.NewReader(img.Thumbnail))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, thumb.Width)
+	assert.Equal(t, 10, thumb.Height)
+}
+
+func Test_processImage_png(t *testing.T) {
+	img, err := processImage(encodeTestImage(t, 8, 8, "png"), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", img.ContentType)
+	assert.Equal(t, ".png", img.Ext)
+}
+
+func Test_processImage_unsupported(t *testing.T) {
+	_, err := processImage([]byte("<html><body>hi</body></html>"), 10)
+	assert.EqualError(t, err, "unsupported image type text/html; charset=utf-8. must be jpeg, png or gif")
+}
+
+func Test_orient(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	turned := orient(src, 6)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), turned.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, turned.At(0, 0), "the top left pixel goes to the top right after a turn right")
+
+	turned = orient(src, 8)
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, turned.At(0, 1), "the top left pixel goes to the bottom left after a turn left")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Loader batches lookups by key, like a dataloader. Keys asked for within wait of the first
+// one are fetched together in one call, and each key is only fetched once, so a loader must
+// only live as long as one request
+type Loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+	wait  time.Duration
+
+	mu      sync.Mutex
+	pending []K
+	results map[K]*loaderResult[V]
+}
+
+type loaderResult[V any] struct {
+	done  chan struct{}
+	err   error
+	value V
+}
+
+// NewLoader is a constructor for a Loader. fetch returns the value of every key it found,
+// keys it leaves out load as the zero value
+func NewLoader[K comparable, V any](wait time.Duration, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:   fetch,
+		results: make(map[K]*loaderResult[V]),
+		wait:    wait,
+	}
+}
+
+// Load returns the value of key
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	values, err := l.LoadMany(ctx, []K{key})
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return values[0], nil
+}
+
+// LoadMany returns the value of each key, in order
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, error) {
+	results := make([]*loaderResult[V], 0, len(keys))
+
+	l.mu.Lock()
+	for _, k := range keys {
+		r, ok := l.results[k]
+		if !ok {
+			r = &loaderResult[V]{done: make(chan struct{})}
+			l.results[k] = r
+
+			// the first key of a batch starts the wait for more
+			if len(l.pending) == 0 {
+				time.AfterFunc(l.wait, func() { l.dispatch(ctx) })
+			}
+			l.pending = append(l.pending, k)
+		}
+		results = append(results, r)
+	}
+	l.mu.Unlock()
+
+	values := make([]V, 0, len(keys))
+	for _, r := range results {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if r.err != nil {
+			return nil, r.err
+		}
+		values = append(values, r.value)
+	}
+
+	return values, nil
+}
+
+// dispatch fetches the pending batch and hands out the values
+func (l *Loader[K, V]) dispatch(ctx context.Context) {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	results := make([]*loaderResult[V], 0, len(keys))
+	for _, k := range keys {
+		results = append(results, l.results[k])
+	}
+	l.mu.Unlock()
+
+	values, err := l.fetch(ctx, keys)
+	for i, r := range results {
+		r.err = err
+		r.value = values[keys[i]]
+		close(r.done)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoader_batches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	l := NewLoader(10*time.Millisecond, func(_ context.Context, keys []string) (map[string]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, keys)
+
+		values := make(map[string]string)
+		for _, k := range keys {
+			if k != "missing" {
+				values[k] = "value " + k
+			}
+		}
+		return values, nil
+	})
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b", "a", "missing"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			l.Load(ctx, key)
+		}(key)
+	}
+	wg.Wait()
+
+	assert.Len(t, batches, 1, "keys loaded together should be fetched in one batch")
+	assert.ElementsMatch(t, []string{"a", "b", "missing"}, batches[0])
+
+	// loaded keys come from the cache, new ones are fetched in a new batch
+	values, err := l.LoadMany(ctx, []string{"b", "c", "missing"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"value b", "value c", ""}, values)
+	assert.Len(t, batches, 2)
+	assert.Equal(t, []string{"c"}, batches[1])
+}
+
+func TestLoader_error(t *testing.T) {
+	l := NewLoader(time.Millisecond, func(_ context.Context, keys []string) (map[string]int, error) {
+		return nil, errors.New("db down")
+	})
+
+	_, err := l.LoadMany(context.Background(), []string{"a", "b"})
+	assert.EqualError(t, err, "db down")
+}
@@ -0,0 +1,36 @@
+package main
+
+import "log"
+
+// log levels, least severe first
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevels are the names of the levels LOG_LEVEL can be set to
+var logLevels = map[string]int{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// minLogLevel is the least severe level that's logged. It's set once from the config, before
+// anything runs in the background
+var minLogLevel = levelInfo
+
+// setLogLevel only logs messages of the named level and above. The level was validated with the
+// rest of the config
+func setLogLevel(name string) {
+	minLogLevel = logLevels[name]
+}
+
+// logf logs a message of the given level, unless the configured level leaves it out
+func logf(level int, format string, v ...interface{}) {
+	if level >= minLogLevel {
+		log.Printf(format, v...)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer setLogLevel("info")
+
+	setLogLevel("warn")
+	logf(levelInfo, "expired %d matches", 2)
+	assert.Empty(t, buf.String())
+
+	logf(levelWarn, "gave up")
+	logf(levelError, "failed")
+	assert.Contains(t, buf.String(), "gave up")
+	assert.Contains(t, buf.String(), "failed")
+}
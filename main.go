@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,7 +10,8 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -17,7 +19,14 @@ import (
 
 // appContext holds application level config
 type appContext struct {
-	DB *DB
+	Blobs       BlobStore
+	Clock       Clock
+	Config      *Config
+	Content     *ContentFilter
+	DB          *DB
+	DailyQuotas map[string]*DailyQuota
+	Quotas      QuotaStore
+	RateLimits  RateLimitStore
 }
 
 func main() {
@@ -26,44 +35,66 @@ func main() {
 		log.Println("warning no .env file found.")
 	}
 
-	// setup app config
-	app := &appContext{
-		DB: NewDB(),
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	// load default data in database
-	PopulateDatabase(app.DB)
+func setupRouter(app *appContext) *gin.Engine {
+	r := gin.Default()
+	r.Use(tracingMiddleware())
+	r.Use(auditMiddleware())
 
-	// register handlers
-	r := setupRouter(app)
+	if app.Config.Features.RateLimiting {
+		r.Use(rateLimitMiddleware(app.RateLimits, app.Clock, &app.Config.Limits))
+	}
 
-	err := r.Run(fmt.Sprintf(":%s", os.Getenv("PORT")))
-	if err != nil {
-		log.Fatal("error running server ", err)
+	for _, v := range apiVersions {
+		setupAPIRoutes(r.Group(v.Prefix(), useVersion(v)), app)
 	}
+
+	// the routes from before versioning stay, with their old responses, until clients have
+	// moved over to /v1. the sunset date was validated with the rest of the config
+	sunset, _ := app.Config.API.Sunset()
+	setupAPIRoutes(r.Group("", useVersion(apiLegacy{}), deprecatedRoutes(apiVersions[0], sunset)), app)
+
+	// photo urls are stored, so blobs stay outside of any version
+	r.GET("/blobs/*key", app.getBlob)
+	setupDocsRoutes(r)
+	setupGraphQLRoutes(r, app)
+
+	return r
 }
 
-func setupRouter(app *appContext) *gin.Engine {
-	r := gin.Default()
+// setupAPIRoutes adds every versioned route to the group
+func setupAPIRoutes(r gin.IRouter, app *appContext) {
 	r.GET("/users", app.getAllUsers)
 	r.GET("/users/:id/likes", app.getIncomingLikes)
 	r.PUT("/users/:id", app.editUser)
+	r.DELETE("/users/:id", app.deleteAccount)
+	r.PUT("/users/:id/status", app.setAccountStatus)
+	r.GET("/users/:id/export", app.exportUser)
 	r.POST("/users/:id/ratings", app.newRating)
+	r.POST("/users/:id/ratings/batch", app.newRatingBatch)
 	r.GET("/users/:id/matches", app.getMatches)
+	r.DELETE("/users/:id/matches/:otherId", app.unmatch)
+	r.POST("/users/:id/matches/:otherId/activity", app.matchActivity)
+	r.GET("/users/:id/feed", app.getFeed)
 
-	return r
+	setupPhotoRoutes(r, app)
+	setupAdminRoutes(r, app)
 }
 
 // see all users that exist within db. helps to get user ids for testing
 func (app *appContext) getAllUsers(c *gin.Context) {
-	userIDs, err := FindAllUsers(app.DB)
+	userIDs, err := FindAllUsers(c.Request.Context(), app.DB)
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data": userIDs,
+		"data": version(c).Profiles(userIDs),
 	})
 	return
 }
@@ -72,14 +103,14 @@ func (app *appContext) getAllUsers(c *gin.Context) {
 func (app *appContext) getIncomingLikes(c *gin.Context) {
 	userId := c.Param("id")
 
-	users, err := FindIncomingLikes(app.DB, userId)
+	users, err := FindIncomingLikes(c.Request.Context(), app.DB, userId)
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data": users,
+		"data": version(c).Profiles(users),
 	})
 	return
 }
@@ -88,23 +119,34 @@ func (app *appContext) getIncomingLikes(c *gin.Context) {
 func (app *appContext) editUser(c *gin.Context) {
 	userId := c.Param("id")
 
-	var u User
-	if err := c.ShouldBindJSON(&u); err != nil {
+	var body UserUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
 		if err == io.EOF {
-			errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body. allowed one of more fields: age, bio, jobTitle, name", err))
+			errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body. allowed one of more fields: age, bio, jobTitle, location, name, notifications"))
 			return
 		}
-		errorResponse(c, http.StatusInternalServerError, NewErrorf("error binding to user struct: %s", err))
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
 		return
 	}
 
-	// user can only change certain number of fields depending on contract.
-	u.ID = userId
+	if err := body.Validate(); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
 
-	// zero out time so it cannot be overridden
-	u.CreatedDate = time.Time{}
+	// only the fields of the update can be changed, not e.g. the role or status
+	u := body.User(userId)
 
-	user, err := u.Edit(app.DB)
+	if !app.requireUsableAccount(c, userId) {
+		return
+	}
+
+	bio := app.filterContent(c, FieldBio, u.Bio)
+	if bio == nil {
+		return
+	}
+
+	user, err := u.Edit(c.Request.Context(), app.DB, app.Clock.Now())
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, err)
 		return
@@ -115,45 +157,456 @@ func (app *appContext) editUser(c *gin.Context) {
 		return
 	}
 
+	if !app.queueReview(c, bio, &ContentReview{TargetType: "user", TargetID: userId, UserID: userId, Field: FieldBio, Text: u.Bio}) {
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"data": user,
+		"data": version(c).Account(user),
 	})
 	return
 }
 
 // add a new like entry. Does not validate if user ids exist within the system
 func (app *appContext) newRating(c *gin.Context) {
+	var body RatingRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
+		return
+	}
+
+	res, err := app.rate(c.Request.Context(), body.Rating(c.Param("id")))
+	if res != nil && res.Quota != nil {
+		res.Quota.SetHeaders(c, res.QuotaName)
+	}
+
+	if err != nil {
+		if e, ok := err.(*RatingError); ok && e.Status == http.StatusTooManyRequests {
+			c.Header("Retry-After", strconv.Itoa(int(res.Quota.ResetAt.Sub(app.Clock.Now()).Seconds())+1))
+		}
+		ratingErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, nil)
+	return
+}
+
+// add many ratings at once, e.g. swipes queued while the app was offline. Each rating is checked
+// like a single one and gets its own result, in order. The ratings are saved with bulk writes
+func (app *appContext) newRatingBatch(c *gin.Context) {
+	var body struct {
+		Ratings []*RatingRequest `json:"ratings"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
+		return
+	}
+
+	max := app.Config.Limits.RatingBatchSize
+	if len(body.Ratings) == 0 || len(body.Ratings) > max {
+		errorResponse(c, http.StatusBadRequest, NewErrorf("ratings must have between 1 and %d ratings", max))
+		return
+	}
+
+	ctx := c.Request.Context()
 	id := c.Param("id")
-	var r Rating
+	if err := app.checkRater(ctx, id); err != nil {
+		ratingErrorResponse(c, err)
+		return
+	}
+
+	ratings := make([]*Rating, 0, len(body.Ratings))
+	toUserIDs := make([]string, 0, len(body.Ratings))
+	for _, req := range body.Ratings {
+		r := req.Rating(id)
+		ratings = append(ratings, r)
+		if r.ToUserID != "" {
+			toUserIDs = append(toUserIDs, r.ToUserID)
+		}
+	}
+
+	batch, err := LoadRatingBatch(ctx, app.DB, id, toUserIDs)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	type review struct {
+		r      *Rating
+		reason *ContentResult
+	}
+
+	now := app.Clock.Now()
+	quotas := make(map[string]*QuotaResult)
+	// quota used by each type, given back if the batch isn't written
+	used := make(map[string]int)
+	refund := func() {
+		for name, n := range used {
+			app.refundQuota(ctx, name, id, quotas[name], n)
+		}
+	}
+	results := make([]*RatingBatchResult, len(ratings))
+	reviews := make([]review, 0)
+	for i, r := range ratings {
+		results[i] = &RatingBatchResult{}
+
+		info, err := checkRating(r)
+		var reason *ContentResult
+		if err == nil {
+			reason, err = app.checkReason(r)
+		}
+		// like single ratings, repeats don't use up the quota
+		if err == nil && !batch.Exists(r) {
+			var res *RatingResult
+			res, err = app.useQuota(ctx, r, info)
+			if res != nil && res.Quota != nil {
+				quotas[res.QuotaName] = res.Quota
+				if err == nil {
+					used[res.QuotaName]++
+				}
+			}
+		}
 
-	if err := c.ShouldBindJSON(&r); err != nil {
-		errorResponse(c, http.StatusInternalServerError, NewErrorf("error binding data to Rating object %s", err))
+		if err != nil {
+			e, ok := err.(*RatingError)
+			if !ok {
+				refund()
+				errorResponse(c, http.StatusInternalServerError, err)
+				return
+			}
+			results[i] = &RatingBatchResult{Error: e.Error(), Reasons: e.Reasons, Status: RatingRejected}
+			continue
+		}
+
+		if err := batch.Add(ctx, r, info, now, results[i]); err != nil {
+			refund()
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		if results[i].Status == RatingCreated {
+			reviews = append(reviews, review{r, reason})
+		}
+	}
+
+	err = batch.Write(ctx, app.DB)
+	if err != nil {
+		refund()
+	}
+
+	for name, q := range quotas {
+		q.SetHeaders(c, name)
+	}
+
+	if err != nil {
+		ratingErrorResponse(c, err)
 		return
 	}
 
+	for _, rv := range reviews {
+		if err := app.queueRatingReview(ctx, rv.r, rv.reason); err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+// ratingErrorResponse responds with the status of a turned down rating, and what the content
+// filter found in its reason. Other errors are internal
+func ratingErrorResponse(c *gin.Context, err error) {
+	e, ok := err.(*RatingError)
+	if !ok {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(e.Reasons) > 0 {
+		c.AbortWithStatusJSON(e.Status, gin.H{
+			"error":   e.Error(),
+			"reasons": e.Reasons,
+		})
+		return
+	}
+	errorResponse(c, e.Status, e)
+}
+
+// RatingError is a rating that was turned down, with the http status that says why
+type RatingError struct {
+	msg string
+	// Reasons are what the content filter found in the rating's reason
+	Reasons []string
+	Status  int
+}
+
+func (e *RatingError) Error() string {
+	return e.msg
+}
+
+// Extensions adds the status and reasons to graphql errors
+func (e *RatingError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"status": e.Status}
+	if len(e.Reasons) > 0 {
+		ext["reasons"] = e.Reasons
+	}
+	return ext
+}
+
+// RatingResult is what came of a rating. Quota is the daily quota it used, if its type has one
+// and it isn't a repeat
+type RatingResult struct {
+	Quota     *QuotaResult
+	QuotaName string
+}
+
+// rate saves a rating once it's valid, the rater's account is usable, its reason passes the
+// content filter and the rater has quota left for it. Ratings that are turned down return a
+// *RatingError. A quota refusal still returns the result, with the state of the quota
+func (app *appContext) rate(ctx context.Context, r *Rating) (*RatingResult, error) {
+	info, err := checkRating(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.checkRater(ctx, r.FromUserID); err != nil {
+		return nil, err
+	}
+
+	reason, err := app.checkReason(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// repeating a rating doesn't use up the quota
+	res := &RatingResult{}
+	if _, ok := app.DailyQuotas[info.Quota]; ok {
+		exists, err := FindRatingExists(ctx, app.DB, &RatingParams{
+			Filter: &Rating{
+				FromUserID: r.FromUserID,
+				ToUserID:   r.ToUserID,
+				Type:       r.Type,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			if res, err = app.useQuota(ctx, r, info); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	if err := r.Save(ctx, app.DB, app.Clock.Now()); err != nil {
+		if res.Quota != nil {
+			app.refundQuota(ctx, res.QuotaName, r.FromUserID, res.Quota, 1)
+		}
+		return res, err
+	}
+
+	return res, app.queueRatingReview(ctx, r, reason)
+}
+
+// checkRating turns down ratings that are missing fields or don't follow the rules of their type
+func checkRating(r *Rating) (*RatingTypeInfo, error) {
 	if r.ToUserID == "" {
-		errorResponse(c, http.StatusBadRequest, errors.New("missing one or more required fields: toUserId, type"))
+		return nil, &RatingError{msg: "missing one or more required fields: toUserId, type", Status: http.StatusBadRequest}
+	}
+
+	info, ok := LookupRatingType(r.Type)
+	if !ok {
+		return nil, &RatingError{msg: fmt.Sprintf("type must be one of %s", strings.Join(RatingTypeNames(), ", ")), Status: http.StatusBadRequest}
+	}
+
+	if info.RequiresReason && r.Reason == "" {
+		return nil, &RatingError{msg: "reason cannot be blank", Status: http.StatusBadRequest}
+	}
+
+	return info, nil
+}
+
+// checkRater turns down ratings from accounts that can't make changes
+func (app *appContext) checkRater(ctx context.Context, id string) error {
+	rater, err := FindCurrentAccount(ctx, app.DB, id)
+	if err != nil {
+		return err
+	}
+
+	if rater != nil && !rater.Usable() {
+		return &RatingError{msg: fmt.Sprintf("account is %s", rater.Status), Status: http.StatusForbidden}
+	}
+
+	return nil
+}
+
+// checkReason runs the rating's reason through the content filter, turning it down if it's rejected
+func (app *appContext) checkReason(r *Rating) (*ContentResult, error) {
+	result := app.Content.Check(FieldReason, r.Reason)
+	if result.Verdict == Reject {
+		return nil, &RatingError{msg: strings.Join(result.Reasons, ", "), Reasons: result.Reasons, Status: http.StatusBadRequest}
+	}
+
+	return result, nil
+}
+
+// useQuota uses up the daily quota of the rating's type, if it has one. It's turned down once the
+// quota is used up, but the result with the state of the quota is returned either way
+func (app *appContext) useQuota(ctx context.Context, r *Rating, info *RatingTypeInfo) (*RatingResult, error) {
+	quota, ok := app.DailyQuotas[info.Quota]
+	if !ok {
+		return &RatingResult{}, nil
+	}
+
+	q, err := quota.Consume(ctx, app.Quotas, r.FromUserID, app.Clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RatingResult{Quota: q, QuotaName: quota.Name}
+	if !q.Allowed {
+		return res, &RatingError{msg: fmt.Sprintf("daily limit of %d %s reached", q.Limit, quota.Name), Status: http.StatusTooManyRequests}
+	}
+
+	return res, nil
+}
+
+// refundQuota gives back n units of a quota used by ratings that weren't saved, and updates q to
+// match. The rating's error is what the caller is told, so a failed refund is only logged
+func (app *appContext) refundQuota(ctx context.Context, name, userID string, q *QuotaResult, n int) {
+	if err := app.DailyQuotas[name].Refund(ctx, app.Quotas, userID, q.ResetAt, n); err != nil {
+		logf(levelError, "error refunding %d %s of user %s: %s", n, name, userID, err)
 		return
 	}
+	q.Remaining += n
+}
+
+// queueRatingReview queues a saved rating for a moderator if the filter flagged its reason
+func (app *appContext) queueRatingReview(ctx context.Context, r *Rating, reason *ContentResult) error {
+	if reason.Verdict != NeedsReview {
+		return nil
+	}
+
+	return QueueReview(ctx, app.DB, &ContentReview{
+		CreatedDate: app.Clock.Now(),
+		Field:       FieldReason,
+		Reasons:     reason.Reasons,
+		TargetID:    string(r.ID),
+		TargetType:  "rating",
+		Text:        r.Reason,
+		UserID:      r.FromUserID,
+	})
+}
+
+// stops suspended, deactivated and deleted accounts from making changes. Hidden users can still
+// use the app. Returns false if the request was aborted
+func (app *appContext) requireUsableAccount(c *gin.Context, id string) bool {
+	u, err := FindCurrentAccount(c.Request.Context(), app.DB, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return false
+	}
 
-	if r.Type != LIKE && r.Type != BLOCK && r.Type != REPORT {
-		errorResponse(c, http.StatusBadRequest, errors.New(fmt.Sprintf("type must be either %s, %s, or %s", LIKE, BLOCK, REPORT)))
+	if u != nil && !u.Usable() {
+		errorResponse(c, http.StatusForbidden, NewErrorf("account is %s", u.Status))
+		return false
+	}
+
+	return true
+}
+
+// runs user written text through the content filter, rejecting it with the filter's reasons.
+// Returns nil if the request was aborted
+func (app *appContext) filterContent(c *gin.Context, field ContentField, text string) *ContentResult {
+	result := app.Content.Check(field, text)
+	if result.Verdict == Reject {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error":   strings.Join(result.Reasons, ", "),
+			"reasons": result.Reasons,
+		})
+		return nil
+	}
+
+	return result
+}
+
+// queues saved content for a moderator if the filter flagged it. Returns false if the request
+// was aborted
+func (app *appContext) queueReview(c *gin.Context, result *ContentResult, r *ContentReview) bool {
+	if result.Verdict != NeedsReview {
+		return true
+	}
+
+	r.CreatedDate = app.Clock.Now()
+	r.Reasons = result.Reasons
+	if err := QueueReview(c.Request.Context(), app.DB, r); err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return false
+	}
+
+	return true
+}
+
+// download everything stored about a user as a json file
+func (app *appContext) exportUser(c *gin.Context) {
+	id := c.Param("id")
+
+	export, err := ExportUser(c.Request.Context(), app.DB, id, app.Clock.Now())
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	if r.Type == REPORT && r.Reason == "" {
-		errorResponse(c, http.StatusBadRequest, errors.New("reason cannot be blank"))
+	if export == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("user not found"))
 		return
 	}
 
-	r.FromUserID = id
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s-export.json"`, id))
+	c.IndentedJSON(http.StatusOK, version(c).Export(export))
+	return
+}
+
+// soft delete an account. It can be reactivated during the grace period, then it's purged
+func (app *appContext) deleteAccount(c *gin.Context) {
+	app.changeAccountStatus(c, UserDeleted)
+}
+
+// hide, deactivate or reactivate an account
+func (app *appContext) setAccountStatus(c *gin.Context) {
+	var body struct {
+		Status UserStatus `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || !SelfServiceStatuses[body.Status] {
+		errorResponse(c, http.StatusBadRequest, errors.New("status must be one of active, hidden, deactivated, deleted"))
+		return
+	}
+
+	app.changeAccountStatus(c, body.Status)
+}
+
+func (app *appContext) changeAccountStatus(c *gin.Context, status UserStatus) {
+	grace := app.Config.Accounts.DeletionGracePeriod
 
-	if err := r.Save(app.DB); err != nil {
+	user, err := ChangeAccountStatus(c.Request.Context(), app.DB, c.Param("id"), status, app.Clock.Now(), grace)
+	if err != nil {
+		if _, ok := err.(*AccountStatusError); ok {
+			errorResponse(c, http.StatusConflict, err)
+			return
+		}
 		errorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, nil)
+	if user == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": version(c).Account(user),
+	})
 	return
 }
 
@@ -161,14 +614,95 @@ func (app *appContext) newRating(c *gin.Context) {
 func (app *appContext) getMatches(c *gin.Context) {
 	id := c.Param("id")
 
-	users, err := FindMatches(app.DB, id)
+	users, err := FindMatches(c.Request.Context(), app.DB, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if app.Config.Features.MatchExpiry {
+		for _, m := range users {
+			m.SetExpiresAt(app.Config.Matches.ExpireAfter)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": version(c).Matches(users),
+	})
+	return
+}
+
+// ends an active match. the pair can't match again
+func (app *appContext) unmatch(c *gin.Context) {
+	id := c.Param("id")
+	otherID := c.Param("otherId")
+
+	ok, err := EndMatch(c.Request.Context(), app.DB, id, otherID, MatchUnmatched, id, app.Clock.Now())
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !ok {
+		errorResponse(c, http.StatusNotFound, errors.New("match not found"))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+	return
+}
+
+// records that the pair interacted, which pushes back when their match expires. A message
+// also notifies the other user
+func (app *appContext) matchActivity(c *gin.Context) {
+	id := c.Param("id")
+	otherID := c.Param("otherId")
+
+	var body struct {
+		Type MatchActivity `json:"type"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && err != io.EOF {
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
+		return
+	}
+	if body.Type != "" && body.Type != MatchMessage {
+		errorResponse(c, http.StatusBadRequest, errors.New("type must be message, or left out"))
+		return
+	}
+
+	ok, err := RecordMatchActivity(c.Request.Context(), app.DB, id, otherID, body.Type, app.Clock.Now())
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !ok {
+		errorResponse(c, http.StatusNotFound, errors.New("match not found"))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+	return
+}
+
+// gets users this user can still rate
+func (app *appContext) getFeed(c *gin.Context) {
+	id := c.Param("id")
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		errorResponse(c, http.StatusBadRequest, errors.New("limit must be a number between 1 and 100"))
+		return
+	}
+
+	users, err := FindFeed(c.Request.Context(), app.DB, id, app.Clock.Now(), limit)
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data": users,
+		"data": version(c).Profiles(users),
 	})
 	return
 }
@@ -181,14 +715,14 @@ func errorResponse(c *gin.Context, statusCode int, err error) {
 }
 
 // helper function to print json into console
-func prettyPrint(i interface{}) {
+func prettyPrint(w io.Writer, i interface{}) {
 	b, _ := json.MarshalIndent(i, "", "    ")
-	fmt.Println(string(b))
+	fmt.Fprintln(w, string(b))
 }
 
 // NewError will log and return a new instance of error
 func NewErrorf(format string, v ...interface{}) error {
 	msg := fmt.Sprintf(format, v...)
-	log.Println(msg)
+	logf(levelError, "%s", msg)
 	return errors.New(msg)
 }
@@ -11,9 +11,7 @@ import (
 )
 
 func initAppContext() *appContext {
-	return &appContext{
-		DB: NewDB(),
-	}
+	return newAppContext(DefaultConfig())
 }
 
 func TestFindAllUsers(t *testing.T) {
@@ -21,7 +19,7 @@ func TestFindAllUsers(t *testing.T) {
 	router := setupRouter(app)
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/users", nil)
+	req, _ := http.NewRequest("GET", "/v1/users", nil)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MatchStatus is where a match is in its lifecycle
+type MatchStatus string
+
+const (
+	MatchActive    MatchStatus = "active"
+	MatchUnmatched MatchStatus = "unmatched"
+	MatchExpired   MatchStatus = "expired"
+)
+
+// Match is formed when two users like each other. A pair only ever gets one match, so once
+// it ends the two users can't match again
+type Match struct {
+	CreatedDate time.Time  `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
+	EndedBy     string     `json:"endedBy,omitempty" bson:"endedBy,omitempty"`
+	EndedDate   *time.Time `json:"endedDate,omitempty" bson:"endedDate,omitempty"`
+	// ExpiresAt is only filled in for responses, when matches expire
+	ExpiresAt        *time.Time  `json:"expiresAt,omitempty" bson:"-"`
+	ID               DocID       `json:"_id,omitempty" bson:"_id,omitempty"`
+	LastActivityDate time.Time   `json:"lastActivityDate,omitempty" bson:"lastActivityDate,omitempty"`
+	Pair             string      `json:"-" bson:"pair,omitempty"`
+	Status           MatchStatus `json:"status,omitempty" bson:"status,omitempty"`
+	UserIDs          []string    `json:"userIds,omitempty" bson:"userIds,omitempty"`
+}
+
+// UserMatch is a match as seen by one of its users, with the other user filled in
+type UserMatch struct {
+	*Match
+	User *User `json:"user"`
+}
+
+// pairKey identifies two users regardless of order
+func pairKey(a, b string) string {
+	ids := []string{a, b}
+	sort.Strings(ids)
+	return strings.Join(ids, ":")
+}
+
+// OtherUserID returns the id of the user in the match who isn't userID
+func (m *Match) OtherUserID(userID string) string {
+	for _, id := range m.UserIDs {
+		if id != userID {
+			return id
+		}
+	}
+	return ""
+}
+
+// SetExpiresAt fills in when an active match expires if nothing happens before then
+func (m *Match) SetExpiresAt(expireAfter time.Duration) {
+	if m.Status != MatchActive {
+		return
+	}
+	expiresAt := m.LastActivityDate.Add(expireAfter)
+	m.ExpiresAt = &expiresAt
+}
+
+// CreateMatch records a new active match between two users, unless the pair already has one
+// in any status. An ended match isn't replaced, since the likes that made it are kept and
+// BackfillMatches would bring it back. Returns whether a match was created. The match and its
+// event are written in one transaction when the database supports them
+func CreateMatch(ctx context.Context, db *DB, a, b string, createdDate time.Time) (bool, error) {
+	coll := db.MongoClient.Collection("matches")
+
+	ids := []string{a, b}
+	sort.Strings(ids)
+
+	m := &Match{
+		CreatedDate:      createdDate,
+		ID:               NewDocID(),
+		LastActivityDate: createdDate,
+		Pair:             pairKey(a, b),
+		Status:           MatchActive,
+		UserIDs:          ids,
+	}
+
+	created := false
+	err := db.transaction(ctx, func(ctx context.Context) error {
+		upsert := true
+		res, err := coll.UpdateOne(ctx, bson.M{"pair": m.Pair}, bson.M{"$setOnInsert": m}, &options.UpdateOptions{Upsert: &upsert})
+		if err != nil {
+			return NewErrorf("error creating match %s: %s", m.Pair, err)
+		}
+
+		created = res.UpsertedCount > 0
+		if !created {
+			return nil
+		}
+
+		if err := recordChange(ctx, db, "match.create", "match", string(m.ID), nil, m, nil); err != nil {
+			return err
+		}
+
+		return emitEvent(ctx, db, EventMatchCreated, NewMatchView(m), createdDate)
+	})
+
+	return created && err == nil, err
+}
+
+// EndMatch moves the active match between two users to status, recording who ended it if
+// anyone did. Returns false if there was no active match
+func EndMatch(ctx context.Context, db *DB, userID, otherID string, status MatchStatus, endedBy string, now time.Time) (bool, error) {
+	filter := bson.M{
+		"pair":   pairKey(userID, otherID),
+		"status": MatchActive,
+	}
+
+	return endMatch(ctx, db, filter, status, endedBy, now)
+}
+
+// end the match found by filter, which should only find active ones. The change and its event
+// are written in one transaction when the database supports them
+func endMatch(ctx context.Context, db *DB, filter bson.M, status MatchStatus, endedBy string, now time.Time) (bool, error) {
+	coll := db.MongoClient.Collection("matches")
+
+	set := bson.M{
+		"status":    status,
+		"endedDate": now,
+	}
+	if endedBy != "" {
+		set["endedBy"] = endedBy
+	}
+
+	ended := false
+	err := db.transaction(ctx, func(ctx context.Context) error {
+		var before Match
+		err := coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}).Decode(&before)
+		ended = err == nil
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return NewErrorf("error ending match: %s", err)
+		}
+
+		after := before
+		after.Status = status
+		after.EndedDate = &now
+		after.EndedBy = endedBy
+		if err := recordChange(ctx, db, "match.end", "match", string(before.ID), &before, &after, nil); err != nil {
+			return err
+		}
+
+		return emitEvent(ctx, db, EventMatchEnded, NewMatchView(&after), now)
+	})
+
+	return ended && err == nil, err
+}
+
+// MatchActivity is how a pair interacted. Empty is any interaction
+type MatchActivity string
+
+// MatchMessage is a message sent to the other user, who's notified of it
+const MatchMessage MatchActivity = "message"
+
+// RecordMatchActivity marks that the pair interacted, e.g. sent a message, which keeps the
+// match from expiring. Returns false if there was no active match. A message notifies the
+// other user, in the same transaction when the database supports them
+func RecordMatchActivity(ctx context.Context, db *DB, userID, otherID string, activity MatchActivity, now time.Time) (bool, error) {
+	coll := db.MongoClient.Collection("matches")
+
+	filter := bson.M{
+		"pair":   pairKey(userID, otherID),
+		"status": MatchActive,
+	}
+
+	found := false
+	err := db.transaction(ctx, func(ctx context.Context) error {
+		var before Match
+		err := coll.FindOneAndUpdate(ctx, filter, bson.M{"$max": bson.M{"lastActivityDate": now}}).Decode(&before)
+		found = err == nil
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return NewErrorf("error updating match %s: %s", filter["pair"], err)
+		}
+
+		after := before
+		if now.After(before.LastActivityDate) {
+			after.LastActivityDate = now
+		}
+		var details bson.M
+		if activity != "" {
+			details = bson.M{"activity": string(activity)}
+		}
+		if err := recordChange(ctx, db, "match.activity", "match", string(before.ID), &before, &after, details); err != nil {
+			return err
+		}
+
+		if activity == MatchMessage {
+			return notify(ctx, db, newNotification(NotifyMessage, otherID, userID, now))
+		}
+		return nil
+	})
+
+	return found && err == nil, err
+}
+
+// ExpireMatches ends every active match with no activity in the last expireAfter. Returns how
+// many expired
+func ExpireMatches(ctx context.Context, db *DB, now time.Time, expireAfter time.Duration) (int, error) {
+	coll := db.MongoClient.Collection("matches")
+
+	cutoff := now.Add(-expireAfter)
+	filter := bson.M{
+		"status":           MatchActive,
+		"lastActivityDate": bson.M{"$lt": cutoff},
+	}
+
+	cur, err := coll.Find(ctx, filter)
+	if err != nil {
+		return 0, NewErrorf("error finding matches to expire: %s", err)
+	}
+
+	stale := make([]*Match, 0)
+	for cur.Next(ctx) {
+		var m Match
+		if err := cur.Decode(&m); err != nil {
+			cur.Close(ctx)
+			return 0, NewErrorf("error decoding into match struct: %s", err)
+		}
+		stale = append(stale, &m)
+	}
+	cur.Close(ctx)
+
+	// ended one at a time so each gets its own audit entry, and a match that saw activity or
+	// was unmatched in the meantime is left alone
+	expired := 0
+	for _, m := range stale {
+		stillStale := bson.M{
+			"_id":              m.ID,
+			"status":           MatchActive,
+			"lastActivityDate": bson.M{"$lt": cutoff},
+		}
+
+		ok, err := endMatch(ctx, db, stillStale, MatchExpired, "", now)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// FindUserMatches returns a user's matches in the given status, newest first
+func FindUserMatches(ctx context.Context, db *DB, userID string, status MatchStatus) ([]*Match, error) {
+	return findMatches(ctx, db, bson.M{"userIds": userID, "status": status})
+}
+
+// findMatches returns the matches matching filter, newest first
+func findMatches(ctx context.Context, db *DB, filter bson.M) ([]*Match, error) {
+	coll := db.MongoClient.Collection("matches")
+
+	cur, err := coll.Find(ctx, filter, options.Find().SetSort(bson.M{"createdDate": -1}))
+	if err != nil {
+		return nil, NewErrorf("error finding matches from mongo: %s", err)
+	}
+
+	defer cur.Close(ctx)
+
+	matches := make([]*Match, 0)
+	for cur.Next(ctx) {
+		var m Match
+		if err := cur.Decode(&m); err != nil {
+			return nil, NewErrorf("error decoding into match struct: %s", err)
+		}
+
+		matches = append(matches, &m)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return matches, nil
+}
+
+// FindMatches gets all the active matches this user has, with the other user filled in
+func FindMatches(ctx context.Context, db *DB, userId string) ([]*UserMatch, error) {
+	matches, err := FindUserMatches(ctx, db, userId, MatchActive)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.OtherUserID(userId))
+	}
+
+	users, err := FindUsersByIDs(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]*UserMatch, 0, len(matches))
+	for _, m := range matches {
+		if u, ok := users[m.OtherUserID(userId)]; ok {
+			views = append(views, &UserMatch{Match: m, User: u})
+		}
+	}
+
+	return views, nil
+}
+
+// FindMatchesOfUsers gets the active matches of many users in one query, by user id
+func FindMatchesOfUsers(ctx context.Context, db *DB, userIDs []string) (map[string][]*Match, error) {
+	matches, err := findMatches(ctx, db, bson.M{"userIds": bson.M{"$in": userIDs}, "status": MatchActive})
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string][]*Match, len(userIDs))
+	for _, m := range matches {
+		for _, id := range m.UserIDs {
+			byUser[id] = append(byUser[id], m)
+		}
+	}
+
+	return byUser, nil
+}
+
+// BackfillMatches creates matches for every pair of users who like each other but don't have
+// a match yet, dated at the later of the two likes. Returns how many were created
+func BackfillMatches(ctx context.Context, db *DB) (int, error) {
+	likes, err := FindRatings(ctx, db, &RatingParams{Types: likeTypes()})
+	if err != nil {
+		return 0, err
+	}
+
+	type pair struct{ from, to string }
+	liked := make(map[pair]time.Time, len(likes))
+	for _, r := range likes {
+		p := pair{r.FromUserID, r.ToUserID}
+		if d, ok := liked[p]; !ok || r.CreatedDate.Before(d) {
+			liked[p] = r.CreatedDate
+		}
+	}
+
+	created := 0
+	for p, date := range liked {
+		// visit each mutual pair once, from the side with the smaller id
+		back, ok := liked[pair{p.to, p.from}]
+		if !ok || p.from > p.to {
+			continue
+		}
+
+		if back.After(date) {
+			date = back
+		}
+
+		ok, err := CreateMatch(ctx, db, p.from, p.to, date)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created++
+		}
+	}
+
+	return created, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_pairKey(t *testing.T) {
+	assert.Equal(t, pairKey("a", "b"), pairKey("b", "a"))
+	assert.Equal(t, "a:b", pairKey("b", "a"))
+}
+
+func TestMatch_OtherUserID(t *testing.T) {
+	m := &Match{UserIDs: []string{"a", "b"}}
+	assert.Equal(t, "b", m.OtherUserID("a"))
+	assert.Equal(t, "a", m.OtherUserID("b"))
+}
+
+func TestMatch_SetExpiresAt(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)}
+	m := &Match{CreatedDate: clock.Now(), LastActivityDate: clock.Now(), Status: MatchActive}
+
+	clock.Advance(6 * time.Hour)
+	m.LastActivityDate = clock.Now()
+
+	m.SetExpiresAt(24 * time.Hour)
+	assert.Equal(t, clock.Now().Add(24*time.Hour), *m.ExpiresAt, "expiry should count from the last activity")
+
+	ended := &Match{CreatedDate: clock.Now(), LastActivityDate: clock.Now(), Status: MatchUnmatched}
+	ended.SetExpiresAt(24 * time.Hour)
+	assert.Nil(t, ended.ExpiresAt, "only active matches expire")
+}
+
+func TestExpireMatches(t *testing.T) {
+	app := initAppContext()
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)}
+	expireAfter := 24 * time.Hour
+
+	quiet, busy, other := string(NewDocID()), string(NewDocID()), string(NewDocID())
+	for _, id := range []string{quiet, busy} {
+		ok, err := CreateMatch(ctx, app.DB, id, other, clock.Now())
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	status := func(id string) MatchStatus {
+		matches, err := findMatches(ctx, app.DB, bson.M{"pair": pairKey(id, other)})
+		assert.Nil(t, err)
+		if !assert.Len(t, matches, 1) {
+			return ""
+		}
+		return matches[0].Status
+	}
+
+	clock.Advance(12 * time.Hour)
+	ok, err := RecordMatchActivity(ctx, app.DB, busy, other, "", clock.Now())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	clock.Advance(12 * time.Hour)
+	_, err = ExpireMatches(ctx, app.DB, clock.Now(), expireAfter)
+	assert.Nil(t, err)
+	assert.Equal(t, MatchActive, status(quiet), "a match expires after the cutoff, not at it")
+
+	clock.Advance(time.Second)
+	_, err = ExpireMatches(ctx, app.DB, clock.Now(), expireAfter)
+	assert.Nil(t, err)
+	assert.Equal(t, MatchExpired, status(quiet))
+	assert.Equal(t, MatchActive, status(busy), "activity should push expiry back")
+
+	clock.Advance(12 * time.Hour)
+	_, err = ExpireMatches(ctx, app.DB, clock.Now(), expireAfter)
+	assert.Nil(t, err)
+	assert.Equal(t, MatchExpired, status(busy))
+}
+
+func TestCreateMatch_once(t *testing.T) {
+	app := initAppContext()
+	ctx := context.Background()
+	now := time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)
+
+	for _, status := range []MatchStatus{MatchUnmatched, MatchExpired} {
+		a, b := string(NewDocID()), string(NewDocID())
+
+		ok, err := CreateMatch(ctx, app.DB, a, b, now)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = EndMatch(ctx, app.DB, a, b, status, "", now)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = CreateMatch(ctx, app.DB, b, a, now.Add(time.Hour))
+		assert.Nil(t, err)
+		assert.False(t, ok, "a pair whose match is %s can't match again", status)
+
+		matches, err := findMatches(ctx, app.DB, bson.M{"pair": pairKey(a, b)})
+		assert.Nil(t, err)
+		if assert.Len(t, matches, 1) {
+			assert.Equal(t, status, matches[0].Status)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a versioned change to the database, applied in order of Version
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *DB) error
+	Down    func(ctx context.Context, db *DB) error
+}
+
+// MigrationStatus tells if a migration has been applied, and when
+type MigrationStatus struct {
+	Migration   *Migration
+	AppliedDate *time.Time
+}
+
+// migrationRecord is stored in the migrations collection for every applied migration
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Name        string    `bson:"name"`
+	AppliedDate time.Time `bson:"appliedDate"`
+}
+
+// migrations is every known migration. New ones go at the end with the next version number
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "unique index on ratings by user pair and type",
+		Up: createIndex("ratings", mongo.IndexModel{
+			Keys:    bson.D{{Key: "fromUserId", Value: 1}, {Key: "toUserId", Value: 1}, {Key: "type", Value: 1}},
+			Options: options.Index().SetName("fromUserId_toUserId_type").SetUnique(true),
+		}),
+		Down: dropIndex("ratings", "fromUserId_toUserId_type"),
+	},
+	{
+		Version: 2,
+		Name:    "index on ratings by recipient and type",
+		Up: createIndex("ratings", mongo.IndexModel{
+			Keys:    bson.D{{Key: "toUserId", Value: 1}, {Key: "type", Value: 1}},
+			Options: options.Index().SetName("toUserId_type"),
+		}),
+		Down: dropIndex("ratings", "toUserId_type"),
+	},
+	{
+		Version: 3,
+		Name:    "expire rate limit buckets",
+		Up: createIndex("rate_limits", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetName("expireAt_ttl").SetExpireAfterSeconds(0),
+		}),
+		Down: dropIndex("rate_limits", "expireAt_ttl"),
+	},
+	{
+		Version: 4,
+		Name:    "expire daily quota counters",
+		Up: createIndex("quotas", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expireAt", Value: 1}},
+			Options: options.Index().SetName("expireAt_ttl").SetExpireAfterSeconds(0),
+		}),
+		Down: dropIndex("quotas", "expireAt_ttl"),
+	},
+	{
+		Version: 5,
+		Name:    "unique index on matches by user pair",
+		Up: createIndex("matches", mongo.IndexModel{
+			Keys:    bson.D{{Key: "pair", Value: 1}},
+			Options: options.Index().SetName("pair").SetUnique(true),
+		}),
+		Down: dropIndex("matches", "pair"),
+	},
+	{
+		Version: 6,
+		Name:    "index on matches by user and status",
+		Up: createIndex("matches", mongo.IndexModel{
+			Keys:    bson.D{{Key: "userIds", Value: 1}, {Key: "status", Value: 1}, {Key: "createdDate", Value: -1}},
+			Options: options.Index().SetName("userIds_status_createdDate"),
+		}),
+		Down: dropIndex("matches", "userIds_status_createdDate"),
+	},
+	{
+		Version: 7,
+		Name:    "backfill matches from existing likes",
+		Up: func(ctx context.Context, db *DB) error {
+			_, err := BackfillMatches(ctx, db)
+			return err
+		},
+		// matches created since can't be told apart from backfilled ones, so they're kept
+		Down: func(ctx context.Context, db *DB) error { return nil },
+	},
+	{
+		Version: 8,
+		Name:    "set last activity on matches and index active matches by it",
+		Up: func(ctx context.Context, db *DB) error {
+			if err := setMatchLastActivity(ctx, db); err != nil {
+				return err
+			}
+			return createIndex("matches", mongo.IndexModel{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "lastActivityDate", Value: 1}},
+				Options: options.Index().SetName("status_lastActivityDate"),
+			})(ctx, db)
+		},
+		Down: dropIndex("matches", "status_lastActivityDate"),
+	},
+	{
+		Version: 9,
+		Name:    "index audit log by target and actor",
+		Up: func(ctx context.Context, db *DB) error {
+			_, err := db.MongoClient.Collection("audit").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "targetId", Value: 1}, {Key: "createdDate", Value: -1}},
+					Options: options.Index().SetName("targetId_createdDate"),
+				},
+				{
+					Keys:    bson.D{{Key: "actorId", Value: 1}, {Key: "createdDate", Value: -1}},
+					Options: options.Index().SetName("actorId_createdDate"),
+				},
+				{
+					Keys:    bson.D{{Key: "requestId", Value: 1}},
+					Options: options.Index().SetName("requestId"),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *DB) error {
+			for _, name := range []string{"targetId_createdDate", "actorId_createdDate", "requestId"} {
+				if err := dropIndex("audit", name)(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 10,
+		Name:    "index users by status and deletion date",
+		Up: createIndex("users", mongo.IndexModel{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "deletedDate", Value: 1}},
+			Options: options.Index().SetName("status_deletedDate"),
+		}),
+		Down: dropIndex("users", "status_deletedDate"),
+	},
+	{
+		Version: 11,
+		Name:    "index content reviews by status",
+		Up: createIndex("reviews", mongo.IndexModel{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "createdDate", Value: 1}},
+			Options: options.Index().SetName("status_createdDate"),
+		}),
+		Down: dropIndex("reviews", "status_createdDate"),
+	},
+	{
+		Version: 12,
+		Name:    "store ids as ObjectIDs",
+		Up:      convertDocIDs(true),
+		Down:    convertDocIDs(false),
+	},
+	{
+		Version: 13,
+		Name:    "create the outbox and webhook deliveries, indexed for the dispatcher",
+		Up: func(ctx context.Context, db *DB) error {
+			if err := createIndex("outbox", mongo.IndexModel{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}},
+				Options: options.Index().SetName("status_id"),
+			})(ctx, db); err != nil {
+				return err
+			}
+
+			_, err := db.MongoClient.Collection("deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "eventId", Value: 1}, {Key: "webhookId", Value: 1}},
+					Options: options.Index().SetName("eventId_webhookId").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptDate", Value: 1}},
+					Options: options.Index().SetName("status_nextAttemptDate"),
+				},
+				{
+					Keys:    bson.D{{Key: "webhookId", Value: 1}, {Key: "_id", Value: -1}},
+					Options: options.Index().SetName("webhookId_id"),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *DB) error {
+			if err := dropIndex("outbox", "status_id")(ctx, db); err != nil {
+				return err
+			}
+			for _, name := range []string{"eventId_webhookId", "status_nextAttemptDate", "webhookId_id"} {
+				if err := dropIndex("deliveries", name)(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 14,
+		Name:    "index notifications by when they're due and by user",
+		Up: func(ctx context.Context, db *DB) error {
+			_, err := db.MongoClient.Collection("notifications").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "status", Value: 1}, {Key: "sendAfter", Value: 1}},
+					Options: options.Index().SetName("status_sendAfter"),
+				},
+				{
+					Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}},
+					Options: options.Index().SetName("userId_status"),
+				},
+				{
+					Keys:    bson.D{{Key: "claimId", Value: 1}},
+					Options: options.Index().SetName("claimId").SetSparse(true),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *DB) error {
+			for _, name := range []string{"status_sendAfter", "userId_status", "claimId"} {
+				if err := dropIndex("notifications", name)(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 15,
+		Name:    "index events by the users they're about, for erasure",
+		Up: createIndex("outbox", mongo.IndexModel{
+			Keys:    bson.D{{Key: "userIds", Value: 1}},
+			Options: options.Index().SetName("userIds").SetSparse(true),
+		}),
+		Down: dropIndex("outbox", "userIds"),
+	},
+}
+
+// MigrationStatuses lists every known migration along with when it was applied
+func MigrationStatuses(ctx context.Context, db *DB) ([]*MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := &MigrationStatus{Migration: m}
+		if r, ok := applied[m.Version]; ok {
+			s.AppliedDate = &r.AppliedDate
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+// checkMigrations returns an error naming the migrations that are pending, if any are
+func checkMigrations(statuses []*MigrationStatus) error {
+	pending := make([]string, 0)
+	for _, s := range statuses {
+		if s.AppliedDate == nil {
+			pending = append(pending, strconv.Itoa(s.Migration.Version))
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+	return fmt.Errorf("migrations %s are pending. run `migrate up` first, or set FEATURE_MIGRATE_ON_STARTUP=true", strings.Join(pending, ", "))
+}
+
+// MigrateUp applies every pending migration in order and returns the ones it ran
+func MigrateUp(ctx context.Context, db *DB) ([]*Migration, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	coll := db.MongoClient.Collection("migrations")
+	ran := make([]*Migration, 0)
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if err := m.Up(ctx, db); err != nil {
+			return ran, NewErrorf("error applying migration %d: %s", m.Version, err)
+		}
+
+		record := &migrationRecord{
+			Version:     m.Version,
+			Name:        m.Name,
+			AppliedDate: time.Now(),
+		}
+		if _, err := coll.InsertOne(ctx, record); err != nil {
+			return ran, NewErrorf("error recording migration %d: %s", m.Version, err)
+		}
+
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// MigrateDown rolls back the last n applied migrations, newest first, and returns the ones it ran
+func MigrateDown(ctx context.Context, db *DB, n int) ([]*Migration, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	coll := db.MongoClient.Collection("migrations")
+	ran := make([]*Migration, 0)
+
+	for i := len(migrations) - 1; i >= 0 && len(ran) < n; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		if err := m.Down(ctx, db); err != nil {
+			return ran, NewErrorf("error rolling back migration %d: %s", m.Version, err)
+		}
+
+		if _, err := coll.DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return ran, NewErrorf("error removing migration record %d: %s", m.Version, err)
+		}
+
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// look up the migrations that have already been applied, keyed by version
+func appliedMigrations(ctx context.Context, db *DB) (map[int]*migrationRecord, error) {
+	coll := db.MongoClient.Collection("migrations")
+
+	cur, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, NewErrorf("error finding migrations from mongo: %s", err)
+	}
+
+	defer cur.Close(ctx)
+
+	applied := make(map[int]*migrationRecord)
+	for cur.Next(ctx) {
+		var r migrationRecord
+		if err := cur.Decode(&r); err != nil {
+			return nil, NewErrorf("error decoding migration record: %s", err)
+		}
+
+		applied[r.Version] = &r
+	}
+
+	return applied, nil
+}
+
+// matches created before they tracked activity count as last active when they were made
+func setMatchLastActivity(ctx context.Context, db *DB) error {
+	coll := db.MongoClient.Collection("matches")
+
+	cur, err := coll.Find(ctx, bson.M{"lastActivityDate": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var m Match
+		if err := cur.Decode(&m); err != nil {
+			return err
+		}
+
+		update := bson.M{"$set": bson.M{"lastActivityDate": m.CreatedDate}}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": m.ID}, update); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// docIDCollections are the collections whose _id is a DocID
+var docIDCollections = []string{"users", "ratings", "matches", "audit", "reviews"}
+
+// convertDocIDs returns a migration step that stores _ids, and the ids of users' photos, as
+// ObjectIDs, or back as strings. Ids that aren't ObjectIDs stay strings. _id can't be changed,
+// so documents are deleted and inserted again with the new one, and put back as they were if
+// that fails
+func convertDocIDs(toObjectID bool) func(context.Context, *DB) error {
+	from := "string"
+	if !toObjectID {
+		from = "objectId"
+	}
+
+	convert := func(id interface{}) (interface{}, bool) {
+		if s, ok := id.(string); ok && toObjectID {
+			oid, err := primitive.ObjectIDFromHex(s)
+			return oid, err == nil
+		}
+		if oid, ok := id.(primitive.ObjectID); ok && !toObjectID {
+			return oid.Hex(), true
+		}
+		return id, false
+	}
+
+	return func(ctx context.Context, db *DB) error {
+		for _, name := range docIDCollections {
+			coll := db.MongoClient.Collection(name)
+
+			filter := bson.M{"$or": []bson.M{
+				{"_id": bson.M{"$type": from}},
+				{"photos._id": bson.M{"$type": from}},
+			}}
+			cur, err := coll.Find(ctx, filter)
+			if err != nil {
+				return err
+			}
+
+			for cur.Next(ctx) {
+				var doc bson.M
+				if err := cur.Decode(&doc); err != nil {
+					cur.Close(ctx)
+					return err
+				}
+
+				photosChanged := false
+				if photos, ok := doc["photos"].(bson.A); ok {
+					for _, p := range photos {
+						if photo, ok := p.(bson.M); ok {
+							id, changed := convert(photo["_id"])
+							photo["_id"] = id
+							photosChanged = photosChanged || changed
+						}
+					}
+				}
+
+				oldID := doc["_id"]
+				newID, idChanged := convert(oldID)
+
+				switch {
+				case idChanged:
+					err = replaceDocID(ctx, coll, doc, newID)
+				case photosChanged:
+					_, err = coll.ReplaceOne(ctx, bson.M{"_id": oldID}, doc)
+				}
+				if err != nil {
+					cur.Close(ctx)
+					return NewErrorf("error converting id %v in %s: %s", oldID, name, err)
+				}
+			}
+
+			err = cur.Err()
+			cur.Close(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// replaceDocID moves a document to a new _id. The old one is deleted first so unique indexes
+// don't get in the way
+func replaceDocID(ctx context.Context, coll *mongo.Collection, doc bson.M, id interface{}) error {
+	oldID := doc["_id"]
+	if _, err := coll.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+		return err
+	}
+
+	doc["_id"] = id
+	if _, err := coll.InsertOne(ctx, doc); err != nil {
+		doc["_id"] = oldID
+		if _, restoreErr := coll.InsertOne(ctx, doc); restoreErr != nil {
+			return NewErrorf("%s, and restoring the document failed: %s", err, restoreErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// createIndex returns a migration step that adds an index to a collection
+func createIndex(collection string, index mongo.IndexModel) func(context.Context, *DB) error {
+	return func(ctx context.Context, db *DB) error {
+		_, err := db.MongoClient.Collection(collection).Indexes().CreateOne(ctx, index)
+		return err
+	}
+}
+
+// dropIndex returns a migration step that removes an index by name
+func dropIndex(collection, name string) func(context.Context, *DB) error {
+	return func(ctx context.Context, db *DB) error {
+		_, err := db.MongoClient.Collection(collection).Indexes().DropOne(ctx, name)
+		return err
+	}
+}
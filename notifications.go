@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationKind is what a notification tells a user about
+type NotificationKind string
+
+const (
+	// NotifyLike is sent as a digest of every like gathered over the digest window
+	NotifyLike    NotificationKind = "like"
+	NotifyMatch   NotificationKind = "match"
+	NotifyMessage NotificationKind = "message"
+	// NotifyWarning tells a user their account was suspended or their content removed. It can't
+	// be muted
+	NotifyWarning NotificationKind = "warning"
+)
+
+// NotificationKinds lists every kind, in the order they're documented
+func NotificationKinds() []NotificationKind {
+	return []NotificationKind{NotifyLike, NotifyMatch, NotifyMessage, NotifyWarning}
+}
+
+// NotificationPreferences are how a user wants to hear about things. Email and push are sent
+// to whichever of the address and token are set, so users without either get nothing
+type NotificationPreferences struct {
+	Email      string             `json:"email,omitempty" bson:"email,omitempty"`
+	Muted      []NotificationKind `json:"muted,omitempty" bson:"muted,omitempty"`
+	PushToken  string             `json:"pushToken,omitempty" bson:"pushToken,omitempty"`
+	QuietHours *QuietHours        `json:"quietHours,omitempty" bson:"quietHours,omitempty"`
+}
+
+// Validate checks the preferences a user sent
+func (p *NotificationPreferences) Validate() error {
+	if p.Email != "" {
+		if a, err := mail.ParseAddress(p.Email); err != nil || a.Address != p.Email {
+			return errors.New("notification email must be a plain email address")
+		}
+	}
+
+	for _, k := range p.Muted {
+		if k == NotifyWarning || !validNotificationKind(k) {
+			return errors.New("muted notifications must be some of like, match, message")
+		}
+	}
+
+	if p.QuietHours != nil {
+		return p.QuietHours.Validate()
+	}
+	return nil
+}
+
+// Wants tells if the user wants notifications of the kind. Users without preferences want all
+func (p *NotificationPreferences) Wants(kind NotificationKind) bool {
+	if p == nil || kind == NotifyWarning {
+		return true
+	}
+	for _, k := range p.Muted {
+		if k == kind {
+			return false
+		}
+	}
+	return true
+}
+
+func validNotificationKind(kind NotificationKind) bool {
+	for _, k := range NotificationKinds() {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// QuietHours is a time of day, HH:MM to HH:MM in the user's time zone, that notifications wait
+// out. Start after End spans midnight
+type QuietHours struct {
+	End   string `json:"end" bson:"end"`
+	Start string `json:"start" bson:"start"`
+	// TimeZone is an IANA name like Europe/Paris, UTC if empty
+	TimeZone string `json:"timeZone,omitempty" bson:"timeZone,omitempty"`
+}
+
+// Validate checks the times and time zone
+func (q *QuietHours) Validate() error {
+	if _, err := time.Parse("15:04", q.Start); err != nil {
+		return errors.New("quiet hours start must be HH:MM")
+	}
+	if _, err := time.Parse("15:04", q.End); err != nil {
+		return errors.New("quiet hours end must be HH:MM")
+	}
+	if _, err := time.LoadLocation(q.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone %q", q.TimeZone)
+	}
+	return nil
+}
+
+// Until returns when the quiet hours now is in end, or the zero time if now isn't in them
+func (q *QuietHours) Until(now time.Time) time.Time {
+	if q == nil || q.Start == q.End {
+		return time.Time{}
+	}
+
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return time.Time{}
+	}
+
+	local := now.In(loc)
+	at := func(hhmm string, days int) time.Time {
+		t, _ := time.Parse("15:04", hhmm)
+		return time.Date(local.Year(), local.Month(), local.Day()+days, t.Hour(), t.Minute(), 0, 0, loc)
+	}
+
+	start, end := at(q.Start, 0), at(q.End, 0)
+	switch {
+	case start.Before(end):
+		if !local.Before(start) && local.Before(end) {
+			return end
+		}
+	case !local.Before(start):
+		return at(q.End, 1)
+	case local.Before(end):
+		return end
+	}
+	return time.Time{}
+}
+
+// NotificationStatus is where a notification is at. Sending ones are claimed by a notifier
+type NotificationStatus string
+
+const (
+	NotificationPending NotificationStatus = "pending"
+	NotificationSending NotificationStatus = "sending"
+	NotificationSent    NotificationStatus = "sent"
+	// NotificationSkipped wasn't sent, because the user muted it, has nowhere to send it or is gone
+	NotificationSkipped NotificationStatus = "skipped"
+	// NotificationFailed gave up after the most attempts
+	NotificationFailed NotificationStatus = "failed"
+)
+
+// Notification is something to tell a user about. It's queued with the change it's about, and
+// sent by the notifier once it's due
+type Notification struct {
+	Attempts    int              `json:"attempts" bson:"attempts"`
+	ClaimID     string           `json:"-" bson:"claimId,omitempty"`
+	CreatedDate time.Time        `json:"createdDate" bson:"createdDate"`
+	ID          DocID            `json:"id" bson:"_id"`
+	Kind        NotificationKind `json:"kind" bson:"kind"`
+	LastError   string           `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	// OtherUserID is who liked, matched or messaged the user
+	OtherUserID string `json:"otherUserId,omitempty" bson:"otherUserId,omitempty"`
+	// Reason is why a warning was given
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+	// SendAfter is when a pending notification is due, or when a notifier's claim on a sending
+	// one runs out
+	SendAfter time.Time          `json:"sendAfter" bson:"sendAfter"`
+	SentDate  *time.Time         `json:"sentDate,omitempty" bson:"sentDate,omitempty"`
+	Status    NotificationStatus `json:"status" bson:"status"`
+	UserID    string             `json:"userId" bson:"userId"`
+}
+
+// newNotification is due at now
+func newNotification(kind NotificationKind, userID, otherUserID string, now time.Time) *Notification {
+	return &Notification{
+		CreatedDate: now,
+		ID:          NewDocID(),
+		Kind:        kind,
+		OtherUserID: otherUserID,
+		SendAfter:   now,
+		Status:      NotificationPending,
+		UserID:      userID,
+	}
+}
+
+// findNotifications finds notifications in the order they were queued
+func findNotifications(ctx context.Context, db *DB, filter bson.M) ([]*Notification, error) {
+	opts := options.Find().SetSort(bson.M{"createdDate": 1})
+	cur, err := db.MongoClient.Collection("notifications").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, NewErrorf("error finding notifications from mongo: %s", err)
+	}
+	defer cur.Close(ctx)
+
+	notifications := make([]*Notification, 0)
+	for cur.Next(ctx) {
+		var n Notification
+		if err := cur.Decode(&n); err != nil {
+			return nil, NewErrorf("error decoding into notification struct: %s", err)
+		}
+		notifications = append(notifications, &n)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return notifications, nil
+}
+
+// ratingNotifications are what a new rating tells users: both of them about the match it made,
+// or the rated user that they're liked
+func ratingNotifications(r *Rating, info *RatingTypeInfo, matched bool, now time.Time) []*Notification {
+	switch {
+	case matched:
+		return []*Notification{
+			newNotification(NotifyMatch, r.FromUserID, r.ToUserID, now),
+			newNotification(NotifyMatch, r.ToUserID, r.FromUserID, now),
+		}
+	case info.CountsAsLike:
+		return []*Notification{newNotification(NotifyLike, r.ToUserID, r.FromUserID, now)}
+	}
+	return nil
+}
+
+// notify queues notifications. Called within the transaction of the change, they're only
+// queued if the change is
+func notify(ctx context.Context, db *DB, notifications ...*Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(notifications))
+	for _, n := range notifications {
+		docs = append(docs, n)
+	}
+
+	if err := insertMany(ctx, db.MongoClient.Collection("notifications"), docs); err != nil {
+		return NewErrorf("error queueing %d notifications: %s", len(notifications), err)
+	}
+	return nil
+}
+
+// notificationTemplate renders the subject and body of a kind of notification. Push
+// notifications show the subject as their title
+type notificationTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// NotificationData fills in the templates
+type NotificationData struct {
+	// Count is how many likes a digest has
+	Count int
+	// Name is the user's, Other the one who matched or messaged them
+	Name   string
+	Other  string
+	Reason string
+}
+
+var notificationTemplates = map[NotificationKind]*notificationTemplate{
+	NotifyLike: newNotificationTemplate(
+		`{{if eq .Count 1}}Someone likes you{{else}}{{.Count}} people like you{{end}}`,
+		`Hi {{.Name}}, {{if eq .Count 1}}someone{{else}}{{.Count}} people{{end}} liked your profile. Like them back to match.`,
+	),
+	NotifyMatch: newNotificationTemplate(
+		`You matched with {{.Other}}`,
+		`Hi {{.Name}}, you and {{.Other}} like each other. Say hello before the match expires.`,
+	),
+	NotifyMessage: newNotificationTemplate(
+		`New message from {{.Other}}`,
+		`Hi {{.Name}}, {{.Other}} sent you a message.`,
+	),
+	NotifyWarning: newNotificationTemplate(
+		`About your account`,
+		`Hi {{.Name}}, a moderator reviewed your account: {{.Reason}}. Repeated breaches of the community guidelines can close your account.`,
+	),
+}
+
+func newNotificationTemplate(subject, body string) *notificationTemplate {
+	return &notificationTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+// renderNotification fills in the template of the kind
+func renderNotification(kind NotificationKind, data *NotificationData) (string, string, error) {
+	t, ok := notificationTemplates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("no template for %s notifications", kind)
+	}
+
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return subject.String(), body.String(), nil
+}
+
+// notificationGroup is notifications sent as one message: a digest of likes, or any other one
+type notificationGroup struct {
+	kind          NotificationKind
+	notifications []*Notification
+}
+
+// groupNotifications puts a user's likes together, oldest first, and every other notification
+// in its own group
+func groupNotifications(notifications []*Notification) []*notificationGroup {
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].CreatedDate.Before(notifications[j].CreatedDate)
+	})
+
+	groups := make([]*notificationGroup, 0)
+	var likes *notificationGroup
+	for _, n := range notifications {
+		if n.Kind != NotifyLike {
+			groups = append(groups, &notificationGroup{kind: n.Kind, notifications: []*Notification{n}})
+			continue
+		}
+		if likes == nil {
+			likes = &notificationGroup{kind: NotifyLike}
+			groups = append(groups, likes)
+		}
+		likes.notifications = append(likes.notifications, n)
+	}
+	return groups
+}
+
+// holdUntil tells when a group can be sent, or the zero time if now. A digest waits until the
+// window from its first like is over, and everything waits out the user's quiet hours
+func (g *notificationGroup) holdUntil(prefs *NotificationPreferences, digest time.Duration, now time.Time) time.Time {
+	var until time.Time
+	if g.kind == NotifyLike {
+		if ready := g.notifications[0].CreatedDate.Add(digest); ready.After(now) {
+			until = ready
+		}
+	}
+
+	if prefs != nil {
+		at := now
+		if !until.IsZero() {
+			at = until
+		}
+		if quiet := prefs.QuietHours.Until(at); !quiet.IsZero() {
+			until = quiet
+		}
+	}
+	return until
+}
+
+// NotificationMessage is a rendered notification, ready for a transport
+type NotificationMessage struct {
+	Body    string
+	Kind    NotificationKind
+	Subject string
+	// To is the email address or push token
+	To     string
+	UserID string
+}
+
+// NotificationTransport sends messages over one channel, like email or push
+type NotificationTransport interface {
+	Send(ctx context.Context, m *NotificationMessage) error
+}
+
+// Notifier sends the notifications that are due over the transports of the channels. A nil
+// transport turns its channel off. Any number of instances can run at once: each claims a
+// user's notifications before sending them
+type Notifier struct {
+	// Clock is read for each user, a run can take a while
+	Clock  Clock
+	Config *NotificationsConfig
+	DB     *DB
+	Email  NotificationTransport
+	Push   NotificationTransport
+}
+
+// NewNotifier is a constructor for a Notifier with the configured transports
+func NewNotifier(db *DB, cfg *NotificationsConfig, clock Clock) *Notifier {
+	n := &Notifier{Clock: clock, Config: cfg, DB: db}
+
+	switch cfg.EmailTransport {
+	case "smtp":
+		n.Email = NewSMTPTransport(&cfg.SMTP)
+	case "log":
+		n.Email = NewLogTransport("email")
+	}
+
+	switch cfg.PushTransport {
+	case "stub":
+		n.Push = NewStubPushTransport()
+	case "log":
+		n.Push = NewLogTransport("push")
+	}
+
+	return n
+}
+
+// dueNotifications are pending ones that are due, and sending ones whose claim ran out
+func dueNotifications(now time.Time) bson.M {
+	return bson.M{
+		"status":    bson.M{"$in": []NotificationStatus{NotificationPending, NotificationSending}},
+		"sendAfter": bson.M{"$lte": now},
+	}
+}
+
+// Run sends the notifications that are due, a user at a time, until none are left. Each user's
+// are claimed and sent as of the time they're reached, not when the run started
+func (n *Notifier) Run(ctx context.Context, _ time.Time) error {
+	coll := n.DB.MongoClient.Collection("notifications")
+
+	for ctx.Err() == nil {
+		now := n.Clock.Now()
+
+		var next Notification
+		err := coll.FindOne(ctx, dueNotifications(now), options.FindOne().SetSort(bson.M{"sendAfter": 1})).Decode(&next)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return NewErrorf("error finding due notifications: %s", err)
+		}
+
+		claimed, err := n.claim(ctx, next.UserID, now)
+		if err != nil {
+			return err
+		}
+		if len(claimed) == 0 {
+			continue
+		}
+
+		if err := n.notifyUser(ctx, next.UserID, claimed, now); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// claim takes every due notification of a user, pushing them back until they should have been
+// sent, so other notifiers leave them alone. Pending likes come along even if they were held,
+// to join the digest
+func (n *Notifier) claim(ctx context.Context, userID string, now time.Time) ([]*Notification, error) {
+	coll := n.DB.MongoClient.Collection("notifications")
+	claimID := string(NewDocID())
+
+	filter := dueNotifications(now)
+	filter["userId"] = userID
+	filter = bson.M{"$or": []bson.M{
+		filter,
+		{"userId": userID, "kind": NotifyLike, "status": NotificationPending},
+	}}
+
+	update := bson.M{"$set": bson.M{
+		"claimId":   claimID,
+		"sendAfter": now.Add(2 * n.Config.Timeout),
+		"status":    NotificationSending,
+	}}
+	if _, err := coll.UpdateMany(ctx, filter, update); err != nil {
+		return nil, NewErrorf("error claiming notifications of user %s: %s", userID, err)
+	}
+
+	cur, err := coll.Find(ctx, bson.M{"claimId": claimID})
+	if err != nil {
+		return nil, NewErrorf("error finding notifications from mongo: %s", err)
+	}
+	defer cur.Close(ctx)
+
+	notifications := make([]*Notification, 0)
+	if err := cur.All(ctx, &notifications); err != nil {
+		return nil, NewErrorf("error decoding into notification struct: %s", err)
+	}
+	return notifications, nil
+}
+
+// notifyUser sends a user's claimed notifications, or holds, skips or retries them
+func (n *Notifier) notifyUser(ctx context.Context, userID string, claimed []*Notification, now time.Time) error {
+	user, err := FindAccount(ctx, n.DB, userID)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string)
+	for _, g := range groupNotifications(claimed) {
+		var set bson.M
+		switch {
+		case user == nil || user.Status == UserDeleted || user.Status == UserDeactivated:
+			set = bson.M{"status": NotificationSkipped, "lastError": "account closed"}
+		case !user.Notifications.Wants(g.kind):
+			set = bson.M{"status": NotificationSkipped, "lastError": "muted"}
+		default:
+			if until := g.holdUntil(user.Notifications, n.Config.LikeDigest, now); !until.IsZero() {
+				set = bson.M{"status": NotificationPending, "sendAfter": until}
+				break
+			}
+
+			data, err := n.data(ctx, user, g, names)
+			if err != nil {
+				return err
+			}
+			set = n.outcome(g, n.Send(ctx, user, g.kind, data), now)
+		}
+
+		if err := n.record(ctx, g, set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// data fills in a group's template, looking up the names of the other users once
+func (n *Notifier) data(ctx context.Context, user *User, g *notificationGroup, names map[string]string) (*NotificationData, error) {
+	data := &NotificationData{Count: len(g.notifications), Name: user.Name, Reason: g.notifications[0].Reason}
+
+	otherID := g.notifications[0].OtherUserID
+	if g.kind == NotifyLike || otherID == "" {
+		return data, nil
+	}
+
+	if _, ok := names[otherID]; !ok {
+		other, err := FindAccount(ctx, n.DB, otherID)
+		if err != nil {
+			return nil, err
+		}
+		names[otherID] = "someone"
+		if other != nil && other.Name != "" {
+			names[otherID] = other.Name
+		}
+	}
+	data.Other = names[otherID]
+	return data, nil
+}
+
+// Send renders a notification and sends it on every channel the user has an address for.
+// Returns ErrNowhereToNotify if there was none
+func (n *Notifier) Send(ctx context.Context, user *User, kind NotificationKind, data *NotificationData) error {
+	subject, body, err := renderNotification(kind, data)
+	if err != nil {
+		return err
+	}
+
+	prefs := user.Notifications
+	if prefs == nil {
+		prefs = &NotificationPreferences{}
+	}
+
+	sent := false
+	errs := make([]string, 0)
+	for _, ch := range []struct {
+		name      string
+		to        string
+		transport NotificationTransport
+	}{{"email", prefs.Email, n.Email}, {"push", prefs.PushToken, n.Push}} {
+		if ch.to == "" || ch.transport == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, n.Config.Timeout)
+		err := ch.transport.Send(ctx, &NotificationMessage{Body: body, Kind: kind, Subject: subject, To: ch.to, UserID: string(user.ID)})
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", ch.name, err))
+			continue
+		}
+		sent = true
+	}
+
+	switch {
+	case len(errs) > 0:
+		return errors.New(strings.Join(errs, "; "))
+	case !sent:
+		return ErrNowhereToNotify
+	}
+	return nil
+}
+
+// ErrNowhereToNotify is returned by Send for users without an email or push token, or whose
+// channels are turned off
+var ErrNowhereToNotify = errors.New("no email or push token to notify")
+
+// outcome is what becomes of a group after trying to send it. A failed send is retried on
+// every channel, until the last attempt
+func (n *Notifier) outcome(g *notificationGroup, sendErr error, now time.Time) bson.M {
+	attempts := 0
+	for _, no := range g.notifications {
+		if no.Attempts > attempts {
+			attempts = no.Attempts
+		}
+	}
+	attempts++
+
+	switch {
+	case sendErr == nil:
+		return bson.M{"attempts": attempts, "sentDate": now, "status": NotificationSent}
+	case sendErr == ErrNowhereToNotify:
+		return bson.M{"status": NotificationSkipped, "lastError": sendErr.Error()}
+	case attempts >= n.Config.MaxAttempts:
+		logf(levelWarn, "gave up sending %s notification to user %s: %s", g.kind, g.notifications[0].UserID, sendErr)
+		return bson.M{"attempts": attempts, "lastError": sendErr.Error(), "status": NotificationFailed}
+	}
+
+	return bson.M{
+		"attempts":  attempts,
+		"lastError": sendErr.Error(),
+		"sendAfter": now.Add(backoff(n.Config.Interval, time.Hour, attempts)),
+		"status":    NotificationPending,
+	}
+}
+
+// record saves what became of a group, releasing the claim on it
+func (n *Notifier) record(ctx context.Context, g *notificationGroup, set bson.M) error {
+	ids := make([]DocID, 0, len(g.notifications))
+	for _, no := range g.notifications {
+		ids = append(ids, no.ID)
+	}
+
+	_, err := n.DB.MongoClient.Collection("notifications").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": set, "$unset": bson.M{"claimId": ""}},
+	)
+	if err != nil {
+		return NewErrorf("error recording %s notifications: %s", g.kind, err)
+	}
+	return nil
+}
+
+// notificationJob sends notifications on a schedule
+func notificationJob(n *Notifier) func(context.Context, time.Time) error {
+	return n.Run
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestQuietHours_Until(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	at := func(hour, min int) time.Time { return time.Date(2020, 6, 1, hour, min, 0, 0, paris) }
+
+	overnight := &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Europe/Paris"}
+	assert.Equal(t, at(31, 0).Unix(), overnight.Until(at(23, 30)).Unix(), "before midnight waits until the next morning")
+	assert.Equal(t, at(7, 0).Unix(), overnight.Until(at(6, 59)).Unix())
+	assert.True(t, overnight.Until(at(7, 0)).IsZero())
+	assert.True(t, overnight.Until(at(12, 0)).IsZero())
+
+	// times are in the user's zone, whatever zone now is in
+	assert.Equal(t, at(7, 0).Unix(), overnight.Until(at(3, 0).UTC()).Unix())
+
+	lunch := &QuietHours{Start: "12:00", End: "13:30"}
+	noon := time.Date(2020, 6, 1, 12, 15, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 6, 1, 13, 30, 0, 0, time.UTC).Unix(), lunch.Until(noon).Unix())
+	assert.True(t, lunch.Until(noon.Add(2*time.Hour)).IsZero())
+
+	assert.True(t, (&QuietHours{Start: "10:00", End: "10:00"}).Until(noon).IsZero())
+	assert.True(t, (*QuietHours)(nil).Until(noon).IsZero())
+}
+
+func TestNotificationPreferences_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefs   *NotificationPreferences
+		wantErr string
+	}{
+		{"empty", &NotificationPreferences{}, ""},
+		{"everything", &NotificationPreferences{Email: "ann@example.com", Muted: []NotificationKind{NotifyLike}, PushToken: "t", QuietHours: &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Asia/Tokyo"}}, ""},
+		{"bad email", &NotificationPreferences{Email: "ann"}, "notification email must be a plain email address"},
+		{"named email", &NotificationPreferences{Email: "Ann <ann@example.com>"}, "notification email must be a plain email address"},
+		{"muted warnings", &NotificationPreferences{Muted: []NotificationKind{NotifyWarning}}, "muted notifications must be some of like, match, message"},
+		{"unknown kind", &NotificationPreferences{Muted: []NotificationKind{"poke"}}, "muted notifications must be some of like, match, message"},
+		{"bad quiet hours", &NotificationPreferences{QuietHours: &QuietHours{Start: "10pm", End: "07:00"}}, "quiet hours start must be HH:MM"},
+		{"bad time zone", &NotificationPreferences{QuietHours: &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus"}}, `unknown time zone "Mars/Olympus"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.prefs.Validate()
+			if tt.wantErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	prefs := &NotificationPreferences{Muted: []NotificationKind{NotifyLike}}
+	assert.False(t, prefs.Wants(NotifyLike))
+	assert.True(t, prefs.Wants(NotifyMatch))
+	assert.True(t, (*NotificationPreferences)(nil).Wants(NotifyLike))
+}
+
+func TestRenderNotification(t *testing.T) {
+	subject, body, err := renderNotification(NotifyLike, &NotificationData{Count: 1, Name: "Ann"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Someone likes you", subject)
+	assert.Equal(t, "Hi Ann, someone liked your profile. Like them back to match.", body)
+
+	subject, _, err = renderNotification(NotifyLike, &NotificationData{Count: 3, Name: "Ann"})
+	assert.Nil(t, err)
+	assert.Equal(t, "3 people like you", subject)
+
+	subject, _, err = renderNotification(NotifyMatch, &NotificationData{Name: "Ann", Other: "Bob"})
+	assert.Nil(t, err)
+	assert.Equal(t, "You matched with Bob", subject)
+
+	for _, kind := range NotificationKinds() {
+		_, ok := notificationTemplates[kind]
+		assert.True(t, ok, "no template for %s", kind)
+	}
+}
+
+func TestRatingNotifications(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	like, _ := LookupRatingType(SUPERLIKE)
+	pass, _ := LookupRatingType(PASS)
+	r := &Rating{FromUserID: "a", ToUserID: "b", Type: SUPERLIKE}
+
+	ns := ratingNotifications(r, like, false, now)
+	assert.Len(t, ns, 1)
+	assert.Equal(t, NotifyLike, ns[0].Kind)
+	assert.Equal(t, "b", ns[0].UserID)
+	assert.Equal(t, "a", ns[0].OtherUserID)
+
+	// a match tells both users, instead of the like
+	ns = ratingNotifications(r, like, true, now)
+	assert.Len(t, ns, 2)
+	assert.Equal(t, []string{"a", "b"}, []string{ns[0].UserID, ns[1].UserID})
+	assert.Equal(t, NotifyMatch, ns[1].Kind)
+
+	assert.Empty(t, ratingNotifications(&Rating{FromUserID: "a", ToUserID: "b", Type: PASS}, pass, false, now))
+}
+
+func TestNotificationGroup_holdUntil(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	like := func(ago time.Duration) *Notification { return newNotification(NotifyLike, "a", "b", now.Add(-ago)) }
+
+	groups := groupNotifications([]*Notification{
+		like(10 * time.Minute),
+		newNotification(NotifyMatch, "a", "c", now),
+		like(50 * time.Minute),
+		newNotification(NotifyMessage, "a", "c", now),
+	})
+	assert.Len(t, groups, 3)
+	assert.Equal(t, NotifyLike, groups[0].kind)
+	assert.Len(t, groups[0].notifications, 2)
+	assert.Equal(t, now.Add(-50*time.Minute), groups[0].notifications[0].CreatedDate, "likes are oldest first")
+
+	// likes wait for the digest window from the first one, the rest go now
+	assert.Equal(t, now.Add(10*time.Minute), groups[0].holdUntil(nil, time.Hour, now))
+	assert.True(t, groups[0].holdUntil(nil, 30*time.Minute, now).IsZero())
+	assert.True(t, groups[1].holdUntil(nil, time.Hour, now).IsZero())
+
+	// and everything waits out quiet hours, even once the digest is ready
+	prefs := &NotificationPreferences{QuietHours: &QuietHours{Start: "12:05", End: "13:00"}}
+	assert.True(t, groups[1].holdUntil(prefs, time.Hour, now).IsZero())
+	assert.Equal(t, now.Add(time.Hour), groups[0].holdUntil(prefs, time.Hour, now))
+}
+
+// recordingTransport keeps what it's sent, or fails
+type recordingTransport struct {
+	err  error
+	sent []*NotificationMessage
+}
+
+func (t *recordingTransport) Send(_ context.Context, m *NotificationMessage) error {
+	if t.err != nil {
+		return t.err
+	}
+	t.sent = append(t.sent, m)
+	return nil
+}
+
+func TestNotifier_Send(t *testing.T) {
+	email := &recordingTransport{}
+	push := NewStubPushTransport()
+	n := &Notifier{Config: &DefaultConfig().Notifications, Email: email, Push: push}
+	ctx := context.Background()
+
+	user := &User{ID: "a", Name: "Ann", Notifications: &NotificationPreferences{Email: "ann@example.com", PushToken: "device-1"}}
+	assert.Nil(t, n.Send(ctx, user, NotifyMessage, &NotificationData{Name: "Ann", Other: "Bob"}))
+
+	want := &NotificationMessage{Body: "Hi Ann, Bob sent you a message.", Kind: NotifyMessage, Subject: "New message from Bob", To: "ann@example.com", UserID: "a"}
+	assert.Equal(t, []*NotificationMessage{want}, email.sent)
+	assert.Len(t, push.Sent(), 1)
+	assert.Equal(t, "device-1", push.Sent()[0].To)
+
+	// channels without an address, or turned off, are left out
+	assert.Equal(t, ErrNowhereToNotify, n.Send(ctx, &User{ID: "b"}, NotifyMatch, &NotificationData{}))
+	n.Push = nil
+	assert.Equal(t, ErrNowhereToNotify, n.Send(ctx, &User{ID: "c", Notifications: &NotificationPreferences{PushToken: "t"}}, NotifyMatch, &NotificationData{}))
+
+	email.err = errors.New("mailbox full")
+	assert.EqualError(t, n.Send(ctx, user, NotifyMatch, &NotificationData{}), "email: mailbox full")
+}
+
+func TestNotifier_outcome(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cfg := &NotificationsConfig{Interval: time.Minute, MaxAttempts: 3}
+	n := &Notifier{Config: cfg}
+	g := &notificationGroup{kind: NotifyMatch, notifications: []*Notification{{Attempts: 1, UserID: "a"}}}
+
+	assert.Equal(t, bson.M{"attempts": 2, "sentDate": now, "status": NotificationSent}, n.outcome(g, nil, now))
+	assert.Equal(t, bson.M{"lastError": ErrNowhereToNotify.Error(), "status": NotificationSkipped}, n.outcome(g, ErrNowhereToNotify, now))
+	assert.Equal(t, bson.M{
+		"attempts":  2,
+		"lastError": "timeout",
+		"sendAfter": now.Add(2 * time.Minute),
+		"status":    NotificationPending,
+	}, n.outcome(g, errors.New("timeout"), now))
+
+	g.notifications[0].Attempts = 2
+	assert.Equal(t, bson.M{"attempts": 3, "lastError": "timeout", "status": NotificationFailed}, n.outcome(g, errors.New("timeout"), now))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// openAPISpec documents every route. It's kept in yaml to be easier to edit, and the route
+// coverage test fails if it falls behind the router
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// swaggerUIPage renders the spec with Swagger UI from a CDN, so there's nothing to vendor
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>backend-homework api</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// setupDocsRoutes serves the spec as json at /openapi.json and browsable at /docs
+func setupDocsRoutes(r *gin.Engine) {
+	spec, err := yaml.YAMLToJSON(openAPISpec)
+	if err != nil {
+		log.Fatalf("error converting openapi spec to json: %s", err)
+	}
+
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+	r.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+type openAPISchema struct {
+	AllOf      []*openAPISchema          `json:"allOf"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+}
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPIDoc(t *testing.T) *openAPIDoc {
+	b, err := yaml.YAMLToJSON(openAPISpec)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var doc openAPIDoc
+	if !assert.Nil(t, json.Unmarshal(b, &doc)) {
+		t.FailNow()
+	}
+	return &doc
+}
+
+// gin's /users/:id and /blobs/*key are /users/{id} and /blobs/{key} in the spec
+var ginParam = regexp.MustCompile(`/[:*](\w+)`)
+
+func TestOpenAPI_routes(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+	cfg := DefaultConfig()
+	cfg.Features.Admin = true
+	cfg.Admin.ProxyToken = "s3cret"
+	router := setupRouter(&appContext{Config: cfg})
+
+	routed := make(map[string]bool)
+	for _, route := range router.Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "/{$1}")
+		routed[strings.ToLower(route.Method)+" "+path] = true
+	}
+
+	for route := range routed {
+		// the deprecated unversioned aliases are documented once, with /v1
+		if routed[strings.Replace(route, " ", " /v1", 1)] {
+			continue
+		}
+
+		parts := strings.SplitN(route, " ", 2)
+		_, ok := doc.Paths[parts[1]][parts[0]]
+		assert.True(t, ok, "%s %s is not documented in openapi.yaml", strings.ToUpper(parts[0]), parts[1])
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			assert.True(t, routed[method+" "+path], "%s %s is documented but not routed", strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestOpenAPI_schemas(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+
+	// the request and response types of /v1, by schema name
+	types := map[string]interface{}{
+		"Account":                 AccountView{},
+		"AdminUserUpdate":         AdminUserUpdate{},
+		"AuditEntry":              AuditEntry{},
+		"CacheStats":              CacheStats{},
+		"ContentReview":           ContentReview{},
+		"ErasureResult":           ErasureResult{},
+		"Event":                   EventView{},
+		"Export":                  ExportView{},
+		"FieldChange":             FieldChange{},
+		"Match":                   MatchView{},
+		"Notification":            Notification{},
+		"NotificationPreferences": NotificationPreferences{},
+		"QuietHours":              QuietHours{},
+		"Photo":                   PhotoView{},
+		"Profile":                 ProfileView{},
+		"Rating":                  RatingView{},
+		"RatingRequest":           RatingRequest{},
+		"UserUpdate":              UserUpdate{},
+	}
+
+	for name, v := range types {
+		t.Run(name, func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[name]
+			if !assert.True(t, ok, "no %s schema", name) {
+				return
+			}
+
+			documented := make(map[string]string)
+			schemaFields(doc, schema, documented)
+
+			want := make(map[string]string)
+			jsonFields(reflect.TypeOf(v), want)
+
+			assert.Equal(t, sortedKeys(want), sortedKeys(documented), "fields of %s", name)
+			for field, typ := range want {
+				if got, ok := documented[field]; ok && got != "" {
+					assert.Equal(t, typ, got, "type of %s.%s", name, field)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAPI_served(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features.RateLimiting = false
+	router := setupRouter(&appContext{Config: cfg})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	var m map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, "3.0.3", m["openapi"])
+}
+
+// schemaFields collects the properties of a schema and the schemas it's made of, with their
+// openapi type. References count as objects, unless they point at an enum
+func schemaFields(doc *openAPIDoc, s *openAPISchema, fields map[string]string) {
+	for _, part := range s.AllOf {
+		schemaFields(doc, resolveSchema(doc, part), fields)
+	}
+	for name, p := range s.Properties {
+		fields[name] = resolveSchema(doc, p).Type
+	}
+}
+
+func resolveSchema(doc *openAPIDoc, s *openAPISchema) *openAPISchema {
+	if s.Ref == "" {
+		return s
+	}
+	resolved := doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	if resolved.Type == "" {
+		return &openAPISchema{Type: "object"}
+	}
+	return resolved
+}
+
+// jsonFields collects the json field names of a struct with the openapi type they encode as.
+// Embedded structs are flattened like encoding/json does
+func jsonFields(t reflect.Type, fields map[string]string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			jsonFields(f.Type, fields)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = openAPIType(f.Type)
+	}
+}
+
+func openAPIType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "string"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Interface:
+		return ""
+	}
+	return "object"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
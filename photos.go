@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Photo is a picture on a user's profile. The first of a user's photos is their primary one
+type Photo struct {
+	ContentType  string    `json:"contentType,omitempty" bson:"contentType,omitempty"`
+	CreatedDate  time.Time `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
+	Height       int       `json:"height,omitempty" bson:"height,omitempty"`
+	ID           DocID     `json:"_id,omitempty" bson:"_id,omitempty"`
+	Key          string    `json:"-" bson:"key,omitempty"`
+	Size         int       `json:"size,omitempty" bson:"size,omitempty"`
+	ThumbnailKey string    `json:"-" bson:"thumbnailKey,omitempty"`
+	ThumbnailURL string    `json:"thumbnailUrl,omitempty" bson:"thumbnailUrl,omitempty"`
+	URL          string    `json:"url,omitempty" bson:"url,omitempty"`
+	Width        int       `json:"width,omitempty" bson:"width,omitempty"`
+}
+
+// photoPrefix is where all of a user's photo blobs are kept
+func photoPrefix(userID string) string {
+	return "photos/" + userID + "/"
+}
+
+// AddPhoto appends a photo to the user's profile unless they already have max. Returns false
+// if the user doesn't exist or has no room
+func AddPhoto(ctx context.Context, db *DB, userID string, p *Photo, max int) (bool, error) {
+	coll := db.MongoClient.Collection("users")
+
+	// the photo at index max - 1 only exists once the user is full
+	filter := bson.M{
+		"_id":                           DocID(userID),
+		fmt.Sprintf("photos.%d", max-1): bson.M{"$exists": false},
+	}
+
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"photos": p}})
+	if err != nil {
+		return false, NewErrorf("error adding photo to user %s: %s", userID, err)
+	}
+
+	if res.ModifiedCount == 0 {
+		return false, nil
+	}
+	db.forgetUsers(ctx, userID)
+
+	return true, recordChange(ctx, db, "user.photos.add", "user", userID, nil, p, nil)
+}
+
+// RemovePhoto takes a photo off the user's profile and returns it, or nil if they don't have it
+func RemovePhoto(ctx context.Context, db *DB, userID, photoID string) (*Photo, error) {
+	coll := db.MongoClient.Collection("users")
+
+	filter := bson.M{"_id": DocID(userID), "photos._id": DocID(photoID)}
+	update := bson.M{"$pull": bson.M{"photos": bson.M{"_id": DocID(photoID)}}}
+
+	var before User
+	err := coll.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, NewErrorf("error removing photo %s from user %s: %s", photoID, userID, err)
+	}
+	db.forgetUsers(ctx, userID)
+
+	for _, p := range before.Photos {
+		if string(p.ID) == photoID {
+			return p, recordChange(ctx, db, "user.photos.remove", "user", userID, p, nil, nil)
+		}
+	}
+
+	return nil, nil
+}
+
+// PhotoOrderError is returned when a new photo order isn't a reordering of the user's photos
+type PhotoOrderError struct {
+	msg string
+}
+
+func (e *PhotoOrderError) Error() string {
+	return e.msg
+}
+
+// ReorderPhotos puts the user's photos in the order of ids, which must list each of them once.
+// Returns the updated user, or nil if there is no such user
+func ReorderPhotos(ctx context.Context, db *DB, userID string, ids []string) (*User, error) {
+	u, err := FindAccount(ctx, db, userID)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	ordered, err := orderPhotos(u.Photos, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// only apply the order to the photos it was made for
+	current := make([]string, 0, len(u.Photos))
+	for _, p := range u.Photos {
+		current = append(current, string(p.ID))
+	}
+	filter := bson.M{"_id": DocID(userID), "photos._id": bson.M{"$all": docIDs(current)}, "photos": bson.M{"$size": len(current)}}
+
+	res, err := db.MongoClient.Collection("users").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"photos": ordered}})
+	if err != nil {
+		return nil, NewErrorf("error reordering photos of user %s: %s", userID, err)
+	}
+	if res.MatchedCount == 0 {
+		return nil, &PhotoOrderError{"photos changed while reordering, try again"}
+	}
+	db.forgetUsers(ctx, userID)
+
+	details := bson.M{"before": current, "after": ids}
+	if err := recordChange(ctx, db, "user.photos.reorder", "user", userID, nil, nil, details); err != nil {
+		return nil, err
+	}
+
+	u.Photos = ordered
+	return u, nil
+}
+
+// orderPhotos sorts photos in the order of ids
+func orderPhotos(photos []*Photo, ids []string) ([]*Photo, error) {
+	if len(ids) != len(photos) {
+		return nil, &PhotoOrderError{fmt.Sprintf("order must list all %d photos", len(photos))}
+	}
+
+	byID := make(map[string]*Photo, len(photos))
+	for _, p := range photos {
+		byID[string(p.ID)] = p
+	}
+
+	ordered := make([]*Photo, 0, len(photos))
+	for _, id := range ids {
+		p, ok := byID[id]
+		if !ok {
+			return nil, &PhotoOrderError{fmt.Sprintf("unknown or repeated photo %s", id)}
+		}
+		delete(byID, id)
+		ordered = append(ordered, p)
+	}
+
+	return ordered, nil
+}
+
+// setupPhotoRoutes adds the photo endpoints
+func setupPhotoRoutes(r gin.IRouter, app *appContext) {
+	r.POST("/users/:id/photos", app.uploadPhotos)
+	r.PUT("/users/:id/photos", app.reorderPhotos)
+	r.POST("/users/:id/photos/:photoId/primary", app.setPrimaryPhoto)
+	r.DELETE("/users/:id/photos/:photoId", app.deletePhoto)
+}
+
+// upload one or more photos as multipart "photos" files
+func (app *appContext) uploadPhotos(c *gin.Context) {
+	id := c.Param("id")
+	cfg := app.Config.Photos
+	ctx := c.Request.Context()
+
+	u, err := FindAccount(ctx, app.DB, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	if u == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	if !app.requireUsableAccount(c, id) {
+		return
+	}
+
+	// room for a full set of photos plus the multipart overhead
+	limit := int64(cfg.MaxBytes)*int64(cfg.MaxPerUser) + 1<<20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid multipart body, at most %d bytes: %s", limit, err))
+		return
+	}
+
+	files := form.File["photos"]
+	if len(files) == 0 {
+		errorResponse(c, http.StatusBadRequest, errors.New(`expected one or more "photos" files`))
+		return
+	}
+	if len(u.Photos)+len(files) > cfg.MaxPerUser {
+		errorResponse(c, http.StatusConflict, NewErrorf("users can have at most %d photos", cfg.MaxPerUser))
+		return
+	}
+
+	// check every file before storing any of them
+	images := make([]*processedImage, 0, len(files))
+	for _, fh := range files {
+		if fh.Size > int64(cfg.MaxBytes) {
+			errorResponse(c, http.StatusRequestEntityTooLarge, NewErrorf("%s is larger than %d bytes", fh.Filename, cfg.MaxBytes))
+			return
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, NewErrorf("error reading %s: %s", fh.Filename, err))
+			return
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, NewErrorf("error reading %s: %s", fh.Filename, err))
+			return
+		}
+
+		img, err := processImage(data, cfg.ThumbnailSize)
+		if err != nil {
+			errorResponse(c, http.StatusUnsupportedMediaType, NewErrorf("%s: %s", fh.Filename, err))
+			return
+		}
+		images = append(images, img)
+	}
+
+	for _, img := range images {
+		p := &Photo{
+			ContentType: img.ContentType,
+			CreatedDate: app.Clock.Now(),
+			Height:      img.Height,
+			ID:          NewDocID(),
+			Size:        len(img.Data),
+			Width:       img.Width,
+		}
+		p.Key = photoPrefix(id) + string(p.ID) + img.Ext
+		p.ThumbnailKey = photoPrefix(id) + string(p.ID) + "_thumb" + img.Ext
+		p.URL = app.Blobs.URL(p.Key)
+		p.ThumbnailURL = app.Blobs.URL(p.ThumbnailKey)
+
+		if err := app.Blobs.Put(ctx, p.Key, bytes.NewReader(img.Data)); err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		if err := app.Blobs.Put(ctx, p.ThumbnailKey, bytes.NewReader(img.Thumbnail)); err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		added, err := AddPhoto(ctx, app.DB, id, p, cfg.MaxPerUser)
+		if err != nil || !added {
+			app.Blobs.Delete(ctx, p.Key)
+			app.Blobs.Delete(ctx, p.ThumbnailKey)
+		}
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+		if !added {
+			errorResponse(c, http.StatusConflict, NewErrorf("users can have at most %d photos", cfg.MaxPerUser))
+			return
+		}
+	}
+
+	app.respondWithAccount(c, id, http.StatusCreated)
+}
+
+// reorder photos with a body of {"order": [photo ids]}. The first one is the primary photo
+func (app *appContext) reorderPhotos(c *gin.Context) {
+	var body struct {
+		Order []string `json:"order"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errorResponse(c, http.StatusBadRequest, errors.New(`invalid request body. expected {"order": [photo ids]}`))
+		return
+	}
+
+	app.applyPhotoOrder(c, func(photos []*Photo) []string {
+		return body.Order
+	})
+}
+
+// make a photo the primary one by moving it to the front
+func (app *appContext) setPrimaryPhoto(c *gin.Context) {
+	photoID := c.Param("photoId")
+
+	app.applyPhotoOrder(c, func(photos []*Photo) []string {
+		ids := []string{photoID}
+		for _, p := range photos {
+			if string(p.ID) != photoID {
+				ids = append(ids, string(p.ID))
+			}
+		}
+		return ids
+	})
+}
+
+func (app *appContext) applyPhotoOrder(c *gin.Context, order func([]*Photo) []string) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	if !app.requireUsableAccount(c, id) {
+		return
+	}
+
+	u, err := FindAccount(ctx, app.DB, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	if u == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	user, err := ReorderPhotos(ctx, app.DB, id, order(u.Photos))
+	if err != nil {
+		if _, ok := err.(*PhotoOrderError); ok {
+			errorResponse(c, http.StatusBadRequest, err)
+			return
+		}
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": version(c).Account(user),
+	})
+}
+
+// delete a photo and its files
+func (app *appContext) deletePhoto(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	p, err := RemovePhoto(ctx, app.DB, c.Param("id"), c.Param("photoId"))
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if p == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("photo not found"))
+		return
+	}
+
+	for _, key := range []string{p.Key, p.ThumbnailKey} {
+		if err := app.Blobs.Delete(ctx, key); err != nil {
+			errorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// serve a blob. Keys are never reused, so they can be cached for good
+func (app *appContext) getBlob(c *gin.Context) {
+	key := c.Param("key")[1:]
+
+	f, err := app.Blobs.Get(c.Request.Context(), key)
+	if err == ErrBlobNotFound {
+		errorResponse(c, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.DataFromReader(http.StatusOK, -1, mime.TypeByExtension(path.Ext(key)), f, nil)
+}
+
+// respond with the user's account as it is now
+func (app *appContext) respondWithAccount(c *gin.Context, id string, status int) {
+	user, err := FindAccount(c.Request.Context(), app.DB, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"data": version(c).Account(user),
+	})
+}
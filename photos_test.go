@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_orderPhotos(t *testing.T) {
+	photos := []*Photo{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	ordered, err := orderPhotos(photos, []string{"c", "a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, []*Photo{{ID: "c"}, {ID: "a"}, {ID: "b"}}, ordered)
+
+	_, err = orderPhotos(photos, []string{"a", "b"})
+	assert.EqualError(t, err, "order must list all 3 photos")
+
+	_, err = orderPhotos(photos, []string{"a", "a", "b"})
+	assert.EqualError(t, err, "unknown or repeated photo a")
+}
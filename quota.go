@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QuotaStore counts how much of a quota has been used. Consume uses one unit of key's quota
+// for the period ending at resetAt and returns how many units are used. If limit was already
+// reached nothing is used and it returns false. Refund gives back n units used by something that
+// then failed
+type QuotaStore interface {
+	Consume(ctx context.Context, key string, limit int, resetAt time.Time) (int, bool, error)
+	Refund(ctx context.Context, key string, n int) error
+}
+
+// DailyQuota limits how many times a user can do something per day. The day rolls over at
+// ResetHour:ResetMinute UTC
+type DailyQuota struct {
+	// Name is used in storage keys and response headers, e.g. "likes"
+	Name        string
+	Limit       int
+	ResetHour   int
+	ResetMinute int
+}
+
+// QuotaResult is the state of a quota after trying to use it
+type QuotaResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	ResetAt   time.Time
+}
+
+// ResetAfter returns the first reset time after now
+func (q *DailyQuota) ResetAfter(now time.Time) time.Time {
+	now = now.UTC()
+	reset := time.Date(now.Year(), now.Month(), now.Day(), q.ResetHour, q.ResetMinute, 0, 0, time.UTC)
+	if !reset.After(now) {
+		reset = reset.AddDate(0, 0, 1)
+	}
+	return reset
+}
+
+// Consume uses one unit of userID's quota for the current day
+func (q *DailyQuota) Consume(ctx context.Context, store QuotaStore, userID string, now time.Time) (*QuotaResult, error) {
+	resetAt := q.ResetAfter(now)
+
+	used, ok, err := store.Consume(ctx, q.key(userID, resetAt), q.Limit, resetAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuotaResult{
+		Allowed:   ok,
+		Limit:     q.Limit,
+		Remaining: q.Limit - used,
+		ResetAt:   resetAt,
+	}, nil
+}
+
+// Refund gives back n units of userID's quota for the day ending at resetAt, e.g. for ratings
+// that weren't saved after all
+func (q *DailyQuota) Refund(ctx context.Context, store QuotaStore, userID string, resetAt time.Time, n int) error {
+	return store.Refund(ctx, q.key(userID, resetAt), n)
+}
+
+// key is the storage key of userID's quota for the day ending at resetAt
+func (q *DailyQuota) key(userID string, resetAt time.Time) string {
+	return fmt.Sprintf("%s:%s:%d", q.Name, userID, resetAt.Unix())
+}
+
+// SetHeaders adds X-<Name>-Limit, -Remaining and -Reset (unix seconds) to the response
+func (r *QuotaResult) SetHeaders(c *gin.Context, name string) {
+	prefix := "X-" + strings.Title(name) + "-"
+	c.Header(prefix+"Limit", strconv.Itoa(r.Limit))
+	c.Header(prefix+"Remaining", strconv.Itoa(r.Remaining))
+	c.Header(prefix+"Reset", strconv.FormatInt(r.ResetAt.Unix(), 10))
+}
+
+// parseResetTime reads a "HH:MM" time of day
+func parseResetTime(s string) (int, int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid reset time %q, expected HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// memoryQuotaStore counts usage in process. Quotas are per instance
+type memoryQuotaStore struct {
+	mu       sync.Mutex
+	counters map[string]*quotaCounter
+	// resetAt is the latest end of a period seen. Older counters are dropped once it moves on
+	resetAt time.Time
+}
+
+type quotaCounter struct {
+	used    int
+	resetAt time.Time
+}
+
+// NewMemoryQuotaStore is a constructor for an in process QuotaStore
+func NewMemoryQuotaStore() QuotaStore {
+	return &memoryQuotaStore{
+		counters: make(map[string]*quotaCounter),
+	}
+}
+
+func (s *memoryQuotaStore) Consume(_ context.Context, key string, limit int, resetAt time.Time) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// keys include the reset time, so counters from earlier periods are never used again
+	if resetAt.After(s.resetAt) {
+		for k, v := range s.counters {
+			if v.resetAt.Before(resetAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.resetAt = resetAt
+	}
+
+	c, ok := s.counters[key]
+	if !ok {
+		c = &quotaCounter{resetAt: resetAt}
+		s.counters[key] = c
+	}
+
+	if c.used >= limit {
+		return c.used, false, nil
+	}
+
+	c.used++
+	return c.used, true, nil
+}
+
+func (s *memoryQuotaStore) Refund(_ context.Context, key string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.counters[key]; ok {
+		c.used -= n
+		if c.used < 0 {
+			c.used = 0
+		}
+	}
+	return nil
+}
+
+// mongoQuotaStore counts usage in the quotas collection so quotas are shared between instances
+type mongoQuotaStore struct {
+	db *DB
+}
+
+// NewMongoQuotaStore is a constructor for a QuotaStore shared through mongo
+func NewMongoQuotaStore(db *DB) QuotaStore {
+	return &mongoQuotaStore{db: db}
+}
+
+// Consume increments the counter only while it is under the limit. Once it's reached the
+// filter stops matching and the upsert collides with the existing _id, which means no quota left.
+// The same collision happens when two first uses of the period race to insert the counter, so
+// it's tried once more before giving up
+func (s *mongoQuotaStore) Consume(ctx context.Context, key string, limit int, resetAt time.Time) (int, bool, error) {
+	if limit <= 0 {
+		return 0, false, nil
+	}
+
+	used, ok, err := s.consume(ctx, key, limit, resetAt)
+	if err == errQuotaCollision {
+		used, ok, err = s.consume(ctx, key, limit, resetAt)
+	}
+	if err == errQuotaCollision {
+		return limit, false, nil
+	}
+	return used, ok, err
+}
+
+// errQuotaCollision is an upsert of a quota counter that collided with an existing one
+var errQuotaCollision = errors.New("quota counter already exists")
+
+func (s *mongoQuotaStore) consume(ctx context.Context, key string, limit int, resetAt time.Time) (int, bool, error) {
+	coll := s.db.MongoClient.Collection("quotas")
+
+	filter := bson.M{
+		"_id":  key,
+		"used": bson.M{"$lt": limit},
+	}
+
+	update := bson.M{
+		"$inc":         bson.M{"used": 1},
+		"$setOnInsert": bson.M{"expireAt": resetAt},
+	}
+
+	after := options.After
+	upsert := true
+	opts := &options.FindOneAndUpdateOptions{
+		ReturnDocument: &after,
+		Upsert:         &upsert,
+	}
+
+	var doc struct {
+		Used int `bson:"used"`
+	}
+
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return 0, false, errQuotaCollision
+		}
+		return 0, false, NewErrorf("error updating quota %s: %s", key, err)
+	}
+
+	return doc.Used, true, nil
+}
+
+// Refund only takes back units that were used, so the counter never goes below 0
+func (s *mongoQuotaStore) Refund(ctx context.Context, key string, n int) error {
+	filter := bson.M{"_id": key, "used": bson.M{"$gte": n}}
+	if _, err := s.db.MongoClient.Collection("quotas").UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"used": -n}}); err != nil {
+		return NewErrorf("error refunding quota %s: %s", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RateLimitStore keeps token buckets by key. Take removes a token from the bucket for key,
+// refilled at rate tokens per second up to burst, and reports how long to wait if it was empty.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error)
+}
+
+// tokenBucket is the state of a single bucket
+type tokenBucket struct {
+	Tokens  float64   `bson:"tokens"`
+	Updated time.Time `bson:"updated"`
+}
+
+// take refills the bucket for the time passed since it was last used, then takes a token
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(burst), b.Tokens+elapsed*rate)
+		b.Updated = now
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.Tokens) / rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// memoryRateLimitStore keeps buckets in process. Limits are per instance
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewMemoryRateLimitStore is a constructor for an in process RateLimitStore
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// memoryBucketLimit is how many buckets to hold before sweeping out idle ones
+const memoryBucketLimit = 100000
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= memoryBucketLimit {
+			s.sweep(rate, burst, now)
+		}
+		b = &tokenBucket{Tokens: float64(burst), Updated: now}
+		s.buckets[key] = b
+	}
+
+	allowed, wait := b.take(rate, burst, now)
+	return allowed, wait, nil
+}
+
+// drop buckets that have been idle long enough to be full again, they'd be recreated the same
+func (s *memoryRateLimitStore) sweep(rate float64, burst int, now time.Time) {
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	for k, b := range s.buckets {
+		if now.Sub(b.Updated) >= full {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+// mongoRateLimitStore keeps buckets in the rate_limits collection so limits are shared
+// between instances. Buckets are updated with compare and swap on a version number.
+type mongoRateLimitStore struct {
+	db *DB
+}
+
+// mongoBucket is a tokenBucket as stored in mongo
+type mongoBucket struct {
+	Key      string      `bson:"_id"`
+	Bucket   tokenBucket `bson:",inline"`
+	Version  int64       `bson:"version"`
+	ExpireAt time.Time   `bson:"expireAt"`
+}
+
+// NewMongoRateLimitStore is a constructor for a RateLimitStore shared through mongo
+func NewMongoRateLimitStore(db *DB) RateLimitStore {
+	return &mongoRateLimitStore{db: db}
+}
+
+// how many times to retry a bucket update that lost a race with another instance
+const rateLimitRetries = 5
+
+func (s *mongoRateLimitStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	coll := s.db.MongoClient.Collection("rate_limits")
+
+	// buckets are expired by a ttl index once they'd be full again
+	expireAt := now.Add(time.Duration(float64(burst) / rate * float64(time.Second)))
+
+	for i := 0; i < rateLimitRetries; i++ {
+		var b mongoBucket
+		found := true
+
+		err := coll.FindOne(ctx, bson.M{"_id": key}).Decode(&b)
+		if err == mongo.ErrNoDocuments {
+			found = false
+			b = mongoBucket{Key: key, Bucket: tokenBucket{Tokens: float64(burst), Updated: now}}
+		} else if err != nil {
+			return false, 0, NewErrorf("error looking up rate limit %s: %s", key, err)
+		}
+
+		prev := b.Version
+		allowed, wait := b.Bucket.take(rate, burst, now)
+		b.Version++
+		b.ExpireAt = expireAt
+
+		if !found {
+			if _, err := coll.InsertOne(ctx, &b); err != nil {
+				if isDuplicateKeyError(err) {
+					continue
+				}
+				return false, 0, NewErrorf("error creating rate limit %s: %s", key, err)
+			}
+			return allowed, wait, nil
+		}
+
+		res, err := coll.ReplaceOne(ctx, bson.M{"_id": key, "version": prev}, &b)
+		if err != nil {
+			return false, 0, NewErrorf("error updating rate limit %s: %s", key, err)
+		}
+		if res.MatchedCount == 1 {
+			return allowed, wait, nil
+		}
+	}
+
+	return false, 0, fmt.Errorf("rate limit %s is too contended", key)
+}
+
+// rateLimitMiddleware limits requests per client ip and, for /users/:id routes, per user.
+// Requests over the limit get a 429 with a Retry-After header.
+func rateLimitMiddleware(store RateLimitStore, clock Clock, cfg *LimitsConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		now := clock.Now()
+
+		allowed, wait, err := store.Take(ctx, "ip:"+c.ClientIP(), cfg.IPRate, cfg.IPBurst, now)
+		if err == nil && allowed {
+			if id := c.Param("id"); id != "" {
+				allowed, wait, err = store.Take(ctx, "user:"+id, cfg.UserRate, cfg.UserBurst, now)
+			}
+		}
+
+		// fail open, an unavailable limiter shouldn't take the api down with it
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			errorResponse(c, http.StatusTooManyRequests, errors.New("too many requests, slow down"))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func Test_tokenBucket_take(t *testing.T) {
+	now := time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)
+	b := &tokenBucket{Tokens: 2, Updated: now}
+
+	ok, _ := b.take(1, 2, now)
+	assert.True(t, ok)
+	ok, _ = b.take(1, 2, now)
+	assert.True(t, ok)
+
+	ok, wait := b.take(1, 2, now)
+	assert.False(t, ok, "bucket should be empty")
+	assert.Equal(t, time.Second, wait)
+
+	ok, _ = b.take(1, 2, now.Add(time.Second))
+	assert.True(t, ok, "bucket should refill over time")
+
+	b.take(1, 2, now.Add(time.Hour))
+	assert.Equal(t, float64(1), b.Tokens, "bucket should not refill past burst")
+}
+
+func Test_rateLimitMiddleware(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)}
+	cfg := &LimitsConfig{IPRate: 100, IPBurst: 100, UserRate: 0.5, UserBurst: 2}
+
+	r := gin.New()
+	r.Use(rateLimitMiddleware(NewMemoryRateLimitStore(), clock, cfg))
+	r.POST("/users/:id/ratings", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	post := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/"+id+"/ratings", nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, post("a").Code)
+	assert.Equal(t, http.StatusCreated, post("a").Code)
+
+	w := post("a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusCreated, post("b").Code, "limits are per user")
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, http.StatusCreated, post("a").Code)
+}
+
+func TestDailyQuota_ResetAfter(t *testing.T) {
+	q := &DailyQuota{ResetHour: 9, ResetMinute: 30}
+
+	before := time.Date(2020, 1, 27, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 1, 27, 9, 30, 0, 0, time.UTC), q.ResetAfter(before))
+
+	after := time.Date(2020, 1, 27, 9, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 1, 28, 9, 30, 0, 0, time.UTC), q.ResetAfter(after))
+}
+
+func TestDailyQuota_Consume(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryQuotaStore()
+	q := &DailyQuota{Name: "likes", Limit: 2}
+	now := time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)
+
+	res, err := q.Consume(ctx, store, "a", now)
+	assert.Nil(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	res, _ = q.Consume(ctx, store, "a", now)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = q.Consume(ctx, store, "a", now)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Date(2020, 1, 28, 0, 0, 0, 0, time.UTC), res.ResetAt)
+
+	res, _ = q.Consume(ctx, store, "a", now.Add(12*time.Hour))
+	assert.True(t, res.Allowed, "quota should start over the next day")
+}
+
+func TestDailyQuota_Refund(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryQuotaStore()
+	q := &DailyQuota{Name: "likes", Limit: 1}
+	now := time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)
+
+	res, _ := q.Consume(ctx, store, "a", now)
+	assert.True(t, res.Allowed)
+
+	assert.Nil(t, q.Refund(ctx, store, "a", res.ResetAt, 1))
+	res, _ = q.Consume(ctx, store, "a", now)
+	assert.True(t, res.Allowed, "refunded quota can be used again")
+
+	assert.Nil(t, q.Refund(ctx, store, "a", res.ResetAt, 5))
+	res, _ = q.Consume(ctx, store, "a", now)
+	assert.Equal(t, 0, res.Remaining, "refunds don't go below nothing used")
+	res, _ = q.Consume(ctx, store, "a", now)
+	assert.False(t, res.Allowed)
+}
+
+func TestMemoryQuotaStore_rollover(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryQuotaStore().(*memoryQuotaStore)
+	today := time.Date(2020, 1, 28, 0, 0, 0, 0, time.UTC)
+
+	for _, key := range []string{"likes:a", "likes:b"} {
+		_, ok, err := store.Consume(ctx, key, 1, today)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	assert.Len(t, store.counters, 2, "counters of the same period are kept")
+
+	_, ok, _ := store.Consume(ctx, "likes:a:tomorrow", 1, today.AddDate(0, 0, 1))
+	assert.True(t, ok)
+	assert.Len(t, store.counters, 1, "counters of earlier periods are dropped once the next one starts")
+
+	_, ok, _ = store.Consume(ctx, "superlikes:a", 0, today.AddDate(0, 0, 1))
+	assert.False(t, ok, "a limit of 0 allows nothing")
+}
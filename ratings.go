@@ -2,42 +2,153 @@ package main
 
 import (
 	"context"
-	"log"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// RatingType is the kind of action one user takes on another
+type RatingType string
+
 const (
-	LIKE   = "LIKE"
-	BLOCK  = "BLOCK"
-	REPORT = "REPORT"
+	LIKE      RatingType = "LIKE"
+	SUPERLIKE RatingType = "SUPERLIKE"
+	BLOCK     RatingType = "BLOCK"
+	REPORT    RatingType = "REPORT"
+	PASS      RatingType = "PASS"
 )
 
+// RatingTypeInfo describes how a rating type behaves
+type RatingTypeInfo struct {
+	Type RatingType
+	// CountsAsLike makes the rating count toward a match and show up in incoming likes
+	CountsAsLike bool
+	// Priority orders incoming likes, higher first
+	Priority int
+	// HidesPair removes likes between the two users in both directions and hides them from
+	// each other's feed
+	HidesPair bool
+	// RequiresReason rejects ratings without a reason
+	RequiresReason bool
+	// FeedCooldown is how long the rated user is hidden from the rater's feed. Zero hides them for good
+	FeedCooldown time.Duration
+	// Quota names the daily quota this type uses up, empty for none
+	Quota string
+	// Event is the type of event sent to webhooks when a rating is saved, empty for none
+	Event string
+	// HidesRater keeps who gave the rating from the rated user, even in the export of their data
+	HidesRater bool
+}
+
+// ratingTypes is the registry of every rating type. Adding a type only needs an entry here, and
+// in the RatingType enums of schema.graphql and homeworkpb/homework.proto for those apis
+var ratingTypes = []*RatingTypeInfo{
+	{Type: LIKE, CountsAsLike: true, Quota: "likes", Event: "like.created"},
+	{Type: SUPERLIKE, CountsAsLike: true, Priority: 1, Quota: "superlikes", Event: "like.created"},
+	{Type: BLOCK, HidesPair: true, Event: "block.created", HidesRater: true},
+	{Type: REPORT, RequiresReason: true, Event: "report.created", HidesRater: true},
+	{Type: PASS, FeedCooldown: 7 * 24 * time.Hour},
+}
+
+// LookupRatingType returns the registered behavior of a rating type
+func LookupRatingType(t RatingType) (*RatingTypeInfo, bool) {
+	for _, info := range ratingTypes {
+		if info.Type == t {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+// RatingTypeNames lists every registered type, for error messages
+func RatingTypeNames() []string {
+	names := make([]string, 0, len(ratingTypes))
+	for _, info := range ratingTypes {
+		names = append(names, string(info.Type))
+	}
+	return names
+}
+
+// likeTypes are the types that count toward a match
+func likeTypes() []RatingType {
+	types := make([]RatingType, 0)
+	for _, info := range ratingTypes {
+		if info.CountsAsLike {
+			types = append(types, info.Type)
+		}
+	}
+	return types
+}
+
+// ratingPriority orders incoming likes, unknown types go last
+func ratingPriority(t RatingType) int {
+	if info, ok := LookupRatingType(t); ok {
+		return info.Priority
+	}
+	return -1
+}
+
 // Rating is a struct where it contains which user likes/blocks/reports other users
 type Rating struct {
-	CreatedDate time.Time `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
-	FromUserID  string    `json:"fromUserId,omitempty" bson:"fromUserId,omitempty"`
-	ID          string    `json:"_id,omitempty" bson:"_id,omitempty"`
-	Reason      string    `json:"reason,omitempty" bson:"reason,omitempty"`
-	ToUserID    string    `json:"toUserId,omitempty" bson:"toUserId,omitempty"`
-	Type        string    `json:"type,omitempty" bson:"type,omitempty"`
+	CreatedDate time.Time  `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
+	FromUserID  string     `json:"fromUserId,omitempty" bson:"fromUserId,omitempty"`
+	ID          DocID      `json:"_id,omitempty" bson:"_id,omitempty"`
+	Reason      string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	ToUserID    string     `json:"toUserId,omitempty" bson:"toUserId,omitempty"`
+	Type        RatingType `json:"type,omitempty" bson:"type,omitempty"`
 }
 
+// RatingParams filters ratings. Set fields of Filter must match, and if Types, FromUserIDs or
+// ToUserIDs are given the rating's type, rater or rated user must be one of them
 type RatingParams struct {
-	Filter *Rating
-	// Projection
+	Filter      *Rating
+	FromUserIDs []string
+	ToUserIDs   []string
+	Types       []RatingType
+}
+
+// query builds the mongo filter document
+func (p *RatingParams) query() (bson.M, error) {
+	q := bson.M{}
+
+	if p.Filter != nil {
+		b, err := bson.Marshal(p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(b, &q); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(p.FromUserIDs) > 0 {
+		q["fromUserId"] = bson.M{"$in": p.FromUserIDs}
+	}
+
+	if len(p.ToUserIDs) > 0 {
+		q["toUserId"] = bson.M{"$in": p.ToUserIDs}
+	}
+
+	if len(p.Types) > 0 {
+		q["type"] = bson.M{"$in": p.Types}
+	}
+
+	return q, nil
 }
 
 // look for all the users that has took an action against this userId
-func FindRatings(db *DB, params *RatingParams) ([]*Rating, error) {
+func FindRatings(ctx context.Context, db *DB, params *RatingParams) ([]*Rating, error) {
 	coll := db.MongoClient.Collection("ratings")
-	ctx := context.Background()
 
 	ratings := make([]*Rating, 0)
 
-	cur, err := coll.Find(ctx, params.Filter)
+	q, err := params.query()
+	if err != nil {
+		return nil, NewErrorf("error building rating query: %s", err)
+	}
+
+	cur, err := coll.Find(ctx, q)
 	if err != nil {
 		return nil, NewErrorf("error finding ratings from mongo: %s", err)
 	}
@@ -57,29 +168,61 @@ func FindRatings(db *DB, params *RatingParams) ([]*Rating, error) {
 }
 
 // FindRatingExists checks if a given document exists within the db
-func FindRatingExists(db *DB, params *RatingParams) (bool, error) {
+func FindRatingExists(ctx context.Context, db *DB, params *RatingParams) (bool, error) {
 	coll := db.MongoClient.Collection("ratings")
 
-	doc := coll.FindOne(context.Background(), params.Filter)
+	q, err := params.query()
+	if err != nil {
+		return false, NewErrorf("error building rating query: %s", err)
+	}
+
+	doc := coll.FindOne(ctx, q)
 	if doc.Err() != nil {
 		if doc.Err() == mongo.ErrNoDocuments {
 			return false, nil
 		}
-		log.Printf("error looking up rating: %s \n", doc.Err())
+		logf(levelError, "error looking up rating: %s", doc.Err())
 		return false, doc.Err()
 	}
 
 	return true, nil
 }
 
-// Save inserts a new like entry to the database
-func (r *Rating) Save(db *DB) error {
+// InsertRatings adds ratings in bulk as they are. Unlike Save, it doesn't check for
+// existing ratings or apply BLOCK side effects, so callers must pass a consistent set
+func InsertRatings(ctx context.Context, db *DB, ratings []*Rating) error {
+	docs := make([]interface{}, 0, len(ratings))
+	for _, v := range ratings {
+		docs = append(docs, v)
+	}
+
+	if err := insertMany(ctx, db.MongoClient.Collection("ratings"), docs); err != nil {
+		return NewErrorf("error inserting ratings: %s", err)
+	}
+
+	return nil
+}
+
+// Save inserts a new rating to the database. Repeating a rating does nothing, except for types
+// with a feed cooldown where it starts the cooldown over. The rating, what it changes and their
+// events are written in one transaction when the database supports them. The rating is dated now
+func (r *Rating) Save(ctx context.Context, db *DB, now time.Time) error {
+	return db.transaction(ctx, func(ctx context.Context) error {
+		return r.save(ctx, db, now)
+	})
+}
+
+func (r *Rating) save(ctx context.Context, db *DB, now time.Time) error {
 	coll := db.MongoClient.Collection("ratings")
-	ctx := context.Background()
+
+	info, ok := LookupRatingType(r.Type)
+	if !ok {
+		return NewErrorf("unknown rating type %s", r.Type)
+	}
 
 	// create unique ID and set createdDate
-	r.ID = primitive.NewObjectID().Hex()
-	r.CreatedDate = time.Now()
+	r.ID = NewDocID()
+	r.CreatedDate = now
 
 	filter := &RatingParams{
 		Filter: &Rating{
@@ -90,33 +233,97 @@ func (r *Rating) Save(db *DB) error {
 	}
 
 	// check if the rating exists before inserting a new one
-	exists, err := FindRatingExists(db, filter)
+	exists, err := FindRatingExists(ctx, db, filter)
 	if err != nil {
 		return err
 	}
 
 	// don't save new entry if it already exists.
 	if exists {
+		if info.FeedCooldown > 0 {
+			q := bson.M{"fromUserId": r.FromUserID, "toUserId": r.ToUserID, "type": r.Type}
+
+			var before Rating
+			err := coll.FindOneAndUpdate(ctx, q, bson.M{"$set": bson.M{"createdDate": r.CreatedDate}}).Decode(&before)
+			if err != nil {
+				return NewErrorf("error refreshing rating: %s", err)
+			}
+
+			after := before
+			after.CreatedDate = r.CreatedDate
+			return recordChange(ctx, db, "rating.refresh", "rating", string(before.ID), &before, &after, nil)
+		}
 		return nil
 	}
 
 	// otherwise insert a new one
 	if _, err := coll.InsertOne(ctx, r); err != nil {
-		log.Printf("error creating a new like: %s\n", err)
+		logf(levelError, "error creating a new like: %s", err)
 		return err
 	}
 
-	// if it was a block entry, from user A to B, remove user A's LIKE to user B, and vice versa
-	if r.Type == BLOCK {
-		filter.Filter.Type = LIKE
-		if _, err := coll.DeleteOne(ctx, filter); err != nil {
-			return NewErrorf("error deleting like entry: %s", err)
+	if err := recordChange(ctx, db, "rating.create", "rating", string(r.ID), nil, r, nil); err != nil {
+		return err
+	}
+
+	if info.Event != "" {
+		if err := emitEvent(ctx, db, info.Event, NewRatingView(r), r.CreatedDate); err != nil {
+			return err
+		}
+	}
+
+	// a like back from the other user makes a match
+	matched := false
+	if info.CountsAsLike {
+		likedBack, err := FindRatingExists(ctx, db, &RatingParams{
+			Filter: &Rating{
+				FromUserID: r.ToUserID,
+				ToUserID:   r.FromUserID,
+			},
+			Types: likeTypes(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if likedBack {
+			if matched, err = CreateMatch(ctx, db, r.FromUserID, r.ToUserID, r.CreatedDate); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := notify(ctx, db, ratingNotifications(r, info, matched, r.CreatedDate)...); err != nil {
+		return err
+	}
+
+	// if it was a block entry, from user A to B, remove user A's likes to user B, and vice versa,
+	// and end their match
+	if info.HidesPair {
+		likes := make([]*Rating, 0)
+		for _, pair := range [][2]string{{r.FromUserID, r.ToUserID}, {r.ToUserID, r.FromUserID}} {
+			found, err := FindRatings(ctx, db, &RatingParams{
+				Filter: &Rating{FromUserID: pair[0], ToUserID: pair[1]},
+				Types:  likeTypes(),
+			})
+			if err != nil {
+				return err
+			}
+			likes = append(likes, found...)
+		}
+
+		for _, like := range likes {
+			if _, err := coll.DeleteOne(ctx, bson.M{"_id": like.ID}); err != nil {
+				return NewErrorf("error deleting like entries: %s", err)
+			}
+
+			if err := recordChange(ctx, db, "rating.delete", "rating", string(like.ID), like, nil, bson.M{"cause": string(r.ID)}); err != nil {
+				return err
+			}
 		}
 
-		// swap fromUserId with toUserId
-		filter.Filter.FromUserID, filter.Filter.ToUserID = filter.Filter.ToUserID, filter.Filter.FromUserID
-		if _, err := coll.DeleteOne(ctx, filter); err != nil {
-			return NewErrorf("error deleting like entry: %s", err)
+		if _, err := EndMatch(ctx, db, r.FromUserID, r.ToUserID, MatchUnmatched, r.FromUserID, r.CreatedDate); err != nil {
+			return err
 		}
 	}
 
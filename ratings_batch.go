@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RatingBatchStatus is what happened to one rating of a batch
+type RatingBatchStatus string
+
+const (
+	RatingCreated   RatingBatchStatus = "created"
+	RatingDuplicate RatingBatchStatus = "duplicate"
+	RatingRejected  RatingBatchStatus = "rejected"
+)
+
+// RatingBatchResult is what happened to one rating of a batch. Rejected ratings say why, like
+// the error of a single rating would
+type RatingBatchResult struct {
+	Error string `json:"error,omitempty"`
+	// ID is the new rating's, or for a duplicate the one it repeats
+	ID      string            `json:"id,omitempty"`
+	Matched bool              `json:"matched,omitempty"`
+	Reasons []string          `json:"reasons,omitempty"`
+	Status  RatingBatchStatus `json:"status"`
+}
+
+// RatingBatch saves many ratings from one user with a few bulk writes. Ratings are added in
+// order, against what's stored and what the batch added before them, with the same effects as
+// saving them one by one: repeats are duplicates, BLOCKs remove the likes between the pair and
+// end their match, and likes back make matches
+type RatingBatch struct {
+	fromUserID string
+	// mine are the rater's ratings of the users in the batch, by rated user and type
+	mine map[string]map[RatingType]*Rating
+	// theirLikes are the likes the users in the batch gave the rater, by user
+	theirLikes map[string][]*Rating
+
+	changes []*AuditEntry
+	events  []*Event
+	// likes are the new likes, which notify the rated user, or both users if they match
+	likes   []*batchLike
+	matches []*batchMatch
+	writes  []mongo.WriteModel
+}
+
+// batchLike is a new like and its result, which says if it matched once the batch is written
+type batchLike struct {
+	info   *RatingTypeInfo
+	rating *Rating
+	result *RatingBatchResult
+}
+
+// batchMatch is a match to make or end once the ratings are written
+type batchMatch struct {
+	date    time.Time
+	end     bool
+	otherID string
+	result  *RatingBatchResult
+}
+
+// LoadRatingBatch starts a batch of ratings from fromUserID, loading the ratings between them
+// and toUserIDs that the batch depends on
+func LoadRatingBatch(ctx context.Context, db *DB, fromUserID string, toUserIDs []string) (*RatingBatch, error) {
+	b := &RatingBatch{
+		fromUserID: fromUserID,
+		mine:       make(map[string]map[RatingType]*Rating),
+		theirLikes: make(map[string][]*Rating),
+	}
+
+	if len(toUserIDs) == 0 {
+		return b, nil
+	}
+
+	mine, err := FindRatings(ctx, db, &RatingParams{Filter: &Rating{FromUserID: fromUserID}, ToUserIDs: toUserIDs})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range mine {
+		b.ratingsOf(r.ToUserID)[r.Type] = r
+	}
+
+	theirs, err := FindRatings(ctx, db, &RatingParams{Filter: &Rating{ToUserID: fromUserID}, FromUserIDs: toUserIDs, Types: likeTypes()})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range theirs {
+		b.theirLikes[r.FromUserID] = append(b.theirLikes[r.FromUserID], r)
+	}
+
+	return b, nil
+}
+
+// ratingsOf returns the rater's ratings of a user by type
+func (b *RatingBatch) ratingsOf(toUserID string) map[RatingType]*Rating {
+	ratings, ok := b.mine[toUserID]
+	if !ok {
+		ratings = make(map[RatingType]*Rating)
+		b.mine[toUserID] = ratings
+	}
+	return ratings
+}
+
+// Exists tells if the rater already gave the rating, before the batch or earlier in it
+func (b *RatingBatch) Exists(r *Rating) bool {
+	return b.mine[r.ToUserID][r.Type] != nil
+}
+
+// Add adds a checked rating to the batch, given at now, and fills in its result. Whether it
+// makes a match is only known once the batch is written
+func (b *RatingBatch) Add(ctx context.Context, r *Rating, info *RatingTypeInfo, now time.Time, result *RatingBatchResult) error {
+	ratings := b.ratingsOf(r.ToUserID)
+
+	if existing := ratings[r.Type]; existing != nil {
+		result.ID = string(existing.ID)
+		result.Status = RatingDuplicate
+
+		// like Save, repeating a rating with a feed cooldown starts the cooldown over
+		if info.FeedCooldown == 0 {
+			return nil
+		}
+
+		before := *existing
+		existing.CreatedDate = now
+		b.writes = append(b.writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": existing.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"createdDate": now}}))
+		return b.record(ctx, "rating.refresh", &before, existing, nil)
+	}
+
+	r.ID = NewDocID()
+	r.CreatedDate = now
+	ratings[r.Type] = r
+	b.writes = append(b.writes, mongo.NewInsertOneModel().SetDocument(r))
+	result.ID = string(r.ID)
+	result.Status = RatingCreated
+
+	if err := b.record(ctx, "rating.create", nil, r, nil); err != nil {
+		return err
+	}
+
+	if info.Event != "" {
+		e, err := newEvent(info.Event, NewRatingView(r), now)
+		if err != nil {
+			return err
+		}
+		b.events = append(b.events, e)
+	}
+
+	// a like back from the other user makes a match
+	if info.CountsAsLike {
+		b.likes = append(b.likes, &batchLike{info: info, rating: r, result: result})
+		if len(b.theirLikes[r.ToUserID]) > 0 {
+			b.matches = append(b.matches, &batchMatch{date: now, otherID: r.ToUserID, result: result})
+		}
+	}
+
+	// a block removes the likes between the pair, both ways, and ends their match
+	if info.HidesPair {
+		likes := b.theirLikes[r.ToUserID]
+		delete(b.theirLikes, r.ToUserID)
+		for _, t := range likeTypes() {
+			if like, ok := ratings[t]; ok {
+				likes = append(likes, like)
+				delete(ratings, t)
+			}
+		}
+
+		for _, like := range likes {
+			b.writes = append(b.writes, mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": like.ID}))
+			if err := b.record(ctx, "rating.delete", like, nil, bson.M{"cause": string(r.ID)}); err != nil {
+				return err
+			}
+		}
+
+		b.matches = append(b.matches, &batchMatch{date: now, end: true, otherID: r.ToUserID})
+	}
+
+	return nil
+}
+
+// record adds the audit entry of a change to a rating, saved along with the batch
+func (b *RatingBatch) record(ctx context.Context, action string, before, after *Rating, details bson.M) error {
+	id := ""
+	if after != nil {
+		id = string(after.ID)
+	} else {
+		id = string(before.ID)
+	}
+
+	var beforeDoc, afterDoc interface{}
+	if before != nil {
+		beforeDoc = before
+	}
+	if after != nil {
+		afterDoc = after
+	}
+
+	e, err := changeEntry(ctx, action, "rating", id, beforeDoc, afterDoc, details)
+	if err != nil {
+		return err
+	}
+
+	b.changes = append(b.changes, e)
+	return nil
+}
+
+// Write saves the batch: its ratings in one ordered bulk write, their audit entries and events,
+// then the matches they make and end, in order, and the notifications of the likes. It's all one
+// transaction when the database supports them. A rating saved by someone else in the meantime
+// fails the write with a *RatingError, and sending the batch again is safe
+func (b *RatingBatch) Write(ctx context.Context, db *DB) error {
+	return db.transaction(ctx, func(ctx context.Context) error {
+		return b.write(ctx, db)
+	})
+}
+
+func (b *RatingBatch) write(ctx context.Context, db *DB) error {
+	if len(b.writes) > 0 {
+		coll := db.MongoClient.Collection("ratings")
+		if _, err := coll.BulkWrite(ctx, b.writes, options.BulkWrite().SetOrdered(true)); err != nil {
+			if isDuplicateKeyError(err) {
+				return &RatingError{msg: "ratings changed while the batch was saved. send it again, saved ratings come back as duplicates", Status: http.StatusConflict}
+			}
+			return NewErrorf("error writing ratings: %s", err)
+		}
+	}
+
+	if len(b.changes) > 0 {
+		if err := RecordAudits(ctx, db, b.changes); err != nil {
+			return err
+		}
+	}
+
+	if len(b.events) > 0 {
+		if err := RecordEvents(ctx, db, b.events); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range b.matches {
+		if m.end {
+			if _, err := EndMatch(ctx, db, b.fromUserID, m.otherID, MatchUnmatched, b.fromUserID, m.date); err != nil {
+				return err
+			}
+			continue
+		}
+
+		created, err := CreateMatch(ctx, db, b.fromUserID, m.otherID, m.date)
+		if err != nil {
+			return err
+		}
+		m.result.Matched = created
+	}
+
+	return notify(ctx, db, b.notifications()...)
+}
+
+// notifications are what the batch's likes tell users, once the matches are made. Likes the
+// batch went on to remove with a BLOCK tell nobody
+func (b *RatingBatch) notifications() []*Notification {
+	notifications := make([]*Notification, 0)
+	for _, l := range b.likes {
+		if b.mine[l.rating.ToUserID][l.rating.Type] != l.rating {
+			continue
+		}
+		notifications = append(notifications, ratingNotifications(l.rating, l.info, l.result.Matched, l.rating.CreatedDate)...)
+	}
+	return notifications
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRatingBatch_Add(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	b, err := LoadRatingBatch(ctx, nil, "a", nil)
+	assert.Nil(t, err)
+	b.theirLikes["b"] = []*Rating{{ID: "ba", FromUserID: "b", ToUserID: "a", Type: LIKE}}
+	b.ratingsOf("c")[LIKE] = &Rating{ID: "ac", FromUserID: "a", ToUserID: "c", Type: LIKE}
+
+	add := func(to string, typ RatingType) *RatingBatchResult {
+		info, _ := LookupRatingType(typ)
+		res := &RatingBatchResult{}
+		assert.Nil(t, b.Add(ctx, &Rating{FromUserID: "a", ToUserID: to, Type: typ}, info, now, res))
+		return res
+	}
+
+	// a like back makes a match once written
+	liked := add("b", LIKE)
+	assert.Equal(t, RatingCreated, liked.Status)
+	assert.NotEmpty(t, liked.ID)
+	assert.True(t, b.Exists(&Rating{ToUserID: "b", Type: LIKE}))
+
+	// stored and earlier ratings are duplicates
+	assert.Equal(t, &RatingBatchResult{ID: "ac", Status: RatingDuplicate}, add("c", LIKE))
+	assert.Equal(t, &RatingBatchResult{ID: liked.ID, Status: RatingDuplicate}, add("b", LIKE))
+
+	// a block removes the likes both ways and ends the match
+	blocked := add("b", BLOCK)
+	assert.Equal(t, RatingCreated, blocked.Status)
+	assert.False(t, b.Exists(&Rating{ToUserID: "b", Type: LIKE}))
+	assert.Empty(t, b.theirLikes["b"])
+
+	assert.Len(t, b.matches, 2)
+	assert.Equal(t, &batchMatch{date: now, otherID: "b", result: liked}, b.matches[0])
+	assert.Equal(t, &batchMatch{date: now, end: true, otherID: "b"}, b.matches[1])
+
+	// two inserts and two deletes, each audited
+	assert.Len(t, b.writes, 4)
+	actions := make([]string, 0, len(b.changes))
+	for _, e := range b.changes {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{"rating.create", "rating.create", "rating.delete", "rating.delete"}, actions)
+
+	// the like was blocked, so it tells nobody
+	assert.Len(t, b.likes, 1)
+	assert.Empty(t, b.notifications())
+}
+
+func TestNewRatingBatch_request(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features.RateLimiting = false
+	cfg.Limits.RatingBatchSize = 2
+	router := setupRouter(&appContext{Config: cfg})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"not the batch route", "/v1/users/a/ratingsfoo", `{"ratings": [{"toUserId": "b", "type": "LIKE"}]}`, http.StatusNotFound},
+		{"colon suffix", "/v1/users/a/ratings:batch", `{"ratings": [{"toUserId": "b", "type": "LIKE"}]}`, http.StatusNotFound},
+		{"no ratings", "/v1/users/a/ratings/batch", `{"ratings": []}`, http.StatusBadRequest},
+		{"too many ratings", "/v1/users/a/ratings/batch", `{"ratings": [{}, {}, {}]}`, http.StatusBadRequest},
+		{"bad body", "/v1/users/a/ratings/batch", `[]`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLookupRatingType(t *testing.T) {
+	info, ok := LookupRatingType(SUPERLIKE)
+	assert.True(t, ok)
+	assert.True(t, info.CountsAsLike)
+	assert.Greater(t, info.Priority, ratingPriority(LIKE), "superlikes should come before likes")
+
+	_, ok = LookupRatingType("LOVE")
+	assert.False(t, ok)
+
+	assert.Equal(t, []RatingType{LIKE, SUPERLIKE}, likeTypes())
+}
+
+func Test_ratingTypes_unique(t *testing.T) {
+	seen := make(map[RatingType]bool)
+	for _, info := range ratingTypes {
+		assert.False(t, seen[info.Type], "%s registered twice", info.Type)
+		seen[info.Type] = true
+	}
+}
+
+func TestRatingParams_query(t *testing.T) {
+	p := &RatingParams{
+		Filter: &Rating{ToUserID: "5e2e39ee290f5a56ffda9ed5"},
+		Types:  likeTypes(),
+	}
+
+	q, err := p.query()
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"toUserId": "5e2e39ee290f5a56ffda9ed5",
+		"type":     bson.M{"$in": []RatingType{LIKE, SUPERLIKE}},
+	}, q)
+}
+
+func TestRatingParams_query_userIDs(t *testing.T) {
+	p := &RatingParams{FromUserIDs: []string{"c"}, ToUserIDs: []string{"a", "b"}}
+
+	q, err := p.query()
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"fromUserId": bson.M{"$in": []string{"c"}},
+		"toUserId":   bson.M{"$in": []string{"a", "b"}},
+	}, q)
+}
+
+func Test_incomingLikes(t *testing.T) {
+	likes := []*Rating{
+		{FromUserID: "a", Type: LIKE},
+		{FromUserID: "b", Type: LIKE},
+		{FromUserID: "a", Type: SUPERLIKE},
+	}
+
+	got := incomingLikes(likes)
+	assert.Len(t, got, 2)
+	assert.Equal(t, &Rating{FromUserID: "a", Type: SUPERLIKE}, got[0], "superlikes come first, once per user")
+	assert.Equal(t, "b", got[1].FromUserID)
+}
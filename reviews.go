@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReviewStatus is where a content review is in the moderation queue
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	// ReviewRemoved takes the content down, if it hasn't changed since
+	ReviewRemoved ReviewStatus = "removed"
+)
+
+// ContentReview is borderline content the filter let through, waiting for a moderator
+type ContentReview struct {
+	CreatedDate  time.Time    `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
+	Field        ContentField `json:"field,omitempty" bson:"field,omitempty"`
+	ID           DocID        `json:"id,omitempty" bson:"_id,omitempty"`
+	Reasons      []string     `json:"reasons,omitempty" bson:"reasons,omitempty"`
+	ResolvedBy   string       `json:"resolvedBy,omitempty" bson:"resolvedBy,omitempty"`
+	ResolvedDate *time.Time   `json:"resolvedDate,omitempty" bson:"resolvedDate,omitempty"`
+	Status       ReviewStatus `json:"status,omitempty" bson:"status,omitempty"`
+	TargetID     string       `json:"targetId,omitempty" bson:"targetId,omitempty"`
+	TargetType   string       `json:"targetType,omitempty" bson:"targetType,omitempty"`
+	Text         string       `json:"text,omitempty" bson:"text,omitempty"`
+	UserID       string       `json:"userId,omitempty" bson:"userId,omitempty"`
+}
+
+// QueueReview adds content to the moderation queue
+func QueueReview(ctx context.Context, db *DB, r *ContentReview) error {
+	coll := db.MongoClient.Collection("reviews")
+
+	r.ID = NewDocID()
+	r.Status = ReviewPending
+
+	if _, err := coll.InsertOne(ctx, r); err != nil {
+		return NewErrorf("error queueing review of %s %s: %s", r.TargetType, r.TargetID, err)
+	}
+
+	return recordChange(ctx, db, "review.create", "review", string(r.ID), nil, r, nil)
+}
+
+// FindReviews returns reviews in a status, oldest first so the queue is worked in order
+func FindReviews(ctx context.Context, db *DB, status ReviewStatus, limit int64) ([]*ContentReview, error) {
+	opts := options.Find().SetSort(bson.M{"createdDate": 1}).SetLimit(limit)
+	return findReviews(ctx, db, bson.M{"status": status}, opts)
+}
+
+func findReviews(ctx context.Context, db *DB, filter bson.M, opts *options.FindOptions) ([]*ContentReview, error) {
+	cur, err := db.MongoClient.Collection("reviews").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, NewErrorf("error finding reviews from mongo: %s", err)
+	}
+
+	defer cur.Close(ctx)
+
+	reviews := make([]*ContentReview, 0)
+	for cur.Next(ctx) {
+		var r ContentReview
+		if err := cur.Decode(&r); err != nil {
+			return nil, NewErrorf("error decoding into review struct: %s", err)
+		}
+
+		reviews = append(reviews, &r)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return reviews, nil
+}
+
+// ResolveReview approves or removes pending content. Returns the resolved review, or nil if
+// there is no such pending review
+func ResolveReview(ctx context.Context, db *DB, id string, status ReviewStatus, resolvedBy string, now time.Time) (*ContentReview, error) {
+	coll := db.MongoClient.Collection("reviews")
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":       status,
+			"resolvedBy":   resolvedBy,
+			"resolvedDate": now,
+		},
+	}
+
+	var before ContentReview
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": DocID(id), "status": ReviewPending}, update).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, NewErrorf("error resolving review %s: %s", id, err)
+	}
+
+	after := before
+	after.Status = status
+	after.ResolvedBy = resolvedBy
+	after.ResolvedDate = &now
+	if err := recordChange(ctx, db, "review.resolve", "review", id, &before, &after, nil); err != nil {
+		return nil, err
+	}
+
+	if status == ReviewRemoved {
+		if err := removeReviewedContent(ctx, db, &before, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &after, nil
+}
+
+// take down the reviewed text, unless it has been changed since, and warn its author
+func removeReviewedContent(ctx context.Context, db *DB, r *ContentReview, now time.Time) error {
+	collections := map[string]string{"user": "users", "rating": "ratings"}
+	name, ok := collections[r.TargetType]
+	if !ok {
+		return nil
+	}
+
+	field := string(r.Field)
+	filter := bson.M{"_id": DocID(r.TargetID), field: r.Text}
+
+	res, err := db.MongoClient.Collection(name).UpdateOne(ctx, filter, bson.M{"$unset": bson.M{field: ""}})
+	if err != nil {
+		return NewErrorf("error removing %s of %s %s: %s", field, r.TargetType, r.TargetID, err)
+	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+	if name == "users" {
+		db.forgetUsers(ctx, r.TargetID)
+	}
+
+	details := bson.M{"review": string(r.ID)}
+	if err := recordChange(ctx, db, r.TargetType+".moderate", r.TargetType, r.TargetID, bson.M{field: r.Text}, bson.M{}, details); err != nil {
+		return err
+	}
+
+	warning := newNotification(NotifyWarning, r.UserID, "", now)
+	warning.Reason = fmt.Sprintf("your %s was removed for breaking the community guidelines", r.Field)
+	return notify(ctx, db, warning)
+}
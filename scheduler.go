@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler runs a job in the background every interval until stopped. The job is given the
+// time from the scheduler's clock, so tests can control what time it thinks it is
+type Scheduler struct {
+	name     string
+	clock    Clock
+	interval time.Duration
+	job      func(ctx context.Context, now time.Time) error
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler is a constructor for a Scheduler. Nothing runs until Start is called
+func NewScheduler(name string, clock Clock, interval time.Duration, job func(ctx context.Context, now time.Time) error) *Scheduler {
+	return &Scheduler{
+		name:     name,
+		clock:    clock,
+		interval: interval,
+		job:      job,
+	}
+}
+
+// Start runs the job every interval in a new goroutine. Errors are logged and the job keeps
+// being scheduled
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.job(ctx, s.clock.Now()); err != nil && ctx.Err() == nil {
+					logf(levelError, "%s: %s", s.name, err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop cancels a running job and waits for the goroutine to exit
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// matchExpiryJob expires matches that have gone expireAfter without any activity
+func matchExpiryJob(db *DB, expireAfter time.Duration) func(context.Context, time.Time) error {
+	actor := &AuditActor{ID: "system:match-expiry"}
+	return func(ctx context.Context, now time.Time) error {
+		n, err := ExpireMatches(WithAuditActor(ctx, actor), db, now, expireAfter)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logf(levelInfo, "expired %d matches", n)
+		}
+		return nil
+	}
+}
+
+// accountPurgeJob purges users whose deletion grace period is over
+func accountPurgeJob(db *DB, blobs BlobStore, grace time.Duration) func(context.Context, time.Time) error {
+	actor := &AuditActor{ID: "system:account-purge"}
+	return func(ctx context.Context, now time.Time) error {
+		n, err := PurgeDeletedUsers(WithAuditActor(ctx, actor), db, blobs, now, grace)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logf(levelInfo, "purged %d deleted users", n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)}
+	ran := make(chan time.Time, 10)
+
+	s := NewScheduler("test", clock, time.Millisecond, func(ctx context.Context, now time.Time) error {
+		select {
+		case ran <- now:
+		default:
+		}
+		return nil
+	})
+	s.Start()
+
+	select {
+	case now := <-ran:
+		assert.Equal(t, clock.now, now, "job should be given the scheduler's clock time")
+	case <-time.After(time.Second):
+		t.Fatal("job never ran")
+	}
+
+	s.Stop()
+
+	// drain anything that ran before stopping, then make sure nothing else does
+	for len(ran) > 0 {
+		<-ran
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, ran, "job should not run after Stop")
+}
+
+func TestScheduler_Stop_notStarted(t *testing.T) {
+	s := NewScheduler("test", systemClock{}, time.Minute, nil)
+	s.Stop()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/urvesh/backend-homework"
+
+// InitTracing sets up the global tracer provider and W3C trace context propagation.
+// exporterName is either "stdout" or "otlp" (endpoint read from OTEL_EXPORTER_OTLP_ENDPOINT).
+// Anything else leaves tracing as a no-op. The returned func flushes and stops the exporter.
+func InitTracing(ctx context.Context, exporterName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch exporterName {
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace exporter: %s", err)
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName("backend-homework"))
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span for every request, continuing any trace passed in
+// the traceparent header. The span is named after the route pattern, not the raw path, so
+// /users/:id/matches is grouped regardless of id.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched route"
+		}
+
+		ctx, span := otel.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("%d", status))
+		}
+	}
+}
+
+// mongoTracer keeps track of the in-flight command spans, keyed by the driver's request id
+type mongoTracer struct {
+	mu    sync.Mutex
+	spans map[int64]trace.Span
+}
+
+// newMongoMonitor returns a command monitor that records one client span per mongo command.
+// Spans are children of whatever span is in the context passed to the driver call.
+func newMongoMonitor() *event.CommandMonitor {
+	mt := &mongoTracer{
+		spans: make(map[int64]trace.Span),
+	}
+
+	return &event.CommandMonitor{
+		Started:   mt.started,
+		Succeeded: mt.succeeded,
+		Failed:    mt.failed,
+	}
+}
+
+func (mt *mongoTracer) started(ctx context.Context, evt *event.CommandStartedEvent) {
+	name := evt.CommandName
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemMongoDB,
+		semconv.DBNamespace(evt.DatabaseName),
+		semconv.DBOperationName(evt.CommandName),
+	}
+
+	// the first element of a command holds the collection it runs against
+	if coll, ok := evt.Command.Index(0).Value().StringValueOK(); ok {
+		name = fmt.Sprintf("%s %s.%s", evt.CommandName, evt.DatabaseName, coll)
+		attrs = append(attrs, semconv.DBCollectionName(coll))
+	}
+
+	_, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	mt.mu.Lock()
+	mt.spans[evt.RequestID] = span
+	mt.mu.Unlock()
+}
+
+func (mt *mongoTracer) succeeded(_ context.Context, evt *event.CommandSucceededEvent) {
+	if span := mt.pop(evt.RequestID); span != nil {
+		span.End()
+	}
+}
+
+func (mt *mongoTracer) failed(_ context.Context, evt *event.CommandFailedEvent) {
+	if span := mt.pop(evt.RequestID); span != nil {
+		span.SetStatus(codes.Error, evt.Failure)
+		span.End()
+	}
+}
+
+// pop removes and returns the span for a finished command
+func (mt *mongoTracer) pop(requestID int64) trace.Span {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	span, ok := mt.spans[requestID]
+	if !ok {
+		return nil
+	}
+	delete(mt.spans, requestID)
+
+	return span
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smtpTransport emails notifications through a mail server, upgrading to tls when the server
+// offers it
+type smtpTransport struct {
+	cfg *SMTPConfig
+}
+
+// NewSMTPTransport is a constructor for a NotificationTransport that sends email over smtp
+func NewSMTPTransport(cfg *SMTPConfig) NotificationTransport {
+	return &smtpTransport{cfg: cfg}
+}
+
+// Send gives up when ctx is done, including halfway through the conversation
+func (t *smtpTransport) Send(ctx context.Context, m *NotificationMessage) error {
+	from, err := mail.ParseAddress(t.cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %s", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.cfg.Host, t.cfg.Port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, t.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: t.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if t.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.cfg.Username, t.cfg.Password, t.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(formatEmail(from, m, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// formatEmail writes a plain text email. The subject and body can be any utf-8
+func formatEmail(from *mail.Address, m *NotificationMessage, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", (&mail.Address{Address: m.To}).String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	b.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(m.Body))
+	qp.Close()
+	b.WriteString("\r\n")
+
+	return b.Bytes()
+}
+
+// stubPushTransportSize is how many sent messages the push stub keeps
+const stubPushTransportSize = 100
+
+// StubPushTransport stands in for a push provider until one is integrated. It accepts every
+// message and keeps the last few, so the push channel can be exercised end to end
+type StubPushTransport struct {
+	mu   sync.Mutex
+	sent []*NotificationMessage
+}
+
+// NewStubPushTransport is a constructor for a StubPushTransport
+func NewStubPushTransport() *StubPushTransport {
+	return &StubPushTransport{}
+}
+
+// Send records the message
+func (t *StubPushTransport) Send(_ context.Context, m *NotificationMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = append(t.sent, m)
+	if len(t.sent) > stubPushTransportSize {
+		t.sent = t.sent[len(t.sent)-stubPushTransportSize:]
+	}
+	return nil
+}
+
+// Sent returns the messages sent, oldest first
+func (t *StubPushTransport) Sent() []*NotificationMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*NotificationMessage(nil), t.sent...)
+}
+
+// logTransport writes notifications to the log instead of sending them, for development
+type logTransport struct {
+	channel string
+}
+
+// NewLogTransport is a constructor for a NotificationTransport that logs what it would send
+// on the channel
+func NewLogTransport(channel string) NotificationTransport {
+	return &logTransport{channel: channel}
+}
+
+func (t *logTransport) Send(_ context.Context, m *NotificationMessage) error {
+	logf(levelDebug, "%s notification to user %s (%s): %s: %s", t.channel, m.UserID, maskRecipient(m.To), m.Subject, m.Body)
+	return nil
+}
+
+// maskRecipient keeps enough of an email address or push token to tell them apart in the log,
+// e.g. a***@example.com or ***9f2c
+func maskRecipient(to string) string {
+	if local, domain, ok := strings.Cut(to, "@"); ok {
+		if local == "" {
+			return "***@" + domain
+		}
+		return local[:1] + "***@" + domain
+	}
+
+	if len(to) <= 8 {
+		return "***"
+	}
+	return "***" + to[len(to)-4:]
+}
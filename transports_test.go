@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTPServer accepts one email per connection and hands over the envelope and message
+type fakeSMTPServer struct {
+	addr     string
+	received chan *fakeEmail
+}
+
+type fakeEmail struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	s := &fakeSMTPServer{addr: lis.Addr().String(), received: make(chan *fakeEmail, 1)}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	email := &fakeEmail{}
+
+	tp.PrintfLine("220 fake smtp")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-fake")
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			email.from = angleAddr(line)
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			email.to = append(email.to, angleAddr(line))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			email.data = string(data)
+			tp.PrintfLine("250 queued")
+			s.received <- email
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// angleAddr is the <address> of a MAIL or RCPT command, which can be followed by parameters
+func angleAddr(line string) string {
+	_, rest, _ := strings.Cut(line, "<")
+	addr, _, _ := strings.Cut(rest, ">")
+	return addr
+}
+
+func TestSMTPTransport(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(srv.addr)
+	transport := NewSMTPTransport(&SMTPConfig{Host: host, Port: port, From: "Homework <noreply@example.com>"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m := &NotificationMessage{Body: "Hi Zoë, Bob sent you a message.", Kind: NotifyMessage, Subject: "New message from Bob ✉", To: "zoe@example.com"}
+	assert.Nil(t, transport.Send(ctx, m))
+
+	var email *fakeEmail
+	select {
+	case email = <-srv.received:
+	case <-ctx.Done():
+		t.Fatal("no email received")
+	}
+
+	assert.Equal(t, "noreply@example.com", email.from)
+	assert.Equal(t, []string{"zoe@example.com"}, email.to)
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(email.data)))
+	assert.Nil(t, err)
+	assert.Equal(t, `"Homework" <noreply@example.com>`, msg.Header.Get("From"))
+	assert.Equal(t, "<zoe@example.com>", msg.Header.Get("To"))
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Nil(t, err)
+	assert.Equal(t, m.Subject, subject)
+
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.Nil(t, err)
+	assert.Equal(t, m.Body, strings.TrimSpace(string(body)))
+}
+
+func TestSMTPTransport_unreachable(t *testing.T) {
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	host, port, _ := net.SplitHostPort(lis.Addr().String())
+	lis.Close()
+
+	transport := NewSMTPTransport(&SMTPConfig{Host: host, Port: port, From: "noreply@example.com"})
+	err := transport.Send(context.Background(), &NotificationMessage{To: "zoe@example.com"})
+	assert.NotNil(t, err)
+}
+
+func TestStubPushTransport(t *testing.T) {
+	push := NewStubPushTransport()
+	for i := 0; i < stubPushTransportSize+5; i++ {
+		assert.Nil(t, push.Send(context.Background(), &NotificationMessage{To: fmt.Sprint(i)}))
+	}
+
+	sent := push.Sent()
+	assert.Len(t, sent, stubPushTransportSize)
+	assert.Equal(t, "5", sent[0].To, "only the last messages are kept")
+}
+
+func Test_maskRecipient(t *testing.T) {
+	assert.Equal(t, "a***@example.com", maskRecipient("ann@example.com"))
+	assert.Equal(t, "***@example.com", maskRecipient("@example.com"))
+	assert.Equal(t, "***9f2c", maskRecipient("d41d8cd98f00b204e9800998ecf89f2c"))
+	assert.Equal(t, "***", maskRecipient("short"))
+	assert.Equal(t, "***", maskRecipient(""))
+}
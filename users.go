@@ -2,31 +2,221 @@ package main
 
 import (
 	"context"
+	"regexp"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Role is what a user is allowed to do. Users without one are plain users
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// roleRanks orders roles, each role can do everything the ones below it can
+var roleRanks = map[Role]int{
+	"":            0,
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole tells if r is a known role
+func ValidRole(r Role) bool {
+	_, ok := roleRanks[r]
+	return ok && r != ""
+}
+
+// HasRole tells if the user's role is at least min
+func (u *User) HasRole(min Role) bool {
+	return roleRanks[u.Role] >= roleRanks[min]
+}
+
+// UserStatus is the state of an account. Users without one are active
+type UserStatus string
+
+const (
+	UserActive UserStatus = "active"
+	// UserHidden can use the app but isn't shown to anyone else
+	UserHidden UserStatus = "hidden"
+	// UserDeactivated is paused by the user and can be reactivated any time
+	UserDeactivated UserStatus = "deactivated"
+	// UserDeleted can be reactivated during the deletion grace period, then it's purged
+	UserDeleted   UserStatus = "deleted"
+	UserSuspended UserStatus = "suspended"
+)
+
+// ValidStatus tells if s is a known status. Empty is a user from before statuses, who is active
+func ValidStatus(s UserStatus) bool {
+	switch s {
+	case "", UserActive, UserHidden, UserDeactivated, UserDeleted, UserSuspended:
+		return true
+	}
+	return false
+}
+
 // User holds information related to the user collection
 type User struct {
-	Age         int       `json:"age,omitempty" bson:"age,omitempty"`
-	Bio         string    `json:"bio,omitempty" bson:"bio,omitempty"`
-	CreatedDate time.Time `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
-	ID          string    `json:"_id,omitempty" bson:"_id,omitempty"`
-	JobTitle    string    `json:"jobTitle,omitempty" bson:"jobTitle,omitempty"`
-	Name        string    `json:"name,omitempty" bson:"name,omitempty"`
+	Age         int        `json:"age,omitempty" bson:"age,omitempty"`
+	Bio         string     `json:"bio,omitempty" bson:"bio,omitempty"`
+	CreatedDate time.Time  `json:"createdDate,omitempty" bson:"createdDate,omitempty"`
+	DeletedDate *time.Time `json:"deletedDate,omitempty" bson:"deletedDate,omitempty"`
+	ID          DocID      `json:"_id,omitempty" bson:"_id,omitempty"`
+	JobTitle    string     `json:"jobTitle,omitempty" bson:"jobTitle,omitempty"`
+	Location    string     `json:"location,omitempty" bson:"location,omitempty"`
+	Name        string     `json:"name,omitempty" bson:"name,omitempty"`
+	// Notifications are only seen by the user, and replaced as a whole when edited
+	Notifications *NotificationPreferences `json:"notifications,omitempty" bson:"notifications,omitempty"`
+	Photos        []*Photo                 `json:"photos,omitempty" bson:"photos,omitempty"`
+	Role          Role                     `json:"role,omitempty" bson:"role,omitempty"`
+	Status        UserStatus               `json:"status,omitempty" bson:"status,omitempty"`
+}
+
+// Suspended tells if a moderator has suspended the account
+func (u *User) Suspended() bool {
+	return u.Status == UserSuspended
+}
+
+// Active tells if the account is active. Only active users are shown to others
+func (u *User) Active() bool {
+	return u.Status == "" || u.Status == UserActive
+}
+
+// Usable tells if the user can make changes. Hidden users can still use the app, suspended,
+// deactivated and deleted ones can't
+func (u *User) Usable() bool {
+	return u.Active() || u.Status == UserHidden
+}
+
+// UserSearch filters users for admins. Name and Location match case insensitive substrings
+type UserSearch struct {
+	Name     string
+	Location string
+	Role     Role
+	Status   UserStatus
+	Limit    int64
+}
+
+// query builds the mongo filter for the search
+func (s *UserSearch) query() bson.M {
+	q := bson.M{}
+	if s.Name != "" {
+		q["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(s.Name), Options: "i"}
+	}
+	if s.Location != "" {
+		q["location"] = primitive.Regex{Pattern: regexp.QuoteMeta(s.Location), Options: "i"}
+	}
+	switch s.Role {
+	case "":
+	case RoleUser:
+		q["role"] = bson.M{"$in": []interface{}{nil, RoleUser}}
+	default:
+		q["role"] = s.Role
+	}
+	switch s.Status {
+	case "":
+	case UserActive:
+		q["status"] = activeStatuses
+	default:
+		q["status"] = s.Status
+	}
+	return q
+}
+
+// SearchUsers finds users matching every set field of the search, sorted by name
+func SearchUsers(ctx context.Context, db *DB, s *UserSearch) ([]*User, error) {
+	coll := db.MongoClient.Collection("users")
+
+	opts := options.Find().SetSort(bson.M{"name": 1}).SetLimit(s.Limit)
+	cur, err := coll.Find(ctx, s.query(), opts)
+	if err != nil {
+		return nil, NewErrorf("error searching users from mongo: %s", err)
+	}
+
+	defer cur.Close(ctx)
+
+	users := make([]*User, 0)
+	for cur.Next(ctx) {
+		var u User
+		if err := cur.Decode(&u); err != nil {
+			return nil, NewErrorf("error decoding into user struct: %s", err)
+		}
+
+		users = append(users, &u)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return users, nil
+}
+
+// SetUserStatus changes the status of an account and returns the user as it was before, or
+// nil if there is no such user. Deleting an account starts its grace period, any other status
+// ends it
+func SetUserStatus(ctx context.Context, db *DB, id string, status UserStatus, reason string, now time.Time) (*User, error) {
+	coll := db.MongoClient.Collection("users")
+
+	update := bson.M{
+		"$set": bson.M{"status": status, "deletedDate": now},
+	}
+	if status != UserDeleted {
+		update = bson.M{
+			"$set":   bson.M{"status": status},
+			"$unset": bson.M{"deletedDate": ""},
+		}
+	}
+
+	var before User
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": DocID(id)}, update).Decode(&before)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, NewErrorf("error updating status of user %s: %s", id, err)
+	}
+	db.forgetUsers(ctx, id)
+
+	after := before
+	after.Status = status
+	after.DeletedDate = nil
+	if status == UserDeleted {
+		after.DeletedDate = &now
+	}
+	if err := recordChange(ctx, db, "user.status", "user", id, &before, &after, bson.M{"reason": reason}); err != nil {
+		return nil, err
+	}
+
+	if status == UserSuspended && before.Status != UserSuspended {
+		warning := newNotification(NotifyWarning, id, "", now)
+		warning.Reason = "your account was suspended"
+		if reason != "" {
+			warning.Reason += " for " + reason
+		}
+		if err := notify(ctx, db, warning); err != nil {
+			return nil, err
+		}
+	}
+
+	return &before, nil
 }
 
 // FindAllUsers returns all the existing users from the db
-func FindAllUsers(db *DB) ([]*User, error) {
+func FindAllUsers(ctx context.Context, db *DB) ([]*User, error) {
 	coll := db.MongoClient.Collection("users")
-	ctx := context.Background()
 
 	users := make([]*User, 0)
 
-	filter := bson.M{}
+	filter := visibleUsers(ctx, bson.M{})
 	cur, err := coll.Find(ctx, filter)
 
 	if err != nil {
@@ -54,15 +244,99 @@ func FindAllUsers(db *DB) ([]*User, error) {
 	return users, nil
 }
 
-// FindUserById lookup user by id
-func FindUserByID(db *DB, id string) (*User, error) {
-	coll := db.MongoClient.Collection("users")
+// InsertUsers adds new users in bulk
+func InsertUsers(ctx context.Context, db *DB, users []*User) error {
+	docs := make([]interface{}, 0, len(users))
+	for _, v := range users {
+		docs = append(docs, v)
+	}
 
-	filter := bson.M{
-		"_id": id,
+	if err := insertMany(ctx, db.MongoClient.Collection("users"), docs); err != nil {
+		return NewErrorf("error inserting users: %s", err)
+	}
+
+	return nil
+}
+
+// FindUserById lookup user by id. Users that aren't active are only found with
+// WithInactiveUsers
+func FindUserByID(ctx context.Context, db *DB, id string) (*User, error) {
+	u, err := FindAccount(ctx, db, id)
+	if err != nil || u == nil || !userVisible(ctx, u) {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindUsersByIDs looks up many users in one query, by id. Users that don't exist or aren't
+// visible are left out. Only the users that aren't cached are read from mongo
+func FindUsersByIDs(ctx context.Context, db *DB, ids []string) (map[string]*User, error) {
+	users := make(map[string]*User, len(ids))
+	missing := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if u := db.cachedUser(ctx, id); u != nil {
+			users[id] = u
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		found, err := findUsers(ctx, db, bson.M{"_id": bson.M{"$in": docIDs(missing)}})
+		if err != nil {
+			return nil, err
+		}
+		db.cacheUsers(ctx, found...)
+
+		for _, u := range found {
+			users[string(u.ID)] = u
+		}
+	}
+
+	for id, u := range users {
+		if !userVisible(ctx, u) {
+			delete(users, id)
+		}
+	}
+
+	return users, nil
+}
+
+// FindAccount looks up a user by id whatever the status of their account, for acting on
+// their own account
+func FindAccount(ctx context.Context, db *DB, id string) (*User, error) {
+	if u := db.cachedUser(ctx, id); u != nil {
+		return u, nil
+	}
+
+	u, err := findUser(ctx, db, bson.M{"_id": DocID(id)})
+	if err != nil || u == nil {
+		return nil, err
 	}
 
-	doc := coll.FindOne(context.Background(), filter)
+	db.cacheUsers(ctx, u)
+	return u, nil
+}
+
+// FindCurrentAccount is FindAccount, always read from mongo. Role and status checks use it, so
+// demoting or suspending a user takes effect at once on every instance, even where the cache
+// can't follow changes
+func FindCurrentAccount(ctx context.Context, db *DB, id string) (*User, error) {
+	u, err := findUser(ctx, db, bson.M{"_id": DocID(id)})
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	db.cacheUsers(ctx, u)
+	return u, nil
+}
+
+// findUser returns the user matching filter, or nil if there is none
+func findUser(ctx context.Context, db *DB, filter bson.M) (*User, error) {
+	coll := db.MongoClient.Collection("users")
+	id := filter["_id"]
+
+	doc := coll.FindOne(ctx, filter)
 	if doc.Err() != nil {
 		if doc.Err() == mongo.ErrNoDocuments {
 			// document not found, not an actual error
@@ -79,20 +353,50 @@ func FindUserByID(db *DB, id string) (*User, error) {
 	return &u, nil
 }
 
-// FindIncomingLikes finds all the users who have liked the given userId
-func FindIncomingLikes(db *DB, userId string) ([]*User, error) {
+// findUsers returns the users matching filter
+func findUsers(ctx context.Context, db *DB, filter bson.M) ([]*User, error) {
+	coll := db.MongoClient.Collection("users")
+
+	cur, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, NewErrorf("error finding users from mongo: %s", err)
+	}
+
+	defer cur.Close(ctx)
+
+	users := make([]*User, 0)
+	for cur.Next(ctx) {
+		var u User
+		if err := cur.Decode(&u); err != nil {
+			return nil, NewErrorf("error decoding into user struct: %s", err)
+		}
+
+		users = append(users, &u)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return users, nil
+}
+
+// FindIncomingLikes finds all the users who have liked the given userId. Higher priority
+// likes, like a SUPERLIKE, come first
+func FindIncomingLikes(ctx context.Context, db *DB, userId string) ([]*User, error) {
 	// find likes where toUserId is this user
 	p := &RatingParams{
 		Filter: &Rating{
 			ToUserID: userId,
-			Type:     LIKE,
 		},
+		Types: likeTypes(),
 	}
-	likes, err := FindRatings(db, p)
+	likes, err := FindRatings(ctx, db, p)
 	if err != nil {
 		return nil, err
 	}
 
+	likes = incomingLikes(likes)
 	users := make([]*User, 0, len(likes))
 
 	// return empty array if no likes.
@@ -100,14 +404,19 @@ func FindIncomingLikes(db *DB, userId string) ([]*User, error) {
 		return users, nil
 	}
 
-	// go through found likes and populate it with user data
+	// populate the likes with user data, all in one lookup
+	ids := make([]string, 0, len(likes))
 	for _, v := range likes {
-		u, err := FindUserByID(db, v.FromUserID)
-		if err != nil {
-			return nil, err
-		}
+		ids = append(ids, v.FromUserID)
+	}
+
+	found, err := FindUsersByIDs(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
 
-		if u != nil {
+	for _, id := range ids {
+		if u, ok := found[id]; ok {
 			users = append(users, u)
 		}
 	}
@@ -115,56 +424,123 @@ func FindIncomingLikes(db *DB, userId string) ([]*User, error) {
 	return users, nil
 }
 
-// FindMatches gets all the matches this user has
-func FindMatches(db *DB, userId string) ([]*User, error) {
-	// look up everyone this user likes
-	p := &RatingParams{
-		Filter: &Rating{
-			FromUserID: userId,
-			Type:       LIKE,
-		},
+// FindIncomingLikesOfUsers gets the likes many users got in one query, by the liked user's id,
+// ordered like FindIncomingLikes
+func FindIncomingLikesOfUsers(ctx context.Context, db *DB, userIDs []string) (map[string][]*Rating, error) {
+	likes, err := FindRatings(ctx, db, &RatingParams{ToUserIDs: userIDs, Types: likeTypes()})
+	if err != nil {
+		return nil, err
 	}
 
-	myLikes, err := FindRatings(db, p)
+	byUser := make(map[string][]*Rating, len(userIDs))
+	for _, r := range likes {
+		byUser[r.ToUserID] = append(byUser[r.ToUserID], r)
+	}
+	for id, rs := range byUser {
+		byUser[id] = incomingLikes(rs)
+	}
+
+	return byUser, nil
+}
+
+// incomingLikes orders the likes one user got, higher priority first. Someone who both liked
+// and superliked only shows up once, in the higher spot
+func incomingLikes(likes []*Rating) []*Rating {
+	sort.SliceStable(likes, func(i, j int) bool {
+		return ratingPriority(likes[i].Type) > ratingPriority(likes[j].Type)
+	})
+
+	seen := make(map[string]bool)
+	out := make([]*Rating, 0, len(likes))
+	for _, v := range likes {
+		if seen[v.FromUserID] {
+			continue
+		}
+		seen[v.FromUserID] = true
+		out = append(out, v)
+	}
+
+	return out
+}
+
+// FindFeed returns up to limit users this user hasn't rated yet. Users they passed on come back
+// once the PASS cooldown is over, and users on either side of a block never show up.
+func FindFeed(ctx context.Context, db *DB, userId string, now time.Time, limit int64) ([]*User, error) {
+	hidden := map[string]bool{userId: true}
+
+	mine, err := FindRatings(ctx, db, &RatingParams{Filter: &Rating{FromUserID: userId}})
 	if err != nil {
 		return nil, err
 	}
 
-	// find who likes this user
-	p.Filter.FromUserID = ""
-	p.Filter.ToUserID = userId
+	for _, r := range mine {
+		info, ok := LookupRatingType(r.Type)
+		if !ok || info.FeedCooldown == 0 || now.Before(r.CreatedDate.Add(info.FeedCooldown)) {
+			hidden[r.ToUserID] = true
+		}
+	}
 
-	incomingLikes, err := FindRatings(db, p)
+	// people who hid the pair from their side, e.g. blocked this user
+	theirs, err := FindRatings(ctx, db, &RatingParams{Filter: &Rating{ToUserID: userId}})
 	if err != nil {
 		return nil, err
 	}
 
-	// hold ids of outgoing likes
-	m := make(map[string]bool)
-	for _, v := range myLikes {
-		m[v.ToUserID] = true
+	for _, r := range theirs {
+		if info, ok := LookupRatingType(r.Type); ok && info.HidesPair {
+			hidden[r.FromUserID] = true
+		}
+	}
+
+	ids := make([]string, 0, len(hidden))
+	for id := range hidden {
+		ids = append(ids, id)
+	}
+
+	coll := db.MongoClient.Collection("users")
+	filter := bson.M{
+		"_id":    bson.M{"$nin": docIDs(ids)},
+		"status": activeStatuses,
+	}
+
+	cur, err := coll.Find(ctx, filter, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, NewErrorf("error finding feed for %s: %s", userId, err)
 	}
 
-	matches := make([]*User, 0)
+	defer cur.Close(ctx)
 
-	// loop through incoming likes, find common ids against outgoing likes and look up the user
-	for _, v := range incomingLikes {
-		if _, ok := m[v.FromUserID]; ok {
-			u, err := FindUserByID(db, v.FromUserID)
-			if err != nil {
-				return nil, err
-			}
-			matches = append(matches, u)
+	users := make([]*User, 0)
+	for cur.Next(ctx) {
+		var u User
+		if err := cur.Decode(&u); err != nil {
+			return nil, NewErrorf("error decoding into user struct: %s", err)
 		}
+
+		users = append(users, &u)
 	}
 
-	return matches, nil
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return users, nil
+}
+
+// Edit overrides user data with the incoming values, at now. The change and its event are
+// written in one transaction when the database supports them
+func (u *User) Edit(ctx context.Context, db *DB, now time.Time) (*User, error) {
+	var user *User
+	err := db.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = u.edit(ctx, db, now)
+		return err
+	})
+	return user, err
 }
 
-// Edit overrides user data with the incoming values
-func (u *User) Edit(db *DB) (*User, error) {
+func (u *User) edit(ctx context.Context, db *DB, now time.Time) (*User, error) {
 	coll := db.MongoClient.Collection("users")
-	ctx := context.Background()
 
 	filter := bson.M{
 		"_id": u.ID,
@@ -175,13 +551,8 @@ func (u *User) Edit(db *DB) (*User, error) {
 		"$set": u,
 	}
 
-	// update and return the updated document
-	after := options.After
-	opts := &options.FindOneAndUpdateOptions{
-		ReturnDocument: &after,
-	}
-
-	doc := coll.FindOneAndUpdate(ctx, filter, update, opts)
+	// update and keep the previous document for the audit log
+	doc := coll.FindOneAndUpdate(ctx, filter, update)
 	if doc.Err() != nil {
 		if doc.Err() == mongo.ErrNoDocuments {
 			return nil, nil
@@ -189,10 +560,24 @@ func (u *User) Edit(db *DB) (*User, error) {
 		return nil, NewErrorf("error updating user %s: %s", u.ID, doc.Err())
 	}
 
-	var user User
-	if err := doc.Decode(&user); err != nil {
+	var before User
+	if err := doc.Decode(&before); err != nil {
 		return nil, NewErrorf("error decoding user %s: %s", u.ID, err)
 	}
+	db.forgetUsers(ctx, string(u.ID))
+
+	user, err := FindAccount(ctx, db, string(u.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordChange(ctx, db, "user.edit", "user", string(u.ID), &before, user, nil); err != nil {
+		return nil, err
+	}
+
+	if err := emitEvent(ctx, db, EventUserUpdated, NewProfileView(user), now); err != nil {
+		return nil, err
+	}
 
-	return &user, nil
+	return user, nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersion presents the data types as one version of the api sees them. Handlers work with
+// the data types and leave the json shape to the version, so a new version can change what
+// clients see without touching the handlers or the database
+type APIVersion interface {
+	// Prefix is where the version's routes are mounted, e.g. /v1
+	Prefix() string
+	// Account is a user's own view of their account, also shown to moderators
+	Account(u *User) interface{}
+	Accounts(us []*User) interface{}
+	Export(e *UserExport) interface{}
+	Matches(ms []*UserMatch) interface{}
+	// Profiles are users as other users see them
+	Profiles(us []*User) interface{}
+	Ratings(rs []*Rating) interface{}
+}
+
+// apiLegacy is the api as it was before versioning, which responded with the data types as
+// they're stored. Only the deprecated unversioned routes use it
+type apiLegacy struct{}
+
+func (apiLegacy) Prefix() string                      { return "" }
+func (apiLegacy) Account(u *User) interface{}         { return u }
+func (apiLegacy) Accounts(us []*User) interface{}     { return us }
+func (apiLegacy) Export(e *UserExport) interface{}    { return e }
+func (apiLegacy) Matches(ms []*UserMatch) interface{} { return ms }
+func (apiLegacy) Profiles(us []*User) interface{}     { return us }
+func (apiLegacy) Ratings(rs []*Rating) interface{}    { return rs }
+
+// apiV1 responds with the views in dto.go
+type apiV1 struct{}
+
+func (apiV1) Prefix() string                      { return "/v1" }
+func (apiV1) Account(u *User) interface{}         { return NewAccountView(u) }
+func (apiV1) Accounts(us []*User) interface{}     { return NewAccountViews(us) }
+func (apiV1) Export(e *UserExport) interface{}    { return NewExportView(e) }
+func (apiV1) Matches(ms []*UserMatch) interface{} { return NewUserMatchViews(ms) }
+func (apiV1) Profiles(us []*User) interface{}     { return NewProfileViews(us) }
+func (apiV1) Ratings(rs []*Rating) interface{}    { return NewRatingViews(rs) }
+
+// apiVersions are every version served, oldest first
+var apiVersions = []APIVersion{apiV1{}}
+
+// useVersion makes handlers in a route group present their responses as the given version
+func useVersion(v APIVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("apiVersion", v)
+		c.Next()
+	}
+}
+
+// version is the api version the request came in on. Handlers called outside of a version's
+// route group get the latest one
+func version(c *gin.Context) APIVersion {
+	if v, ok := c.Get("apiVersion"); ok {
+		return v.(APIVersion)
+	}
+	return apiVersions[len(apiVersions)-1]
+}
+
+// deprecatedRoutes marks responses from routes that are going away with Deprecation and
+// Sunset headers, and links to the same route in the version that replaces them
+func deprecatedRoutes(successor APIVersion, sunset time.Time) gin.HandlerFunc {
+	sunsetHeader := sunset.UTC().Format(http.TimeFormat)
+
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", sunsetHeader)
+		c.Header("Link", "<"+successor.Prefix()+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
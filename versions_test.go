@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecatedRoutes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features.RateLimiting = false
+	router := setupRouter(&appContext{Config: cfg})
+
+	// an invalid limit is rejected before the database is needed
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/abc/feed?limit=0", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+	assert.Equal(t, `</v1/users/abc/feed>; rel="successor-version"`, w.Header().Get("Link"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/users/abc/feed?limit=0", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+	assert.Empty(t, w.Header().Get("Sunset"))
+}
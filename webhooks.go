@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// webhookSignatureHeader signs every delivery as "t=<unix time>,v1=<hex hmac>". The hmac is
+	// sha256, keyed with the webhook's secret, of "<unix time>.<body>"
+	webhookSignatureHeader = "X-Webhook-Signature"
+	// webhookEventHeader and webhookEventIDHeader name the event delivered. Retries and replays
+	// send the same id again, so receivers can drop the ones they've seen
+	webhookEventHeader   = "X-Webhook-Event"
+	webhookEventIDHeader = "X-Webhook-Event-Id"
+
+	// webhookWorkers is how many deliveries are sent at once
+	webhookWorkers = 8
+	// webhookFanOutBatch is how many events are handed to webhooks at a time
+	webhookFanOutBatch = 500
+)
+
+// Webhook is a url that events are delivered to
+type Webhook struct {
+	CreatedDate time.Time `json:"createdDate" bson:"createdDate"`
+	// Events are the event types delivered to the webhook, every type if empty
+	Events []string `json:"events,omitempty" bson:"events,omitempty"`
+	ID     DocID    `json:"id" bson:"_id"`
+	// Secret signs deliveries. It's only shown once, when the webhook is registered
+	Secret string `json:"-" bson:"secret"`
+	URL    string `json:"url" bson:"url"`
+}
+
+// Wants tells if events of the type are delivered to the webhook
+func (w *Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, t := range w.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookRequest is the body of a webhook registration
+type WebhookRequest struct {
+	Events []string `json:"events"`
+	URL    string   `json:"url"`
+}
+
+// Webhook checks the request and makes a webhook with a new secret
+func (req *WebhookRequest) Webhook(now time.Time) (*Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("url must be an absolute http or https url")
+	}
+
+	known := make(map[string]bool)
+	for _, t := range EventTypes() {
+		known[t] = true
+	}
+	for _, t := range req.Events {
+		if !known[t] {
+			return nil, NewErrorf("events must be some of %s", strings.Join(EventTypes(), ", "))
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, NewErrorf("error making a webhook secret: %s", err)
+	}
+
+	return &Webhook{
+		CreatedDate: now,
+		Events:      req.Events,
+		ID:          NewDocID(),
+		Secret:      hex.EncodeToString(secret),
+		URL:         req.URL,
+	}, nil
+}
+
+// DeliveryStatus is where a delivery of an event to a webhook is at
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryDead gave up after the most attempts. It's only sent again if it's replayed
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// Delivery is an event to send to a webhook. Each pair only ever has one
+type Delivery struct {
+	Attempts      int        `json:"attempts" bson:"attempts"`
+	CreatedDate   time.Time  `json:"createdDate" bson:"createdDate"`
+	DeliveredDate *time.Time `json:"deliveredDate,omitempty" bson:"deliveredDate,omitempty"`
+	EventID       DocID      `json:"eventId" bson:"eventId"`
+	EventType     string     `json:"eventType" bson:"eventType"`
+	ID            DocID      `json:"id" bson:"_id"`
+	LastError     string     `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	// NextAttemptDate is when a pending delivery is due
+	NextAttemptDate time.Time      `json:"nextAttemptDate" bson:"nextAttemptDate"`
+	Status          DeliveryStatus `json:"status" bson:"status"`
+	WebhookID       DocID          `json:"webhookId" bson:"webhookId"`
+}
+
+// queueDelivery is the write that makes the delivery of an event to a webhook due now,
+// creating it or sending it again from the first attempt
+func queueDelivery(e *Event, webhookID DocID, now time.Time) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"eventId": e.ID, "webhookId": webhookID}).
+		SetUpdate(bson.M{
+			"$set":         bson.M{"attempts": 0, "nextAttemptDate": now, "status": DeliveryPending},
+			"$setOnInsert": bson.M{"_id": NewDocID(), "createdDate": now, "eventType": e.Type},
+			"$unset":       bson.M{"deliveredDate": "", "lastError": ""},
+		}).
+		SetUpsert(true)
+}
+
+// writeDeliveries runs delivery writes. Another instance queueing the same delivery at the
+// same time is no error
+func writeDeliveries(ctx context.Context, db *DB, models []mongo.WriteModel) error {
+	if len(models) == 0 {
+		return nil
+	}
+
+	_, err := db.MongoClient.Collection("deliveries").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !onlyDuplicateKeyErrors(err) {
+		return NewErrorf("error queueing deliveries: %s", err)
+	}
+	return nil
+}
+
+// onlyDuplicateKeyErrors tells if every write of a bulk write that failed hit a unique index
+func onlyDuplicateKeyErrors(err error) bool {
+	e, ok := err.(mongo.BulkWriteException)
+	if !ok || e.WriteConcernError != nil {
+		return false
+	}
+	for _, we := range e.WriteErrors {
+		if we.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// backoff is how long to wait after a failed attempt: base, doubling with every attempt up to max
+func backoff(base, max time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// signWebhook signs a delivery body sent at t
+func signWebhook(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t.Unix())
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifyWebhookSignature checks a delivery's signature header, for receivers written in Go.
+// Signatures older than tolerance are turned down, so recorded deliveries can't be replayed
+func VerifyWebhookSignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts int64
+	var sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts, _ = strconv.ParseInt(v, 10, 64)
+		case "v1":
+			sig = v
+		}
+	}
+
+	if ts == 0 || sig == "" {
+		return errors.New("malformed signature")
+	}
+
+	t := time.Unix(ts, 0)
+	if now.Sub(t) > tolerance || t.Sub(now) > tolerance {
+		return errors.New("signature is too old")
+	}
+
+	if !hmac.Equal([]byte(signWebhook(secret, t, body)), []byte(fmt.Sprintf("t=%d,v1=%s", ts, sig))) {
+		return errors.New("signature doesn't match")
+	}
+	return nil
+}
+
+// WebhookDispatcher delivers the events in the outbox to the webhooks that want them. Any
+// number of instances can run at once: deliveries are claimed before they're sent
+type WebhookDispatcher struct {
+	Client *http.Client
+	// Clock is read for each claim and send, a run can take a while
+	Clock  Clock
+	Config *WebhooksConfig
+	DB     *DB
+}
+
+// NewWebhookDispatcher is a constructor for a WebhookDispatcher
+func NewWebhookDispatcher(db *DB, cfg *WebhooksConfig, clock Clock) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Client: &http.Client{Timeout: cfg.Timeout},
+		Clock:  clock,
+		Config: cfg,
+		DB:     db,
+	}
+}
+
+// Run hands pending events to their webhooks, then sends every delivery that's due
+func (d *WebhookDispatcher) Run(ctx context.Context, now time.Time) error {
+	if err := d.fanOut(ctx, now); err != nil {
+		return err
+	}
+	return d.deliverDue(ctx)
+}
+
+// fanOut queues a delivery of every pending event to each webhook that wants it. Webhooks
+// registered later don't get earlier events unless they're replayed
+func (d *WebhookDispatcher) fanOut(ctx context.Context, now time.Time) error {
+	webhooks, err := FindWebhooks(ctx, d.DB)
+	if err != nil {
+		return err
+	}
+
+	for {
+		events, err := FindPendingEvents(ctx, d.DB, webhookFanOutBatch)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		models := make([]mongo.WriteModel, 0)
+		ids := make([]DocID, 0, len(events))
+		for _, e := range events {
+			for _, w := range webhooks {
+				if w.Wants(e.Type) {
+					models = append(models, queueDelivery(e, w.ID, now))
+				}
+			}
+			ids = append(ids, e.ID)
+		}
+
+		if err := writeDeliveries(ctx, d.DB, models); err != nil {
+			return err
+		}
+		if err := MarkEventsDispatched(ctx, d.DB, ids); err != nil {
+			return err
+		}
+	}
+}
+
+// deliverDue sends the deliveries that are due, a few at a time, until none are left
+func (d *WebhookDispatcher) deliverDue(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, webhookWorkers)
+
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				delivery, err := d.claim(ctx, d.Clock.Now())
+				if err != nil {
+					errs <- err
+					return
+				}
+				if delivery == nil {
+					return
+				}
+
+				if err := d.deliver(ctx, delivery); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// claim takes the next due delivery, pushing it back until the send it's claimed for is over,
+// so other workers and instances leave it alone. Returns nil if none are due
+func (d *WebhookDispatcher) claim(ctx context.Context, now time.Time) (*Delivery, error) {
+	var delivery Delivery
+	err := d.DB.MongoClient.Collection("deliveries").FindOneAndUpdate(ctx,
+		bson.M{"status": DeliveryPending, "nextAttemptDate": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptDate": now.Add(2 * d.Config.Timeout)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptDate": 1}),
+	).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewErrorf("error claiming a delivery: %s", err)
+	}
+	return &delivery, nil
+}
+
+// deliver sends a claimed delivery and records how it went. It's signed when it's sent, and
+// retried counting from when the attempt ended
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *Delivery) error {
+	event, err := FindEventByID(ctx, d.DB, delivery.EventID)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := FindWebhookByID(ctx, d.DB, delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	switch {
+	case event == nil:
+		sendErr = errors.New("event no longer exists")
+		delivery.Attempts = d.Config.MaxAttempts - 1
+	case webhook == nil:
+		sendErr = errors.New("webhook was removed")
+		delivery.Attempts = d.Config.MaxAttempts - 1
+	default:
+		sendErr = d.Send(ctx, webhook, event, d.Clock.Now())
+	}
+
+	return d.record(ctx, delivery, sendErr, d.Clock.Now())
+}
+
+// Send posts an event to a webhook, signed with its secret. Any status other than 2xx fails
+func (d *WebhookDispatcher) Send(ctx context.Context, w *Webhook, e *Event, now time.Time) error {
+	body, err := e.Body()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, e.Type)
+	req.Header.Set(webhookEventIDHeader, string(e.ID))
+	req.Header.Set(webhookSignatureHeader, signWebhook(w.Secret, now, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// record saves how an attempt went. Failed deliveries are retried with backoff, until the
+// last attempt which leaves them dead
+func (d *WebhookDispatcher) record(ctx context.Context, delivery *Delivery, sendErr error, now time.Time) error {
+	set := nextDeliveryState(delivery, sendErr, d.Config, now)
+
+	_, err := d.DB.MongoClient.Collection("deliveries").UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": set})
+	if err != nil {
+		return NewErrorf("error recording delivery %s: %s", delivery.ID, err)
+	}
+
+	if delivery.Status == DeliveryDead {
+		logf(levelWarn, "gave up delivering event %s to webhook %s: %s", delivery.EventID, delivery.WebhookID, sendErr)
+	}
+	return nil
+}
+
+// nextDeliveryState moves a delivery on after an attempt, returning the fields that changed
+func nextDeliveryState(delivery *Delivery, sendErr error, cfg *WebhooksConfig, now time.Time) bson.M {
+	delivery.Attempts++
+	set := bson.M{"attempts": delivery.Attempts}
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredDate = &now
+		set["deliveredDate"] = now
+	case delivery.Attempts >= cfg.MaxAttempts:
+		delivery.Status = DeliveryDead
+		delivery.LastError = sendErr.Error()
+		set["lastError"] = delivery.LastError
+	default:
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptDate = now.Add(backoff(cfg.Backoff, cfg.MaxBackoff, delivery.Attempts))
+		set["lastError"] = delivery.LastError
+		set["nextAttemptDate"] = delivery.NextAttemptDate
+	}
+
+	set["status"] = delivery.Status
+	return set
+}
+
+// FindWebhooks returns every registered webhook, oldest first
+func FindWebhooks(ctx context.Context, db *DB) ([]*Webhook, error) {
+	cur, err := db.MongoClient.Collection("webhooks").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, NewErrorf("error finding webhooks from mongo: %s", err)
+	}
+	defer cur.Close(ctx)
+
+	webhooks := make([]*Webhook, 0)
+	for cur.Next(ctx) {
+		var w Webhook
+		if err := cur.Decode(&w); err != nil {
+			return nil, NewErrorf("error decoding into webhook struct: %s", err)
+		}
+		webhooks = append(webhooks, &w)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return webhooks, nil
+}
+
+// FindWebhookByID returns nil if there's no such webhook
+func FindWebhookByID(ctx context.Context, db *DB, id DocID) (*Webhook, error) {
+	var w Webhook
+	err := db.MongoClient.Collection("webhooks").FindOne(ctx, bson.M{"_id": id}).Decode(&w)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewErrorf("error finding webhook %s: %s", id, err)
+	}
+	return &w, nil
+}
+
+// CreateWebhook registers a webhook. Its secret is left out of the audit log
+func CreateWebhook(ctx context.Context, db *DB, w *Webhook) error {
+	if _, err := db.MongoClient.Collection("webhooks").InsertOne(ctx, w); err != nil {
+		return NewErrorf("error creating webhook: %s", err)
+	}
+
+	audited := *w
+	audited.Secret = ""
+	return recordChange(ctx, db, "webhook.create", "webhook", string(w.ID), nil, &audited, nil)
+}
+
+// DeleteWebhook removes a webhook and the deliveries still due to it. Returns false if there
+// was no such webhook
+func DeleteWebhook(ctx context.Context, db *DB, id DocID) (bool, error) {
+	var before Webhook
+	err := db.MongoClient.Collection("webhooks").FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, NewErrorf("error deleting webhook %s: %s", id, err)
+	}
+
+	if _, err := db.MongoClient.Collection("deliveries").DeleteMany(ctx, bson.M{"webhookId": id, "status": DeliveryPending}); err != nil {
+		return false, NewErrorf("error deleting deliveries of webhook %s: %s", id, err)
+	}
+
+	before.Secret = ""
+	return true, recordChange(ctx, db, "webhook.delete", "webhook", string(id), &before, nil, nil)
+}
+
+// FindDeliveries returns a webhook's deliveries, newest first, optionally only of a status
+func FindDeliveries(ctx context.Context, db *DB, webhookID DocID, status DeliveryStatus, limit int64) ([]*Delivery, error) {
+	filter := bson.M{"webhookId": webhookID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit)
+	cur, err := db.MongoClient.Collection("deliveries").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, NewErrorf("error finding deliveries from mongo: %s", err)
+	}
+	defer cur.Close(ctx)
+
+	deliveries := make([]*Delivery, 0)
+	for cur.Next(ctx) {
+		var d Delivery
+		if err := cur.Decode(&d); err != nil {
+			return nil, NewErrorf("error decoding into delivery struct: %s", err)
+		}
+		deliveries = append(deliveries, &d)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, NewErrorf("mongo error: %s", err)
+	}
+
+	return deliveries, nil
+}
+
+// ReplayDeadDeliveries sends a webhook's dead deliveries again, from the first attempt.
+// Returns how many were queued
+func ReplayDeadDeliveries(ctx context.Context, db *DB, webhookID DocID, now time.Time) (int, error) {
+	res, err := db.MongoClient.Collection("deliveries").UpdateMany(ctx,
+		bson.M{"webhookId": webhookID, "status": DeliveryDead},
+		bson.M{
+			"$set":   bson.M{"attempts": 0, "nextAttemptDate": now, "status": DeliveryPending},
+			"$unset": bson.M{"lastError": ""},
+		},
+	)
+	if err != nil {
+		return 0, NewErrorf("error replaying deliveries of webhook %s: %s", webhookID, err)
+	}
+	return int(res.ModifiedCount), nil
+}
+
+// ReplayEvents sends every event the webhook wants that happened in [from, to) again, whether
+// it was delivered before, failed or happened before the webhook was registered. Events are
+// read from a cursor and queued insertBatchSize at a time. Returns how many were queued
+func ReplayEvents(ctx context.Context, db *DB, w *Webhook, p *DumpParams, now time.Time) (int, error) {
+	query := p.query()
+	if len(w.Events) > 0 {
+		query["type"] = bson.M{"$in": w.Events}
+	}
+
+	cur, err := db.MongoClient.Collection("outbox").Find(ctx, query, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, NewErrorf("error finding events from mongo: %s", err)
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	models := make([]mongo.WriteModel, 0, insertBatchSize)
+	for cur.Next(ctx) {
+		var e Event
+		if err := cur.Decode(&e); err != nil {
+			return n, NewErrorf("error decoding into event struct: %s", err)
+		}
+
+		models = append(models, queueDelivery(&e, w.ID, now))
+		if len(models) == insertBatchSize {
+			if err := writeDeliveries(ctx, db, models); err != nil {
+				return n, err
+			}
+			n += len(models)
+			models = models[:0]
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return n, NewErrorf("mongo error: %s", err)
+	}
+
+	if err := writeDeliveries(ctx, db, models); err != nil {
+		return n, err
+	}
+	return n + len(models), nil
+}
+
+// webhookDispatchJob delivers events to webhooks on a schedule
+func webhookDispatchJob(d *WebhookDispatcher) func(context.Context, time.Time) error {
+	return d.Run
+}
+
+// every registered webhook, without their secrets
+func (app *appContext) adminWebhooks(c *gin.Context) {
+	webhooks, err := FindWebhooks(c.Request.Context(), app.DB)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": webhooks,
+	})
+	return
+}
+
+// register a webhook. The response has its secret, which isn't shown again
+func (app *appContext) adminCreateWebhook(c *gin.Context) {
+	var body WebhookRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		errorResponse(c, http.StatusBadRequest, NewErrorf("invalid request body: %s", err))
+		return
+	}
+
+	w, err := body.Webhook(app.Clock.Now())
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := CreateWebhook(c.Request.Context(), app.DB, w); err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data": &struct {
+			*Webhook
+			Secret string `json:"secret"`
+		}{w, w.Secret},
+	})
+	return
+}
+
+// remove a webhook. Deliveries still due to it are dropped
+func (app *appContext) adminDeleteWebhook(c *gin.Context) {
+	ok, err := DeleteWebhook(c.Request.Context(), app.DB, DocID(c.Param("webhookId")))
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !ok {
+		errorResponse(c, http.StatusNotFound, errors.New("webhook not found"))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+	return
+}
+
+// a webhook's deliveries, newest first, by status
+func (app *appContext) adminWebhookDeliveries(c *gin.Context) {
+	status := DeliveryStatus(c.Query("status"))
+	switch status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryDead:
+	default:
+		errorResponse(c, http.StatusBadRequest, errors.New("status must be one of pending, delivered, dead"))
+		return
+	}
+
+	limit := int64(100)
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > 1000 {
+			errorResponse(c, http.StatusBadRequest, errors.New("limit must be a number from 1 to 1000"))
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := FindDeliveries(c.Request.Context(), app.DB, DocID(c.Param("webhookId")), status, limit)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": deliveries,
+	})
+	return
+}
+
+// send events to a webhook again: every event it wants between from and to, or without them
+// its dead deliveries
+func (app *appContext) adminReplayWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	w, err := FindWebhookByID(ctx, app.DB, DocID(c.Param("webhookId")))
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+	if w == nil {
+		errorResponse(c, http.StatusNotFound, errors.New("webhook not found"))
+		return
+	}
+
+	p := &DumpParams{}
+	if err := p.bindDates(c); err != nil {
+		errorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	now := app.Clock.Now()
+	var n int
+	if p.From.IsZero() && p.To.IsZero() {
+		n, err = ReplayDeadDeliveries(ctx, app.DB, w.ID, now)
+	} else {
+		n, err = ReplayEvents(ctx, app.DB, w, p, now)
+	}
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := recordChange(ctx, app.DB, "webhook.replay", "webhook", string(w.ID), nil, nil, bson.M{"queued": n}); err != nil {
+		errorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{"queued": n},
+	})
+	return
+}
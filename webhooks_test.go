@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWebhookDispatcher_Send(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	w := &Webhook{ID: "w1", Secret: "s3cret"}
+	e, err := newEvent("like.created", NewRatingView(&Rating{FromUserID: "a", ID: "r1", ToUserID: "b", Type: LIKE}), now)
+	assert.Nil(t, err)
+
+	status := http.StatusNoContent
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req
+		body, _ = io.ReadAll(req.Body)
+		rw.WriteHeader(status)
+	}))
+	defer srv.Close()
+	w.URL = srv.URL
+
+	d := NewWebhookDispatcher(nil, &WebhooksConfig{Timeout: time.Second}, &fakeClock{now: now})
+	assert.Nil(t, d.Send(context.Background(), w, e, now))
+
+	want, _ := e.Body()
+	assert.JSONEq(t, string(want), string(body))
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.Equal(t, "like.created", got.Header.Get(webhookEventHeader))
+	assert.Equal(t, string(e.ID), got.Header.Get(webhookEventIDHeader))
+
+	// receivers check the signature with the secret, and turn down old or changed deliveries
+	sig := got.Header.Get(webhookSignatureHeader)
+	assert.Nil(t, VerifyWebhookSignature("s3cret", sig, body, now.Add(time.Minute), 5*time.Minute))
+	assert.EqualError(t, VerifyWebhookSignature("s3cret", sig, body, now.Add(time.Hour), 5*time.Minute), "signature is too old")
+	assert.EqualError(t, VerifyWebhookSignature("other", sig, body, now, 5*time.Minute), "signature doesn't match")
+	assert.EqualError(t, VerifyWebhookSignature("s3cret", sig, append(body, ' '), now, 5*time.Minute), "signature doesn't match")
+	assert.EqualError(t, VerifyWebhookSignature("s3cret", "v1=abc", body, now, 5*time.Minute), "malformed signature")
+
+	status = http.StatusInternalServerError
+	assert.EqualError(t, d.Send(context.Background(), w, e, now), "webhook responded with 500")
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, backoff(30*time.Second, time.Hour, 1))
+	assert.Equal(t, time.Minute, backoff(30*time.Second, time.Hour, 2))
+	assert.Equal(t, 4*time.Minute, backoff(30*time.Second, time.Hour, 4))
+	assert.Equal(t, time.Hour, backoff(30*time.Second, time.Hour, 9))
+	assert.Equal(t, time.Hour, backoff(30*time.Second, time.Hour, 1000))
+}
+
+func TestNextDeliveryState(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cfg := &WebhooksConfig{Backoff: time.Minute, MaxAttempts: 3, MaxBackoff: time.Hour}
+	failed := errors.New("connection refused")
+
+	d := &Delivery{Status: DeliveryPending}
+	assert.Equal(t, bson.M{
+		"attempts":        1,
+		"lastError":       "connection refused",
+		"nextAttemptDate": now.Add(time.Minute),
+		"status":          DeliveryPending,
+	}, nextDeliveryState(d, failed, cfg, now))
+
+	assert.Equal(t, now.Add(2*time.Minute), nextDeliveryState(d, failed, cfg, now)["nextAttemptDate"])
+
+	// the last attempt failing leaves the delivery dead
+	assert.Equal(t, bson.M{
+		"attempts":  3,
+		"lastError": "connection refused",
+		"status":    DeliveryDead,
+	}, nextDeliveryState(d, failed, cfg, now))
+	assert.Equal(t, DeliveryDead, d.Status)
+
+	d = &Delivery{Attempts: 1, Status: DeliveryPending}
+	assert.Equal(t, bson.M{
+		"attempts":      2,
+		"deliveredDate": now,
+		"status":        DeliveryDelivered,
+	}, nextDeliveryState(d, nil, cfg, now))
+}
+
+func TestWebhookRequest_Webhook(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	w, err := (&WebhookRequest{Events: []string{EventMatchCreated}, URL: "https://example.com/hooks"}).Webhook(now)
+	assert.Nil(t, err)
+	assert.Len(t, w.Secret, 64)
+	assert.Equal(t, now, w.CreatedDate)
+	assert.True(t, w.Wants(EventMatchCreated))
+	assert.False(t, w.Wants(EventUserUpdated))
+
+	// no events is every event
+	w, err = (&WebhookRequest{URL: "http://localhost:9000"}).Webhook(now)
+	assert.Nil(t, err)
+	assert.True(t, w.Wants(EventUserUpdated))
+
+	_, err = (&WebhookRequest{URL: "/hooks"}).Webhook(now)
+	assert.EqualError(t, err, "url must be an absolute http or https url")
+
+	_, err = (&WebhookRequest{URL: "ftp://example.com"}).Webhook(now)
+	assert.EqualError(t, err, "url must be an absolute http or https url")
+
+	_, err = (&WebhookRequest{Events: []string{"user.deleted"}, URL: "https://example.com"}).Webhook(now)
+	assert.EqualError(t, err, "events must be some of like.created, block.created, report.created, match.created, match.ended, user.updated")
+}